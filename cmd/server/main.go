@@ -2,6 +2,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
@@ -17,8 +18,10 @@ var (
 func main() {
 	printBuildInfo()
 
-	srv, err := server.NewServer()
-	if err != nil {
+	srv, err := server.NewServer(server.WithBuildInfo(buildVersion, buildDate, buildCommit))
+	if errors.Is(err, server.ErrConfigDumped) {
+		return
+	} else if err != nil {
 		log.Fatal(fmt.Errorf("server.NewServer: %w", err))
 	}
 
@@ -3,6 +3,7 @@ package agent
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"os"
 	"os/signal"
@@ -24,6 +25,7 @@ type Agent struct {
 	serverAddr     string           // ServerAddr is the address of the server.
 	pollInterval   time.Duration    // PollInterval is the interval at which metrics are collected.
 	reportInterval time.Duration    // ReportInterval is the interval at which metrics are reported.
+	once           bool             // Once is whether to collect and report metrics a single time.
 }
 
 // NewAgent creates a new agent instance.
@@ -38,32 +40,78 @@ func NewAgent() (*Agent, error) {
 		return nil, fmt.Errorf("logger.NewZapLogger: %w", err)
 	}
 
+	if cfg.configFileMissing {
+		log.Sugar().Infof("Config file %s not found, using flags and environment variables", cfg.ConfigFile)
+	}
+
+	if cfg.pollExceedsReport {
+		log.Warn("Poll interval exceeds report interval, some reports repeat the values collected earlier",
+			zap.Int("poll_interval", cfg.PollInterval),
+			zap.Int("report_interval", cfg.ReportInterval),
+		)
+	}
+
 	publicKey, err := cryptutils.LoadRSAPublicKey(cfg.CryptoKey)
 	if err != nil {
 		return nil, fmt.Errorf("cryptutils.LoadRSAPublicKey: %w", err)
 	}
 
+	var signPrivKey ed25519.PrivateKey
+
+	if cfg.SignPrivateKey != "" {
+		signPrivKey, err = cryptutils.LoadEd25519PrivateKey(cfg.SignPrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("cryptutils.LoadEd25519PrivateKey: %w", err)
+		}
+	}
+
+	pollJitter, err := time.ParseDuration(cfg.PollJitter)
+	if err != nil || pollJitter < 0 {
+		return nil, fmt.Errorf("invalid poll jitter: %q", cfg.PollJitter)
+	}
+
 	mon := monitor.NewMonitor(
 		monitor.WithLogger(log),
 		monitor.WithServerAddr(cfg.ServerAddr),
+		monitor.WithUpdatesPath(cfg.UpdatesPath),
 		monitor.WithSignKey([]byte(cfg.SignKey)),
+		monitor.WithSignPrivateKey(signPrivKey),
 		monitor.WithCryptoPubKey(publicKey),
 		monitor.WithPollInterval(time.Duration(cfg.PollInterval)*time.Second),
+		monitor.WithPollJitter(pollJitter),
+		monitor.WithGopsutilInterval(time.Duration(cfg.GopsutilInterval)*time.Second),
 		monitor.WithReportInterval(time.Duration(cfg.ReportInterval)*time.Second),
 		monitor.WithRateLimit(cfg.RateLimit),
+		monitor.WithHTTP2(cfg.HTTP2),
+		monitor.WithCompression(cfg.Compression),
+		monitor.WithSelfMetricsPrefix(cfg.SelfPrefix),
+		monitor.WithFailureThreshold(cfg.FailureThreshold),
+		monitor.WithDropFailingMetrics(cfg.DropFailingMetrics),
 	)
 
 	return &Agent{
 		serverAddr:     cfg.ServerAddr,
 		pollInterval:   time.Duration(cfg.PollInterval) * time.Second,
 		reportInterval: time.Duration(cfg.ReportInterval) * time.Second,
+		once:           cfg.Once,
 		log:            log,
 		monitor:        mon,
 	}, nil
 }
 
 // Start starts the agent intance.
+//
+// In the one-shot mode the agent collects and reports the metrics
+// a single time and returns.
 func (a *Agent) Start() error {
+	if a.once {
+		a.log.Sugar().Infof("Reporting metrics once to server endpoint '%s'", a.serverAddr)
+
+		a.monitor.RunOnce()
+
+		return nil
+	}
+
 	a.log.Sugar().Infof("Starting agent with server endpoint '%s'", a.serverAddr)
 	a.log.Sugar().Infof("Polling interval: %s", a.pollInterval)
 	a.log.Sugar().Infof("Reporting interval: %s", a.reportInterval)
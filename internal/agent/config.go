@@ -2,41 +2,91 @@ package agent
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"reflect"
 	"strings"
 
 	"github.com/caarlos0/env"
+
+	"github.com/andymarkow/go-metrics-collector/internal/httpclient"
+	"github.com/andymarkow/go-metrics-collector/internal/monitor"
 )
 
 // config represents the agent configuration.
 //
 //nolint:tagalign,tagliatelle
 type config struct {
-	ConfigFile     string `env:"CONFIG" json:"config"`
-	ServerAddr     string `env:"ADDRESS" json:"address"`
-	LogLevel       string `env:"LOG_LEVEL" json:"log_level"`
-	SignKey        string `env:"KEY" json:"key"`
-	CryptoKey      string `env:"CRYPTO_KEY" json:"crypto_key"`
-	PollInterval   int    `env:"POLL_INTERVAL" json:"poll_interval"`
-	ReportInterval int    `env:"REPORT_INTERVAL" json:"report_interval"`
-	RateLimit      int    `env:"RATE_LIMIT" json:"rate_limit"`
+	ConfigFile      string `env:"CONFIG" json:"config"`
+	ServerAddr      string `env:"ADDRESS" json:"address"`
+	UpdatesPath     string `env:"UPDATES_PATH" json:"updates_path"`
+	LogLevel        string `env:"LOG_LEVEL" json:"log_level"`
+	SignKey         string `env:"KEY" json:"key"`
+	SignPrivateKey  string `env:"SIGN_PRIVATE_KEY" json:"sign_private_key"`
+	CryptoKey       string `env:"CRYPTO_KEY" json:"crypto_key"`
+	PollInterval    int    `env:"POLL_INTERVAL" json:"poll_interval"`
+	PollJitter      string `env:"POLL_JITTER" json:"poll_jitter"`
+	ReportInterval  int    `env:"REPORT_INTERVAL" json:"report_interval"`
+	RateLimit       int    `env:"RATE_LIMIT" json:"rate_limit"`
+	SelfPrefix      string `env:"SELF_METRICS_PREFIX" json:"self_metrics_prefix"`
+	Compression     string `env:"COMPRESSION" json:"compression"`
+	HTTP2           bool   `env:"HTTP2" json:"http2"`
+	Once            bool   `env:"ONCE" json:"once"`
+	StrictIntervals bool   `env:"STRICT_INTERVALS" json:"strict_intervals"`
+
+	GopsutilInterval   int  `env:"GOPSUTIL_INTERVAL" json:"gopsutil_interval"`
+	FailureThreshold   int  `env:"GOPSUTIL_FAILURE_THRESHOLD" json:"gopsutil_failure_threshold"`
+	DropFailingMetrics bool `env:"DROP_FAILING_METRICS" json:"drop_failing_metrics"`
+
+	// configFileMissing is set when the config file does not exist.
+	configFileMissing bool
+
+	// pollExceedsReport is set when the poll interval exceeds the report
+	// interval, so that some reports repeat the stale values.
+	pollExceedsReport bool
 }
 
 // newConfig creates a new config for agent.
 func newConfig() (config, error) {
+	return parseConfig(flag.CommandLine, os.Args[1:])
+}
+
+// parseConfig populates the config from the given flag set and arguments,
+// environment variables and the config file.
+//
+// The log level and the rate limit have the long -log-level and -rate-limit
+// aliases, as the short -l flag is the log level of the server but the rate
+// limit of the agent.
+func parseConfig(fs *flag.FlagSet, args []string) (config, error) {
 	cfg := config{}
 
-	flag.StringVar(&cfg.ConfigFile, "c", "./config/agent.json", "path to config file [env:CONFIG]")
-	flag.StringVar(&cfg.ServerAddr, "a", "", "server endpoint address [env:ADDRESS]")
-	flag.StringVar(&cfg.LogLevel, "lv", "", "log output level [env:LOG_LEVEL]")
-	flag.StringVar(&cfg.SignKey, "k", "", "signing key [env:KEY]")
-	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "path to RSA public key file to encrypt messages to Server [env:CRYPTO_KEY]")
-	flag.IntVar(&cfg.PollInterval, "p", 0, "poll interval in seconds [env:POLL_INTERVAL]")
-	flag.IntVar(&cfg.ReportInterval, "r", 0, "report interval in seconds [env:REPORT_INTERVAL]")
-	flag.IntVar(&cfg.RateLimit, "l", 0, "the number of simultaneous outgoing requests to the server [env:RATE_LIMIT]")
-	flag.Parse()
+	fs.StringVar(&cfg.ConfigFile, "c", "./config/agent.json", "comma-separated list of config files merged in order [env:CONFIG]")
+	fs.StringVar(&cfg.ServerAddr, "a", "", "server endpoint address or unix:/path/to.sock Unix domain socket [env:ADDRESS]")
+	fs.StringVar(&cfg.UpdatesPath, "updates-path", "", "server batch updates endpoint path [env:UPDATES_PATH]")
+	fs.StringVar(&cfg.LogLevel, "lv", "", "log output level [env:LOG_LEVEL]")
+	fs.StringVar(&cfg.LogLevel, "log-level", "", "log output level; alias of -lv [env:LOG_LEVEL]")
+	fs.StringVar(&cfg.SignKey, "k", "", "signing key [env:KEY]")
+	fs.StringVar(&cfg.SignPrivateKey, "sign-private-key", "", "path to ed25519 private key file to sign messages with instead of the signing key [env:SIGN_PRIVATE_KEY]")
+	fs.StringVar(&cfg.CryptoKey, "crypto-key", "", "path to RSA public key file to encrypt messages to Server [env:CRYPTO_KEY]")
+	fs.IntVar(&cfg.PollInterval, "p", 0, "poll interval in seconds [env:POLL_INTERVAL]")
+	fs.StringVar(&cfg.PollJitter, "poll-jitter", "", "maximum random delay of the collection after each poll tick, e.g. 100ms; 0 disables it [env:POLL_JITTER]")
+	fs.IntVar(&cfg.ReportInterval, "r", 0, "report interval in seconds [env:REPORT_INTERVAL]")
+	fs.IntVar(&cfg.RateLimit, "l", 0, "the number of simultaneous outgoing requests to the server, at least 1 [env:RATE_LIMIT]")
+	fs.IntVar(&cfg.RateLimit, "rate-limit", 0, "the number of simultaneous outgoing requests to the server; alias of -l [env:RATE_LIMIT]")
+	fs.StringVar(&cfg.SelfPrefix, "self-metrics-prefix", "", "name prefix of the agent self-reported metrics [env:SELF_METRICS_PREFIX]")
+	fs.StringVar(&cfg.Compression, "compression", "", "compression algorithm of the metrics sent to the server: gzip or zstd [env:COMPRESSION]")
+	fs.BoolVar(&cfg.HTTP2, "http2", false, "whether or not to use HTTP/2 for requests to the server [env:HTTP2]")
+	fs.BoolVar(&cfg.Once, "once", false, "collect and report the metrics a single time and exit [env:ONCE]")
+	fs.BoolVar(&cfg.StrictIntervals, "strict-intervals", false, "fail to start if the poll interval exceeds the report interval instead of warning [env:STRICT_INTERVALS]")
+	fs.IntVar(&cfg.GopsutilInterval, "gopsutil-interval", 0, "system metrics collection interval in seconds; defaults to the poll interval [env:GOPSUTIL_INTERVAL]")
+	fs.IntVar(&cfg.FailureThreshold, "gopsutil-failure-threshold", 0, "consecutive collection failures of a system metric before a warning is logged [env:GOPSUTIL_FAILURE_THRESHOLD]")
+	fs.BoolVar(&cfg.DropFailingMetrics, "drop-failing-metrics", false, "whether or not to stop reporting the system metrics that keep failing to be collected [env:DROP_FAILING_METRICS]")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, fmt.Errorf("fs.Parse: %w", err)
+	}
 
 	// Highest precedence for environment variables.
 	if err := env.Parse(&cfg); err != nil {
@@ -48,27 +98,93 @@ func newConfig() (config, error) {
 		return cfg, fmt.Errorf("readConfigFile: %w", err)
 	}
 
+	// Rate limit of 0 is treated as unset and is already defaulted to 1 request
+	// at a time by readConfigFile, so only the negative values are left here.
+	if cfg.RateLimit < 1 {
+		return cfg, fmt.Errorf("invalid rate limit %d: must be at least 1", cfg.RateLimit)
+	}
+
+	if cfg.FailureThreshold < 1 {
+		return cfg, fmt.Errorf("invalid gopsutil failure threshold %d: must be at least 1", cfg.FailureThreshold)
+	}
+
+	// The poll interval exceeding the report interval is almost always
+	// a misconfiguration, though the one-shot mode does not use the intervals.
+	if cfg.PollInterval > cfg.ReportInterval && !cfg.Once {
+		if cfg.StrictIntervals {
+			return cfg, fmt.Errorf("invalid poll interval %d: must not exceed report interval %d", cfg.PollInterval, cfg.ReportInterval)
+		}
+
+		cfg.pollExceedsReport = true
+	}
+
+	switch cfg.Compression {
+	case monitor.CompressionGzip, monitor.CompressionZstd:
+	default:
+		return cfg, fmt.Errorf("invalid compression %q: must be gzip or zstd", cfg.Compression)
+	}
+
 	// Check if the URL does not start with "http://" or "https://".
+	// The Unix domain socket addresses are dialed as they are.
 	if !strings.HasPrefix(cfg.ServerAddr, "http://") &&
-		!strings.HasPrefix(cfg.ServerAddr, "https://") {
+		!strings.HasPrefix(cfg.ServerAddr, "https://") &&
+		!strings.HasPrefix(cfg.ServerAddr, httpclient.UnixAddrPrefix) {
 		cfg.ServerAddr = "http://" + cfg.ServerAddr
 	}
 
 	return cfg, nil
 }
 
-func readConfigFile(file string, cfg *config) error {
-	f, err := os.ReadFile(file)
-	if err != nil {
-		return fmt.Errorf("os.ReadFile: %w", err)
+// readConfigFile merges the config files values into the config.
+//
+// The files is a comma-separated list of config files merged in order,
+// so that values of the later files override the earlier ones.
+// Missing config files are skipped.
+func readConfigFile(files string, cfg *config) error {
+	fileCfg := new(config)
+
+	// Assume all the files are missing until one is read.
+	cfg.configFileMissing = true
+
+	for _, file := range strings.Split(files, ",") {
+		f, err := os.ReadFile(strings.TrimSpace(file))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("os.ReadFile: %w", err)
+		}
+
+		layerCfg := new(config)
+
+		if err := json.Unmarshal(f, layerCfg); err != nil {
+			return fmt.Errorf("json.Unmarshal(%s): %w", file, err)
+		}
+
+		mergeConfig(fileCfg, layerCfg)
+
+		cfg.configFileMissing = false
 	}
 
-	fileCfg := new(config)
+	applyConfigFile(fileCfg, cfg)
+
+	return nil
+}
 
-	if err := json.Unmarshal(f, fileCfg); err != nil {
-		return fmt.Errorf("json.Unmarshal: %w", err)
+// mergeConfig sets the dst config fields to the non-zero src config fields.
+func mergeConfig(dst, src *config) {
+	dv := reflect.ValueOf(dst).Elem()
+	sv := reflect.ValueOf(src).Elem()
+
+	for i := range sv.NumField() {
+		if field := sv.Field(i); dv.Field(i).CanSet() && !field.IsZero() {
+			dv.Field(i).Set(field)
+		}
 	}
+}
 
+// applyConfigFile sets the config values that are not set yet from fileCfg,
+// falling back to the default values.
+func applyConfigFile(fileCfg, cfg *config) {
 	if cfg.CryptoKey == "" {
 		if fileCfg.CryptoKey == "" {
 			cfg.CryptoKey = "./tls/public.key"
@@ -93,6 +209,22 @@ func readConfigFile(file string, cfg *config) error {
 		}
 	}
 
+	if cfg.GopsutilInterval == 0 {
+		if fileCfg.GopsutilInterval == 0 {
+			cfg.GopsutilInterval = cfg.PollInterval
+		} else {
+			cfg.GopsutilInterval = fileCfg.GopsutilInterval
+		}
+	}
+
+	if cfg.PollJitter == "" {
+		if fileCfg.PollJitter == "" {
+			cfg.PollJitter = "100ms"
+		} else {
+			cfg.PollJitter = fileCfg.PollJitter
+		}
+	}
+
 	if cfg.ReportInterval == 0 {
 		if fileCfg.ReportInterval == 0 {
 			cfg.ReportInterval = 10
@@ -117,9 +249,59 @@ func readConfigFile(file string, cfg *config) error {
 		}
 	}
 
+	if cfg.UpdatesPath == "" {
+		if fileCfg.UpdatesPath == "" {
+			cfg.UpdatesPath = "/updates"
+		} else {
+			cfg.UpdatesPath = fileCfg.UpdatesPath
+		}
+	}
+
 	if cfg.SignKey == "" {
 		cfg.SignKey = fileCfg.SignKey
 	}
 
-	return nil
+	if cfg.SignPrivateKey == "" {
+		cfg.SignPrivateKey = fileCfg.SignPrivateKey
+	}
+
+	if cfg.SelfPrefix == "" {
+		if fileCfg.SelfPrefix == "" {
+			cfg.SelfPrefix = "agent_"
+		} else {
+			cfg.SelfPrefix = fileCfg.SelfPrefix
+		}
+	}
+
+	if cfg.Compression == "" {
+		if fileCfg.Compression == "" {
+			cfg.Compression = monitor.CompressionGzip
+		} else {
+			cfg.Compression = fileCfg.Compression
+		}
+	}
+
+	if cfg.FailureThreshold == 0 {
+		if fileCfg.FailureThreshold == 0 {
+			cfg.FailureThreshold = 3
+		} else {
+			cfg.FailureThreshold = fileCfg.FailureThreshold
+		}
+	}
+
+	if !cfg.HTTP2 {
+		cfg.HTTP2 = fileCfg.HTTP2
+	}
+
+	if !cfg.DropFailingMetrics {
+		cfg.DropFailingMetrics = fileCfg.DropFailingMetrics
+	}
+
+	if !cfg.Once {
+		cfg.Once = fileCfg.Once
+	}
+
+	if !cfg.StrictIntervals {
+		cfg.StrictIntervals = fileCfg.StrictIntervals
+	}
 }
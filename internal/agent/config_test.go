@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadConfigFileCryptoKeyPrecedence(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "agent.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"crypto_key": "./file.key"}`), 0600))
+
+	emptyFile := filepath.Join(dir, "empty.json")
+	require.NoError(t, os.WriteFile(emptyFile, []byte(`{}`), 0600))
+
+	testCases := []struct {
+		name string
+		file string
+		cfg  config
+		want string
+	}{
+		{"FlagOrEnvSet", file, config{CryptoKey: "./flag.key"}, "./flag.key"},
+		{"FileOnly", file, config{}, "./file.key"},
+		{"NoneSet", emptyFile, config{}, "./tls/public.key"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+
+			require.NoError(t, readConfigFile(tc.file, &cfg))
+
+			assert.Equal(t, tc.want, cfg.CryptoKey)
+		})
+	}
+}
+
+func TestParseConfigFlags(t *testing.T) {
+	// The default config file path is relative to the working directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chdir(t.TempDir()))
+
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+
+	testCases := []struct {
+		name      string
+		args      []string
+		logLevel  string
+		rateLimit int
+	}{
+		{"Defaults", nil, "info", 1},
+		{"ShortFlags", []string{"-lv", "debug", "-l", "4"}, "debug", 4},
+		{"LongFlags", []string{"-log-level", "warn", "-rate-limit", "5"}, "warn", 5},
+		{"DoubleDashLongFlags", []string{"--log-level", "error", "--rate-limit", "6"}, "error", 6},
+		{"ZeroRateLimit", []string{"-l", "0"}, "info", 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), tc.args)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.logLevel, cfg.LogLevel)
+			assert.Equal(t, tc.rateLimit, cfg.RateLimit)
+		})
+	}
+}
+
+func TestParseConfigNegativeRateLimit(t *testing.T) {
+	// The default config file path is relative to the working directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chdir(t.TempDir()))
+
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+
+	_, err = parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-l", "-1"})
+	require.ErrorContains(t, err, "invalid rate limit")
+}
+
+func TestParseConfigIntervals(t *testing.T) {
+	// The default config file path is relative to the working directory.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	require.NoError(t, os.Chdir(t.TempDir()))
+
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+
+	testCases := []struct {
+		name              string
+		args              []string
+		wantErr           bool
+		pollExceedsReport bool
+	}{
+		{"Defaults", nil, false, false},
+		{"EqualIntervals", []string{"-p", "5", "-r", "5"}, false, false},
+		{"PollExceedsReport", []string{"-p", "20", "-r", "10"}, false, true},
+		{"PollExceedsReportStrict", []string{"-p", "20", "-r", "10", "-strict-intervals"}, true, false},
+		{"PollExceedsReportOnce", []string{"-p", "20", "-r", "10", "-strict-intervals", "-once"}, false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := parseConfig(flag.NewFlagSet("test", flag.ContinueOnError), tc.args)
+			if tc.wantErr {
+				require.ErrorContains(t, err, "invalid poll interval")
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.pollExceedsReport, cfg.pollExceedsReport)
+		})
+	}
+}
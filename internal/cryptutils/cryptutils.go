@@ -2,6 +2,7 @@
 package cryptutils
 
 import (
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -61,6 +62,56 @@ func LoadRSAPrivateKey(keyfile string) (*rsa.PrivateKey, error) {
 	return rsaPrivKey, nil
 }
 
+// LoadEd25519PublicKey loads an ed25519 public key from a file.
+func LoadEd25519PublicKey(keyfile string) (ed25519.PublicKey, error) {
+	keyPEM, err := os.ReadFile(keyfile)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("failed to decode PEM block containing public key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+
+	pubKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("the key is not an ed25519 public key")
+	}
+
+	return pubKey, nil
+}
+
+// LoadEd25519PrivateKey loads an ed25519 private key from a file.
+func LoadEd25519PrivateKey(keyfile string) (ed25519.PrivateKey, error) {
+	keyPEM, err := os.ReadFile(keyfile)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("failed to decode PEM block containing private key")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+
+	privKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the key is not an ed25519 private key")
+	}
+
+	return privKey, nil
+}
+
 // EncryptOAEP encrypts data using RSA-OAEP encryption method.
 func EncryptOAEP(hash hash.Hash, random io.Reader, key *rsa.PublicKey, msg []byte, label []byte) ([]byte, error) {
 	msgLen := len(msg)
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"os"
@@ -13,15 +14,33 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/andymarkow/go-metrics-collector/internal/models"
 	"github.com/andymarkow/go-metrics-collector/internal/storage"
 )
 
+// Data saver health stats exposed via the expvar stats endpoint.
+var (
+	saveFailures      = expvar.NewInt("datamanager_save_failures")
+	lastSaveTimestamp = expvar.NewInt("datamanager_last_save_timestamp")
+)
+
 // DataManager represents a data manager to load and save metrics data.
 type DataManager struct {
 	storeInterval time.Duration
 	log           *zap.Logger
 	storage       storage.Storage
 	file          string
+	synchronous   bool
+
+	// restoreMaxAge is the maximum age of the file the data is loaded from.
+	restoreMaxAge time.Duration
+
+	// saveSource is the storage the data saver reads the metrics data from.
+	saveSource storage.Storage
+
+	// saveMu serializes the file writes of the data saver and the
+	// synchronous saves.
+	saveMu sync.Mutex
 }
 
 // NewDataManager creates a new DataManager instance.
@@ -41,6 +60,10 @@ func NewDataManager(storage storage.Storage, file string, opts ...Option) *DataM
 		opt(dm)
 	}
 
+	if dm.saveSource == nil {
+		dm.saveSource = dm.storage
+	}
+
 	return dm
 }
 
@@ -61,13 +84,67 @@ func WithStoreInterval(storeInterval time.Duration) Option {
 	}
 }
 
+// WithSynchronous makes the data manager save the metrics data to the file
+// after each batch update made through the SynchronousStorage instead of
+// every store interval.
+func WithSynchronous(synchronous bool) Option {
+	return func(d *DataManager) {
+		d.synchronous = synchronous
+	}
+}
+
+// WithRestoreMaxAge makes the data manager skip loading the metrics data from
+// the file modified more than maxAge ago, so that a stale snapshot does not
+// resurrect the long-gone metrics. The age is not limited if maxAge is not
+// positive.
+func WithRestoreMaxAge(maxAge time.Duration) Option {
+	return func(d *DataManager) {
+		d.restoreMaxAge = maxAge
+	}
+}
+
+// WithSaveSource makes the data saver read the metrics data saved every store
+// interval and on shutdown from the given storage, e.g. a database read replica,
+// so that the full scans do not compete with serving the traffic. The
+// synchronous saves read the data manager storage to not miss the latest batch
+// due to the replication lag.
+func WithSaveSource(strg storage.Storage) Option {
+	return func(d *DataManager) {
+		d.saveSource = strg
+	}
+}
+
+// Synchronous reports whether the data is saved after each batch update.
+func (m *DataManager) Synchronous() bool {
+	return m.synchronous
+}
+
 // Load loads the metrics data from the file.
 func (m *DataManager) Load(ctx context.Context) error {
+	if m.restoreMaxAge > 0 {
+		info, err := os.Stat(m.file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("os.Stat: %w", err)
+		}
+
+		if err == nil {
+			if age := time.Since(info.ModTime()); age > m.restoreMaxAge {
+				m.log.Warn("Skipping stale data file",
+					zap.String("file", m.file),
+					zap.Duration("age", age.Round(time.Second)),
+					zap.Duration("max_age", m.restoreMaxAge),
+				)
+
+				return nil
+			}
+		}
+	}
+
 	m.log.Sugar().Infof("Loading data from file %s", m.file)
 
 	data := make(map[string]storage.Metric)
 
-	if err := readDataFromFile(m.file, &data); err != nil {
+	if err := readDataFromFile(m.log, m.file, &data); err != nil {
 		return fmt.Errorf("failed to read data from file: %w", err)
 	}
 
@@ -78,8 +155,14 @@ func (m *DataManager) Load(ctx context.Context) error {
 	return nil
 }
 
+// Save saves the metrics data of the data manager storage to the file.
 func (m *DataManager) Save(ctx context.Context, file *os.File) error {
-	data, err := m.storage.GetAllMetrics(ctx)
+	return saveData(ctx, m.storage, file)
+}
+
+// saveData saves the metrics data of the storage to the file.
+func saveData(ctx context.Context, strg storage.Storage, file *os.File) error {
+	data, err := strg.GetAllMetrics(ctx)
 	if err != nil {
 		return fmt.Errorf("storage.GetAllMetrics: %w", err)
 	}
@@ -91,19 +174,98 @@ func (m *DataManager) Save(ctx context.Context, file *os.File) error {
 	return nil
 }
 
+// SaveSync saves the metrics data to the file right away.
+func (m *DataManager) SaveSync(ctx context.Context) error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	f, err := os.OpenFile(m.file, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		saveFailures.Add(1)
+
+		return fmt.Errorf("os.OpenFile: %w", err)
+	}
+
+	if err := m.Save(ctx, f); err != nil {
+		saveFailures.Add(1)
+
+		_ = f.Close()
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		saveFailures.Add(1)
+
+		return fmt.Errorf("file.Close: %w", err)
+	}
+
+	lastSaveTimestamp.Set(time.Now().Unix())
+
+	return nil
+}
+
+// SynchronousStorage wraps the storage to save the metrics data to the file
+// after each successful batch update if the data manager is synchronous.
+// Otherwise the storage is returned as is.
+func (m *DataManager) SynchronousStorage(strg storage.Storage) storage.Storage {
+	if !m.synchronous {
+		return strg
+	}
+
+	return &syncStorage{Storage: strg, datamgr: m}
+}
+
+// syncStorage is a Storage decorator that saves the data after batch updates.
+type syncStorage struct {
+	storage.Storage
+	datamgr *DataManager
+}
+
+// SetMetrics stores the metrics and saves the data to the file.
+//
+// The save failure is logged and counted in the data saver health stats
+// instead of being returned, since the metrics are already stored and the
+// client retrying the batch would apply the counter deltas twice.
+func (s *syncStorage) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+	if err := s.Storage.SetMetrics(ctx, metrics); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if err := s.datamgr.SaveSync(ctx); err != nil {
+		s.datamgr.log.Error("failed to save data to store file", zap.Error(err))
+	}
+
+	return nil
+}
+
+// shutdownSaveTimeout is the timeout of the final save on shutdown.
+const shutdownSaveTimeout = 5 * time.Second
+
 func (m *DataManager) RunDataSaver(ctx context.Context, wg *sync.WaitGroup) error {
 	defer wg.Done()
 
 	m.log.Info("Starting data saver")
-	m.log.Sugar().Infof("Saving data every %s to the file %s", m.storeInterval.String(), m.file)
 
 	f, err := os.OpenFile(m.file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("os.OpenFile: %w", err)
 	}
 
-	storeTicker := time.NewTicker(m.storeInterval)
-	defer storeTicker.Stop()
+	// The ticker is not started in the synchronous mode, so only the final
+	// save is made here.
+	var storeTick <-chan time.Time
+
+	if m.synchronous {
+		m.log.Sugar().Infof("Saving data after each batch update to the file %s", m.file)
+	} else {
+		m.log.Sugar().Infof("Saving data every %s to the file %s", m.storeInterval.String(), m.file)
+
+		storeTicker := time.NewTicker(m.storeInterval)
+		defer storeTicker.Stop()
+
+		storeTick = storeTicker.C
+	}
 
 	for {
 		select {
@@ -111,9 +273,11 @@ func (m *DataManager) RunDataSaver(ctx context.Context, wg *sync.WaitGroup) erro
 			m.log.Info("Stopping data saver")
 			m.log.Sugar().Infof("Flushing data to store file %s", m.file)
 
-			if err := m.Save(ctx, f); err != nil {
-				m.log.Error("failed to save data to store file", zap.Error(err))
-			}
+			// The context is already cancelled, so the final save gets
+			// a fresh one to not fail the storage queries.
+			saveCtx, cancel := context.WithTimeout(context.Background(), shutdownSaveTimeout)
+			m.save(saveCtx, f)
+			cancel()
 
 			if err := f.Close(); err != nil {
 				return fmt.Errorf("file.Close: %w", err)
@@ -121,26 +285,41 @@ func (m *DataManager) RunDataSaver(ctx context.Context, wg *sync.WaitGroup) erro
 
 			return nil
 
-		case <-storeTicker.C:
-			if err := m.Save(ctx, f); err != nil {
-				m.log.Error("failed to save data to store file", zap.Error(err))
-			}
+		case <-storeTick:
+			m.save(ctx, f)
 		}
 	}
 }
 
-func readDataFromFile(file string, data any) error {
+// save saves the metrics data to the file and records the data saver health stats.
+func (m *DataManager) save(ctx context.Context, file *os.File) {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	if err := saveData(ctx, m.saveSource, file); err != nil {
+		saveFailures.Add(1)
+
+		m.log.Error("failed to save data to store file", zap.Error(err))
+
+		return
+	}
+
+	lastSaveTimestamp.Set(time.Now().Unix())
+}
+
+func readDataFromFile(log *zap.Logger, file string, data any) error {
 	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("os.OpenFile: %w", err)
 	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			return
-		}
-	}()
+	defer closeFile(log, file, f)
 
-	err = json.NewDecoder(f).Decode(&data)
+	decoder := json.NewDecoder(f)
+
+	// Keep numbers as json.Number to not lose counters precision beyond 2^53.
+	decoder.UseNumber()
+
+	err = decoder.Decode(&data)
 	if errors.Is(err, io.EOF) {
 		return nil
 	} else if err != nil {
@@ -150,6 +329,14 @@ func readDataFromFile(file string, data any) error {
 	return nil
 }
 
+// closeFile closes the file read from and logs the close error, as the data
+// has been read already and the error is not worth failing the read for.
+func closeFile(log *zap.Logger, file string, f io.Closer) {
+	if err := f.Close(); err != nil {
+		log.Error("Failed to close data file", zap.String("file", file), zap.Error(err))
+	}
+}
+
 func writeDataToFile(file *os.File, data any) error {
 	// Truncate the file content to 0.
 	if err := file.Truncate(0); err != nil {
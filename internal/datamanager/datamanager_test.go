@@ -0,0 +1,283 @@
+package datamanager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+	"github.com/andymarkow/go-metrics-collector/internal/storage"
+)
+
+func TestSaveLoadLargeCounter(t *testing.T) {
+	ctx := context.Background()
+
+	const counter int64 = 1<<53 + 1
+
+	file := filepath.Join(t.TempDir(), "metrics-db.json")
+
+	src := storage.NewMemStorage()
+	require.NoError(t, src.SetCounter(ctx, "testCounter", counter))
+	require.NoError(t, src.SetGauge(ctx, "testGauge", 1.5))
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	require.NoError(t, err)
+
+	require.NoError(t, NewDataManager(src, file).Save(ctx, f))
+	require.NoError(t, f.Close())
+
+	dst := storage.NewMemStorage()
+	require.NoError(t, NewDataManager(dst, file).Load(ctx))
+
+	cnt, err := dst.GetCounter(ctx, "testCounter")
+	require.NoError(t, err)
+	assert.Equal(t, counter, cnt)
+
+	gauge, err := dst.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, gauge, 0)
+}
+
+func TestSaveHealthStats(t *testing.T) {
+	ctx := context.Background()
+
+	file := filepath.Join(t.TempDir(), "metrics-db.json")
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	require.NoError(t, err)
+
+	dm := NewDataManager(storage.NewMemStorage(), file)
+
+	failures := saveFailures.Value()
+
+	dm.save(ctx, f)
+
+	assert.Equal(t, failures, saveFailures.Value())
+	assert.NotZero(t, lastSaveTimestamp.Value())
+
+	require.NoError(t, f.Close())
+
+	// Saving to the closed file fails.
+	dm.save(ctx, f)
+
+	assert.Equal(t, failures+1, saveFailures.Value())
+}
+
+// ctxStorage is a storage failing the queries with the cancelled context
+// as the database storages do.
+type ctxStorage struct {
+	storage.Storage
+}
+
+func (s ctxStorage) GetAllMetrics(ctx context.Context) (map[string]storage.Metric, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.Storage.GetAllMetrics(ctx) //nolint:wrapcheck
+}
+
+func TestRunDataSaverFinalSave(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "metrics-db.json")
+
+	strg := storage.NewMemStorage()
+	require.NoError(t, strg.SetGauge(context.Background(), "testGauge", 1.5))
+
+	dm := NewDataManager(ctxStorage{strg}, file, WithStoreInterval(time.Hour))
+
+	// The saver is stopped before the first store tick.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	require.NoError(t, dm.RunDataSaver(ctx, wg))
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "testGauge")
+}
+
+func TestSynchronousStorage(t *testing.T) {
+	ctx := context.Background()
+
+	file := filepath.Join(t.TempDir(), "metrics-db.json")
+
+	strg := storage.NewMemStorage()
+
+	assert.Same(t, strg, NewDataManager(strg, file).SynchronousStorage(strg))
+
+	dm := NewDataManager(strg, file, WithStoreInterval(0), WithSynchronous(true))
+
+	delta := int64(3)
+
+	require.NoError(t, dm.SynchronousStorage(strg).SetMetrics(ctx, []models.Metrics{
+		{ID: "testCounter", MType: "counter", Delta: &delta},
+	}))
+
+	// The batch is in the file without waiting for the data saver.
+	dst := storage.NewMemStorage()
+	require.NoError(t, NewDataManager(dst, file).Load(ctx))
+
+	cnt, err := dst.GetCounter(ctx, "testCounter")
+	require.NoError(t, err)
+	assert.Equal(t, delta, cnt)
+
+	// The data saver runs without the store ticker.
+	runCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	require.NoError(t, dm.RunDataSaver(runCtx, wg))
+}
+
+func TestSynchronousStorageSaveFailure(t *testing.T) {
+	ctx := context.Background()
+
+	// The store file directory does not exist.
+	file := filepath.Join(t.TempDir(), "missing", "metrics-db.json")
+
+	core, logs := observer.New(zap.ErrorLevel)
+
+	strg := storage.NewMemStorage()
+
+	dm := NewDataManager(strg, file,
+		WithLogger(zap.New(core)),
+		WithStoreInterval(0),
+		WithSynchronous(true),
+	)
+
+	failures := saveFailures.Value()
+
+	delta := int64(3)
+
+	// The stored batch is not failed, so that the client does not retry it.
+	require.NoError(t, dm.SynchronousStorage(strg).SetMetrics(ctx, []models.Metrics{
+		{ID: "testCounter", MType: "counter", Delta: &delta},
+	}))
+
+	cnt, err := strg.GetCounter(ctx, "testCounter")
+	require.NoError(t, err)
+	assert.Equal(t, delta, cnt)
+
+	assert.Equal(t, failures+1, saveFailures.Value())
+	assert.Equal(t, 1, logs.FilterMessage("failed to save data to store file").Len())
+}
+
+func TestSaveSource(t *testing.T) {
+	ctx := context.Background()
+
+	file := filepath.Join(t.TempDir(), "metrics-db.json")
+
+	primary := storage.NewMemStorage()
+	require.NoError(t, primary.SetGauge(ctx, "primaryGauge", 1))
+
+	replica := storage.NewMemStorage()
+	require.NoError(t, replica.SetGauge(ctx, "replicaGauge", 1))
+
+	dm := NewDataManager(primary, file, WithStoreInterval(time.Hour), WithSaveSource(replica))
+
+	// The data saver reads the replica.
+	runCtx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	require.NoError(t, dm.RunDataSaver(runCtx, wg))
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "replicaGauge")
+	assert.NotContains(t, string(data), "primaryGauge")
+
+	// The synchronous save reads the primary.
+	require.NoError(t, dm.SaveSync(ctx))
+
+	data, err = os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "primaryGauge")
+}
+
+func TestLoadRestoreMaxAge(t *testing.T) {
+	ctx := context.Background()
+
+	file := filepath.Join(t.TempDir(), "metrics-db.json")
+
+	src := storage.NewMemStorage()
+	require.NoError(t, src.SetCounter(ctx, "testCounter", 1))
+
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	require.NoError(t, err)
+
+	require.NoError(t, NewDataManager(src, file).Save(ctx, f))
+	require.NoError(t, f.Close())
+
+	// The fresh snapshot is restored.
+	dst := storage.NewMemStorage()
+	require.NoError(t, NewDataManager(dst, file, WithRestoreMaxAge(time.Hour)).Load(ctx))
+
+	_, err = dst.GetCounter(ctx, "testCounter")
+	require.NoError(t, err)
+
+	// The stale snapshot is skipped.
+	staleTime := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(file, staleTime, staleTime))
+
+	dst = storage.NewMemStorage()
+	require.NoError(t, NewDataManager(dst, file, WithRestoreMaxAge(time.Hour)).Load(ctx))
+
+	_, err = dst.GetCounter(ctx, "testCounter")
+	require.ErrorIs(t, err, storage.ErrMetricNotFound)
+
+	// The age is not limited by default.
+	require.NoError(t, NewDataManager(dst, file).Load(ctx))
+
+	_, err = dst.GetCounter(ctx, "testCounter")
+	require.NoError(t, err)
+
+	// The missing file is created as before.
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	require.NoError(t, NewDataManager(dst, missing, WithRestoreMaxAge(time.Hour)).Load(ctx))
+	assert.FileExists(t, missing)
+}
+
+// failingCloser is an io.Closer that fails to close.
+type failingCloser struct{}
+
+func (failingCloser) Close() error {
+	return errors.New("close failed")
+}
+
+func TestCloseFileLogsError(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	log := zap.New(core)
+
+	closeFile(log, "metrics-db.json", failingCloser{})
+
+	entries := logs.FilterMessage("Failed to close data file").All()
+	require.Len(t, entries, 1)
+
+	assert.Equal(t, "metrics-db.json", entries[0].ContextMap()["file"])
+	assert.Equal(t, "close failed", entries[0].ContextMap()["error"])
+
+	// The successful read closes the file without errors.
+	file := filepath.Join(t.TempDir(), "metrics-db.json")
+
+	data := make(map[string]storage.Metric)
+	require.NoError(t, readDataFromFile(log, file, &data))
+
+	assert.Equal(t, 1, logs.Len())
+}
@@ -4,12 +4,24 @@ package errormsg
 import "errors"
 
 var (
-	ErrMetricInvalidType    = errors.New("invalid metric type")
-	ErrMetricInvalidDelta   = errors.New("invalid metric delta")
-	ErrMetricInvalidValue   = errors.New("invalid metric value")
-	ErrMetricEmptyName      = errors.New("empty metric name")
-	ErrMetricEmptyValue     = errors.New("empty metric value")
-	ErrMetricEmptyDelta     = errors.New("empty metric delta")
-	ErrEmptyRequestPayload  = errors.New("empty request payload")
-	ErrHashSumValueMismatch = errors.New("hash sum value mismatch")
+	ErrMetricInvalidType     = errors.New("invalid metric type")
+	ErrMetricInvalidDelta    = errors.New("invalid metric delta")
+	ErrMetricInvalidValue    = errors.New("invalid metric value")
+	ErrMetricEmptyName       = errors.New("empty metric name")
+	ErrMetricEmptyValue      = errors.New("empty metric value")
+	ErrMetricEmptyDelta      = errors.New("empty metric delta")
+	ErrMetricNameTooLong     = errors.New("metric name is too long")
+	ErrMetricInvalidName     = errors.New("invalid metric name")
+	ErrMetricValueTooLong    = errors.New("metric value is too long")
+	ErrMetricCounterOverflow = errors.New("metric counter overflow")
+	ErrEmptyRequestPayload   = errors.New("empty request payload")
+	ErrHashSumValueMismatch  = errors.New("hash sum value mismatch")
+	ErrSignatureInvalid      = errors.New("invalid ed25519 signature")
+	ErrRouteNotFound         = errors.New("route not found")
+	ErrInvalidUpdateMode     = errors.New("invalid update mode")
+	ErrInvalidPrecision      = errors.New("invalid precision")
+	ErrUntrustedIPAddress    = errors.New("untrusted client ip address")
+	ErrBatchTooLarge         = errors.New("metrics batch is too large")
+	ErrRequestBodyTooLarge   = errors.New("decompressed request body is too large")
+	ErrRateLimitExceeded     = errors.New("request rate limit exceeded")
 )
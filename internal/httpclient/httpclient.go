@@ -2,6 +2,13 @@
 package httpclient
 
 import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/go-resty/resty/v2"
 )
 
@@ -12,11 +19,156 @@ type HTTPClient struct {
 
 // NewHTTPClient returns a new HTTPClient.
 //
-// The underlying resty client is created with default settings.
-func NewHTTPClient() *HTTPClient {
-	client := resty.New()
+// The underlying resty client is created with a keep-alive transport which
+// keeps idle connections to the server open, so consecutive requests reuse
+// them instead of dialing a new TCP connection each time.
+func NewHTTPClient(opts ...Option) *HTTPClient {
+	o := &options{
+		transport: newTransport(),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	client := resty.NewWithClient(&http.Client{
+		Transport: o.transport,
+	})
+
+	if o.backoffBase > 0 && o.backoffMax > 0 {
+		// The wait time is computed by the retry after function entirely,
+		// so the lower bound must not clamp the jittered wait.
+		client.
+			SetRetryWaitTime(0).
+			SetRetryMaxWaitTime(o.backoffMax).
+			SetRetryAfter(func(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
+				// The attempt is the number of requests sent so far, starting with 1.
+				return backoffWait(o.backoffBase, o.backoffMax, resp.Request.Attempt-1), nil
+			})
+	}
 
 	return &HTTPClient{
 		Client: client,
 	}
 }
+
+// options represents the HTTP client options.
+type options struct {
+	transport   *http.Transport
+	backoffBase time.Duration
+	backoffMax  time.Duration
+}
+
+// Option is a HTTP client option.
+type Option func(o *options)
+
+// WithMaxIdleConnsPerHost is a HTTP client option that sets the maximum number
+// of idle (keep-alive) connections kept per host.
+//
+// It should not be lower than the number of concurrent requests sent to the
+// server, otherwise connections are closed after use and dialed again.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(o *options) {
+		o.transport.MaxIdleConnsPerHost = n
+
+		if o.transport.MaxIdleConns < n {
+			o.transport.MaxIdleConns = n
+		}
+	}
+}
+
+// WithHTTP2 is a HTTP client option that enables HTTP/2 support.
+//
+// HTTP/2 is negotiated with the server over TLS (ALPN), so the client falls
+// back to HTTP/1.1 when the server does not support it. Plain HTTP connections
+// always use HTTP/1.1.
+func WithHTTP2(enabled bool) Option {
+	return func(o *options) {
+		o.transport.ForceAttemptHTTP2 = enabled
+	}
+}
+
+// WithBackoff is a HTTP client option that sets an exponential backoff with
+// full jitter between the request retries.
+//
+// The wait before the retry attempt is a random duration up to
+// min(maxWait, base*2^attempt), so that the retries of many clients are
+// spread over time instead of hitting the server all at once.
+func WithBackoff(base, maxWait time.Duration) Option {
+	return func(o *options) {
+		o.backoffBase = base
+		o.backoffMax = maxWait
+	}
+}
+
+// UnixAddrPrefix is the server address prefix of a Unix domain socket,
+// e.g. "unix:/run/metrics.sock".
+const UnixAddrPrefix = "unix:"
+
+// UnixSocketPath returns the socket file path of the Unix domain socket
+// server address.
+func UnixSocketPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, UnixAddrPrefix)
+
+	return path, ok && path != ""
+}
+
+// WithUnixSocket is a HTTP client option that makes the client dial the
+// Unix domain socket at the given path for all the requests regardless
+// of the request URL host.
+func WithUnixSocket(path string) Option {
+	return func(o *options) {
+		dialer := &net.Dialer{
+			Timeout: 30 * time.Second,
+		}
+
+		o.transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+
+		// The requests never reach the network, so no proxy is involved.
+		o.transport.Proxy = nil
+	}
+}
+
+// backoffCap returns the upper bound of the wait before the retry attempt,
+// i.e. min(maxWait, base*2^attempt).
+func backoffCap(base, maxWait time.Duration, attempt int) time.Duration {
+	wait := base
+
+	for range attempt {
+		// Stop doubling once the max wait is reached to not overflow.
+		if wait >= maxWait/2 {
+			return maxWait
+		}
+
+		wait *= 2
+	}
+
+	return min(wait, maxWait)
+}
+
+// backoffWait returns a random wait before the retry attempt within
+// (0, backoffCap].
+func backoffWait(base, maxWait time.Duration, attempt int) time.Duration {
+	return time.Duration(rand.Int64N(int64(backoffCap(base, maxWait, attempt)))) + 1
+}
+
+// newTransport creates a new HTTP/1.1 transport with keep-alive connections enabled.
+func newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     false,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
@@ -0,0 +1,143 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+
+	defer ts.Close()
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(ts.Certificate())
+
+	testCases := []struct {
+		name    string
+		enabled bool
+		proto   string
+	}{
+		{"HTTP2Enabled", true, "HTTP/2.0"},
+		{"HTTP2Disabled", false, "HTTP/1.1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := NewHTTPClient(WithHTTP2(tc.enabled))
+			client.SetBaseURL(ts.URL)
+			client.SetTLSClientConfig(&tls.Config{
+				RootCAs:    certPool,
+				MinVersion: tls.VersionTLS12,
+			})
+
+			resp, err := client.R().Get("/")
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.proto, resp.RawResponse.Proto)
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	const (
+		base    = 100 * time.Millisecond
+		maxWait = time.Second
+	)
+
+	var prev time.Duration
+
+	for attempt := range 10 {
+		waitCap := backoffCap(base, maxWait, attempt)
+
+		assert.GreaterOrEqual(t, waitCap, prev, "attempt %d", attempt)
+		assert.LessOrEqual(t, waitCap, maxWait, "attempt %d", attempt)
+
+		for range 100 {
+			wait := backoffWait(base, maxWait, attempt)
+
+			assert.Positive(t, wait)
+			assert.LessOrEqual(t, wait, waitCap)
+		}
+
+		prev = waitCap
+	}
+
+	assert.Equal(t, base, backoffCap(base, maxWait, 0))
+	assert.Equal(t, 4*base, backoffCap(base, maxWait, 2))
+	assert.Equal(t, maxWait, backoffCap(base, maxWait, 100))
+}
+
+func TestWithBackoffRetries(t *testing.T) {
+	var requests int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	client := NewHTTPClient(WithBackoff(10*time.Millisecond, 50*time.Millisecond))
+	client.SetRetryCount(3).AddRetryCondition(func(resp *resty.Response, _ error) bool {
+		return resp.StatusCode() == http.StatusServiceUnavailable
+	})
+
+	start := time.Now()
+
+	resp, err := client.R().Get(ts.URL)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+	assert.Equal(t, 3, requests)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWithUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.Listener = ln
+	ts.Start()
+
+	defer ts.Close()
+
+	socket, ok := UnixSocketPath("unix:" + path)
+	require.True(t, ok)
+
+	client := NewHTTPClient(WithUnixSocket(socket))
+	client.SetBaseURL("http://localhost")
+
+	resp, err := client.R().Get("/")
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode())
+
+	_, ok = UnixSocketPath("localhost:8080")
+	assert.False(t, ok)
+}
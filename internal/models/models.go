@@ -2,24 +2,181 @@
 package models
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
 )
 
+// DefaultMaxNameLength is the default maximum length of the metric name.
+const DefaultMaxNameLength = 200
+
+// MaxValueLength is the maximum length of the metric value text form,
+// which is enough for any int64 and float64 value.
+const MaxValueLength = 64
+
+// ValidateName checks that the metric name is not empty, does not exceed
+// maxLength bytes and is a valid UTF-8 string with no control characters,
+// e.g. newlines breaking the metrics listing. The length is not limited if
+// maxLength is not positive.
+func ValidateName(name string, maxLength int) error {
+	if name == "" {
+		return errormsg.ErrMetricEmptyName
+	}
+
+	if maxLength > 0 && len(name) > maxLength {
+		return fmt.Errorf("%w: more than %d bytes", errormsg.ErrMetricNameTooLong, maxLength)
+	}
+
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("%w: not a valid UTF-8 string", errormsg.ErrMetricInvalidName)
+	}
+
+	if strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return fmt.Errorf("%w: control characters are not allowed", errormsg.ErrMetricInvalidName)
+	}
+
+	return nil
+}
+
 // Metrics is a model for metrics.
 type Metrics struct {
 	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge или наблюдение histogram
 	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
+	MType string   `json:"type"`            // параметр, принимающий значение gauge, counter или histogram
+}
+
+// UnmarshalMetricsJSON decodes a batch of metrics from JSON data.
+//
+// The fieldMap renames alternate JSON field names used by non-standard clients
+// to the Metrics field names, e.g. {"metric_id": "id", "metric_type": "type"}.
+// The fields which are not in the fieldMap are decoded as is.
+func UnmarshalMetricsJSON(data []byte, fieldMap map[string]string) ([]Metrics, error) {
+	var metrics []Metrics
+
+	if len(fieldMap) == 0 {
+		if err := json.Unmarshal(data, &metrics); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: %w", err)
+		}
+
+		return metrics, nil
+	}
+
+	var rawMetrics []map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &rawMetrics); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	for _, rawMetric := range rawMetrics {
+		renameFields(rawMetric, fieldMap)
+	}
+
+	// Encode the renamed fields back to decode them with the Metrics JSON tags.
+	mapped, err := json.Marshal(rawMetrics)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	if err := json.Unmarshal(mapped, &metrics); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return metrics, nil
+}
+
+// UnmarshalMetricJSON decodes a single metric from JSON data, renaming the
+// alternate JSON field names like UnmarshalMetricsJSON.
+func UnmarshalMetricJSON(data []byte, fieldMap map[string]string) (Metrics, error) {
+	var metric Metrics
+
+	if len(fieldMap) == 0 {
+		if err := json.Unmarshal(data, &metric); err != nil {
+			return metric, fmt.Errorf("json.Unmarshal: %w", err)
+		}
+
+		return metric, nil
+	}
+
+	var rawMetric map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &rawMetric); err != nil {
+		return metric, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	renameFields(rawMetric, fieldMap)
+
+	// Encode the renamed fields back to decode them with the Metrics JSON tags.
+	mapped, err := json.Marshal(rawMetric)
+	if err != nil {
+		return metric, fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	if err := json.Unmarshal(mapped, &metric); err != nil {
+		return metric, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+
+	return metric, nil
+}
+
+// renameFields renames the alternate JSON field names of the raw metric to
+// the Metrics field names.
+func renameFields(rawMetric map[string]json.RawMessage, fieldMap map[string]string) {
+	for alias, field := range fieldMap {
+		if v, ok := rawMetric[alias]; ok {
+			delete(rawMetric, alias)
+			rawMetric[field] = v
+		}
+	}
+}
+
+// UpdateMetricsResult is a model for the batch metrics update result.
+type UpdateMetricsResult struct {
+	Updated int `json:"updated"` // количество сохранённых метрик
+}
+
+// GetMetricsResult is a model for the batch get metrics response.
+type GetMetricsResult struct {
+	Metrics []Metrics     `json:"metrics"`          // найденные метрики
+	Errors  []MetricError `json:"errors,omitempty"` // метрики, которые не удалось получить
+}
+
+// MetricError is a model for the error of a single metric in a batch.
+type MetricError struct {
+	ID    string `json:"id"`    // имя метрики
+	MType string `json:"type"`  // тип метрики
+	Error string `json:"error"` // описание ошибки
+}
+
+// ResetCountersResult is a model for the counters reset result.
+type ResetCountersResult struct {
+	Reset int64 `json:"reset"` // количество обнулённых счётчиков
+}
+
+// ErrorResponse is a model for the error response of the JSON handlers.
+type ErrorResponse struct {
+	Error string `json:"error"` // описание ошибки
+	Code  int    `json:"code"`  // HTTP код ответа
+}
+
+// BuildInfo is a model for the server build information.
+type BuildInfo struct {
+	Version string `json:"version"` // версия сборки
+	Date    string `json:"date"`    // дата сборки
+	Commit  string `json:"commit"`  // хеш коммита сборки
 }
 
 // Validate performs basic validation of the Metrics object.
-// It checks that the ID field is not empty and that the MType field
-// is either "counter" or "gauge". If either of these conditions are
-// not met, an error will be returned.
-func (m *Metrics) Validate() error {
-	if m.ID == "" {
-		return errormsg.ErrMetricEmptyName
+// It checks that the ID field is a valid name of at most maxNameLength bytes
+// and that the MType field is either "counter" or "gauge". If either of these
+// conditions are not met, an error will be returned.
+func (m *Metrics) Validate(maxNameLength int) error {
+	if err := ValidateName(m.ID, maxNameLength); err != nil {
+		return err
 	}
 
 	switch m.MType {
@@ -32,12 +189,15 @@ func (m *Metrics) Validate() error {
 }
 
 // ValidateUpdate performs basic validation of the Metrics object, but with
-// the logic of Delta and Value switched. It checks that the ID field is not
-// empty and that the MType field is either "counter" or "gauge". If either of
-// these conditions are not met, an error will be returned.
-func (m *Metrics) ValidateUpdate() error {
-	if m.ID == "" {
-		return errormsg.ErrMetricEmptyName
+// the logic of Delta and Value switched. It checks that the ID field is a
+// valid name of at most maxNameLength bytes and that the MType field is either
+// "counter", "gauge" or "histogram".
+// If either of these conditions are not met, an error will be returned.
+//
+// The histogram update carries the observed value in the Value field.
+func (m *Metrics) ValidateUpdate(maxNameLength int) error {
+	if err := ValidateName(m.ID, maxNameLength); err != nil {
+		return err
 	}
 
 	switch m.MType {
@@ -46,7 +206,7 @@ func (m *Metrics) ValidateUpdate() error {
 			return errormsg.ErrMetricEmptyDelta
 		}
 
-	case "gauge":
+	case "gauge", "histogram":
 		if m.Value == nil {
 			return errormsg.ErrMetricEmptyValue
 		}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+)
+
+func TestMetricsValidateNameLength(t *testing.T) {
+	value := 1.5
+
+	testCases := []struct {
+		wantErr   error
+		name      string
+		maxLength int
+		length    int
+	}{
+		{name: "DefaultAtLimit", maxLength: DefaultMaxNameLength, length: DefaultMaxNameLength},
+		{name: "DefaultOverLimit", maxLength: DefaultMaxNameLength, length: DefaultMaxNameLength + 1, wantErr: errormsg.ErrMetricNameTooLong},
+		{name: "CustomAtLimit", maxLength: 10, length: 10},
+		{name: "CustomOverLimit", maxLength: 10, length: 11, wantErr: errormsg.ErrMetricNameTooLong},
+		{name: "Unlimited", maxLength: 0, length: 4 * DefaultMaxNameLength},
+		{name: "Empty", maxLength: 0, length: 0, wantErr: errormsg.ErrMetricEmptyName},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metric := Metrics{ID: strings.Repeat("a", tc.length), MType: "gauge", Value: &value}
+
+			if tc.wantErr == nil {
+				require.NoError(t, metric.Validate(tc.maxLength))
+				require.NoError(t, metric.ValidateUpdate(tc.maxLength))
+
+				return
+			}
+
+			require.ErrorIs(t, metric.Validate(tc.maxLength), tc.wantErr)
+			require.ErrorIs(t, metric.ValidateUpdate(tc.maxLength), tc.wantErr)
+		})
+	}
+}
+
+func TestMetricsValidateNameCharacters(t *testing.T) {
+	value := 1.5
+
+	testCases := []struct {
+		wantErr error
+		name    string
+		id      string
+	}{
+		{name: "Plain", id: "HeapAlloc"},
+		{name: "Punctuation", id: "http.requests_total{code=200}"},
+		{name: "Unicode", id: "температура"},
+		{name: "Space", id: "heap alloc"},
+		{name: "Newline", id: "Heap\nAlloc", wantErr: errormsg.ErrMetricInvalidName},
+		{name: "Tab", id: "Heap\tAlloc", wantErr: errormsg.ErrMetricInvalidName},
+		{name: "NUL", id: "HeapAlloc\x00", wantErr: errormsg.ErrMetricInvalidName},
+		{name: "DEL", id: "\x7fHeapAlloc", wantErr: errormsg.ErrMetricInvalidName},
+		{name: "C1Control", id: "Heap\u0085Alloc", wantErr: errormsg.ErrMetricInvalidName},
+		{name: "InvalidUTF8", id: "Heap\xffAlloc", wantErr: errormsg.ErrMetricInvalidName},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metric := Metrics{ID: tc.id, MType: "gauge", Value: &value}
+
+			if tc.wantErr == nil {
+				require.NoError(t, metric.Validate(DefaultMaxNameLength))
+				require.NoError(t, metric.ValidateUpdate(DefaultMaxNameLength))
+
+				return
+			}
+
+			require.ErrorIs(t, metric.Validate(DefaultMaxNameLength), tc.wantErr)
+			require.ErrorIs(t, metric.ValidateUpdate(DefaultMaxNameLength), tc.wantErr)
+		})
+	}
+}
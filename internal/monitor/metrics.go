@@ -2,6 +2,7 @@
 package monitor
 
 import (
+	"errors"
 	"math/rand"
 	"runtime"
 	"strconv"
@@ -13,9 +14,14 @@ import (
 
 type MetricType string
 
+// errNoCPUStats is the collection error of the CPU utilization metric when
+// gopsutil returns no CPU stats.
+var errNoCPUStats = errors.New("no cpu stats")
+
 const (
-	MetricCounter MetricType = "counter"
-	MetricGauge   MetricType = "gauge"
+	MetricCounter   MetricType = "counter"
+	MetricGauge     MetricType = "gauge"
+	MetricHistogram MetricType = "histogram"
 )
 
 type baseMetric struct {
@@ -32,6 +38,14 @@ func (m *baseMetric) GetKind() string {
 	return string(m.kind)
 }
 
+// Describe returns the metric descriptor with no help and unit.
+func (m *baseMetric) Describe() MetricDesc {
+	return MetricDesc{
+		Name: m.name,
+		Kind: string(m.kind),
+	}
+}
+
 type CounterMetric struct {
 	baseMetric
 	value int64
@@ -67,6 +81,14 @@ func (m *CounterMetric) Collect() {
 	m.value++
 }
 
+// Add adds delta to the counter value.
+func (m *CounterMetric) Add(delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.value += delta
+}
+
 func (m *CounterMetric) Reset() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -102,6 +124,29 @@ func (m *GaugeMetric) GetValueString() string {
 	return strconv.FormatFloat(m.value, 'f', -1, 64)
 }
 
+// SystemMetric is a gauge of the system stats collected with gopsutil.
+//
+// The collection fails on the platforms gopsutil does not support, so the
+// metric keeps the error of the last collection along with the last value.
+type SystemMetric struct {
+	GaugeMetric
+	err error
+}
+
+func newSystemMetric(name string) SystemMetric {
+	return SystemMetric{
+		GaugeMetric: newGaugeMetric(name),
+	}
+}
+
+// CollectError returns the error of the last collection.
+func (m *SystemMetric) CollectError() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
+
 type MemStatsMetric struct {
 	source *runtime.MemStats
 	GaugeMetric
@@ -152,18 +197,58 @@ type (
 	}
 
 	TotalMemory struct {
-		GaugeMetric
+		SystemMetric
 	}
 
 	FreeMemory struct {
-		GaugeMetric
+		SystemMetric
 	}
 
 	CPUutilization struct {
-		GaugeMetric
+		SystemMetric
+
+		// prev is the CPU times sample of the previous collection.
+		prev *cpu.TimesStat
+	}
+
+	HTTPConnReused struct {
+		CounterMetric
+	}
+
+	HTTPConnNew struct {
+		CounterMetric
+	}
+
+	ReportQueueBlockedNs struct {
+		CounterMetric
 	}
 )
 
+// selfMetrics is a set of metrics the monitor collects about itself.
+type selfMetrics struct {
+	connReused   *HTTPConnReused
+	connNew      *HTTPConnNew
+	queueBlocked *ReportQueueBlockedNs
+}
+
+// newSelfMetrics creates the self metrics with names prefixed by prefix.
+func newSelfMetrics(prefix string) *selfMetrics {
+	return &selfMetrics{
+		connReused:   newHTTPConnReusedMetric(prefix),
+		connNew:      newHTTPConnNewMetric(prefix),
+		queueBlocked: newReportQueueBlockedNsMetric(prefix),
+	}
+}
+
+// metrics returns the self metrics as a list.
+func (s *selfMetrics) metrics() []Metric {
+	return []Metric{
+		s.connReused,
+		s.connNew,
+		s.queueBlocked,
+	}
+}
+
 func newAllocMetric(source *runtime.MemStats) *Alloc {
 	m := Alloc(newMemStatsMetric("Alloc", source))
 	return &m
@@ -509,7 +594,7 @@ func newPollCountMetric() *PollCount {
 
 func newTotalMemoryMetric() *TotalMemory {
 	return &TotalMemory{
-		GaugeMetric: newGaugeMetric("TotalMemory"),
+		SystemMetric: newSystemMetric("TotalMemory"),
 	}
 }
 
@@ -518,6 +603,8 @@ func (m *TotalMemory) Collect() {
 	defer m.mu.Unlock()
 
 	v, err := mem.VirtualMemory()
+	m.err = err
+
 	if err != nil {
 		return
 	}
@@ -527,7 +614,7 @@ func (m *TotalMemory) Collect() {
 
 func newFreeMemoryMetric() *FreeMemory {
 	return &FreeMemory{
-		GaugeMetric: newGaugeMetric("FreeMemory"),
+		SystemMetric: newSystemMetric("FreeMemory"),
 	}
 }
 
@@ -536,6 +623,8 @@ func (m *FreeMemory) Collect() {
 	defer m.mu.Unlock()
 
 	v, err := mem.VirtualMemory()
+	m.err = err
+
 	if err != nil {
 		return
 	}
@@ -545,18 +634,82 @@ func (m *FreeMemory) Collect() {
 
 func newCPUutilizationMetric() *CPUutilization {
 	return &CPUutilization{
-		GaugeMetric: newGaugeMetric("CPUutilization"),
+		SystemMetric: newSystemMetric("CPUutilization"),
 	}
 }
 
+// Collect computes the CPU utilization over the time passed since the
+// previous collection. The first collection only takes the CPU times sample.
 func (m *CPUutilization) Collect() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	v, err := cpu.Percent(0, false)
+	v, err := cpu.Times(false)
+	if err == nil && len(v) == 0 {
+		err = errNoCPUStats
+	}
+
+	m.err = err
+
 	if err != nil {
 		return
 	}
 
-	m.value = v[0]
+	if m.prev != nil {
+		m.value = cpuUtilization(*m.prev, v[0])
+	}
+
+	m.prev = &v[0]
 }
+
+// cpuUtilization returns the percentage of the busy CPU time between
+// the two CPU times samples.
+func cpuUtilization(prev, cur cpu.TimesStat) float64 {
+	prevBusy, prevTotal := cpuBusy(prev)
+	curBusy, curTotal := cpuBusy(cur)
+
+	if curTotal <= prevTotal {
+		return 0
+	}
+
+	if curBusy <= prevBusy {
+		return 0
+	}
+
+	return min(100, (curBusy-prevBusy)/(curTotal-prevTotal)*100)
+}
+
+// cpuBusy returns the busy and the total CPU time of the sample. The guest
+// time is already accounted in the user time.
+func cpuBusy(t cpu.TimesStat) (float64, float64) {
+	total := t.User + t.System + t.Idle + t.Nice + t.Iowait + t.Irq + t.Softirq + t.Steal
+
+	return total - t.Idle - t.Iowait, total
+}
+
+func newHTTPConnReusedMetric(prefix string) *HTTPConnReused {
+	return &HTTPConnReused{
+		CounterMetric: newCounterMetric(prefix + "HTTPConnReused"),
+	}
+}
+
+// Collect is a no-op: the metric is updated by the reporter on each request.
+func (m *HTTPConnReused) Collect() {}
+
+func newHTTPConnNewMetric(prefix string) *HTTPConnNew {
+	return &HTTPConnNew{
+		CounterMetric: newCounterMetric(prefix + "HTTPConnNew"),
+	}
+}
+
+// Collect is a no-op: the metric is updated by the reporter on each request.
+func (m *HTTPConnNew) Collect() {}
+
+func newReportQueueBlockedNsMetric(prefix string) *ReportQueueBlockedNs {
+	return &ReportQueueBlockedNs{
+		CounterMetric: newCounterMetric(prefix + "ReportQueueBlockedNs"),
+	}
+}
+
+// Collect is a no-op: the metric is updated by the reporter on each report.
+func (m *ReportQueueBlockedNs) Collect() {}
@@ -4,6 +4,7 @@ import (
 	"runtime"
 	"testing"
 
+	"github.com/shirou/gopsutil/v4/cpu"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -228,3 +229,76 @@ func TestRandomValueMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestDescribeMetric(t *testing.T) {
+	source := &runtime.MemStats{}
+
+	testCases := []struct {
+		metric Metric
+		want   MetricDesc
+		name   string
+	}{
+		{
+			name:   "Gauge",
+			metric: newAllocMetric(source),
+			want:   MetricDesc{Name: "Alloc", Kind: "gauge"},
+		},
+		{
+			name:   "Counter",
+			metric: newPollCountMetric(),
+			want:   MetricDesc{Name: "PollCount", Kind: "counter"},
+		},
+		{
+			name:   "SelfMetric",
+			metric: newHTTPConnNewMetric("Agent"),
+			want:   MetricDesc{Name: "AgentHTTPConnNew", Kind: "counter"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Implements(t, (*Describer)(nil), tc.metric)
+			assert.Equal(t, tc.want, DescribeMetric(tc.metric))
+		})
+	}
+}
+
+func TestCPUutilization(t *testing.T) {
+	prev := cpu.TimesStat{User: 100, System: 50, Idle: 800, Iowait: 50}
+
+	testCases := []struct {
+		name string
+		cur  cpu.TimesStat
+		want float64
+	}{
+		{"HalfBusy", cpu.TimesStat{User: 150, System: 100, Idle: 900, Iowait: 50}, 50},
+		{"Idle", cpu.TimesStat{User: 100, System: 50, Idle: 900, Iowait: 50}, 0},
+		{"IowaitIsIdle", cpu.TimesStat{User: 100, System: 50, Idle: 800, Iowait: 150}, 0},
+		{"NoTimePassed", prev, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.InDelta(t, tc.want, cpuUtilization(prev, tc.cur), 1e-9)
+		})
+	}
+
+	// The utilization is computed over the window between the collections.
+	m := newCPUutilizationMetric()
+
+	m.Collect()
+
+	if m.CollectError() != nil {
+		t.Skip("cpu times are not supported on this platform")
+	}
+
+	assert.NotNil(t, m.prev)
+	assert.Zero(t, m.GetValue())
+
+	m.Collect()
+
+	value, ok := m.GetValue().(float64)
+	assert.True(t, ok)
+	assert.GreaterOrEqual(t, value, 0.0)
+	assert.LessOrEqual(t, value, 100.0)
+}
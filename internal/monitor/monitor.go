@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -12,13 +13,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	mathrand "math/rand/v2"
 	"net"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/klauspost/compress/zstd"
 	"go.uber.org/zap"
 
 	"github.com/andymarkow/go-metrics-collector/internal/cryptutils"
@@ -41,6 +45,53 @@ type Reseter interface {
 	Reset()
 }
 
+// CollectErrorer is an interface for metrics whose collection may fail,
+// e.g. the system metrics on the platforms gopsutil does not support.
+type CollectErrorer interface {
+	CollectError() error
+}
+
+// MetricDesc is a metric descriptor holding the metric metadata.
+type MetricDesc struct {
+	// Name is the metric name.
+	Name string
+	// Kind is the metric type: counter, gauge or histogram.
+	Kind string
+	// Help is the metric description. It may be empty.
+	Help string
+	// Unit is the metric value unit, e.g. "bytes". It may be empty.
+	Unit string
+}
+
+// Describer is an interface for metrics that can describe themselves,
+// so the exporters can render the metrics metadata without a registry.
+type Describer interface {
+	Describe() MetricDesc
+}
+
+// DescribeMetric returns the descriptor of the metric. If the metric does
+// not implement Describer, the descriptor holds the name and kind only.
+func DescribeMetric(m Metric) MetricDesc {
+	if d, ok := m.(Describer); ok {
+		return d.Describe()
+	}
+
+	return MetricDesc{
+		Name: m.GetName(),
+		Kind: m.GetKind(),
+	}
+}
+
+// Supported compression algorithms of the updates request payload.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// ErrUpdateRejected is returned when the server responds to metrics update
+// with an unsuccessful status code.
+var ErrUpdateRejected = errors.New("metrics update rejected by server")
+
 // Monitor is a metrics monitor.
 type Monitor struct {
 	log            *zap.Logger
@@ -48,11 +99,33 @@ type Monitor struct {
 	memstat        *runtime.MemStats
 	cryptoPubKey   *rsa.PublicKey
 	signKey        []byte
+	signPrivKey    ed25519.PrivateKey
+	serverAddr     string
+	updatesPath    string
+	compression    string
+	selfPrefix     string
 	metrics        []Metric
 	gopsutilstats  []Metric
+	gopsutilMu     sync.RWMutex
+	selfstats      *selfMetrics
+	collectMu      sync.Mutex
 	pollInterval   time.Duration
+	pollJitter     time.Duration
 	reportInterval time.Duration
 	rateLimit      int
+	http2          bool
+
+	// gopsutilFailures is the number of consecutive collection failures
+	// of the system metrics by name.
+	gopsutilFailures map[string]int
+	failureThreshold int
+	dropFailing      bool
+
+	// flushTimeout bounds the final flush of the metrics on shutdown.
+	flushTimeout time.Duration
+
+	// gopsutilInterval is the system metrics collection interval.
+	gopsutilInterval time.Duration
 }
 
 // NewMonitor creates a new Monitor with the given options.
@@ -95,6 +168,14 @@ type Monitor struct {
 //   - FreeMemory: The amount of free memory on the system.
 //   - TotalMemory: The total amount of memory on the system.
 //
+// The Monitor also reports its own metrics, prefixed with "agent_" by default
+// to not collide with the application metrics names:
+//
+//   - HTTPConnReused: The number of requests sent over a reused connection.
+//   - HTTPConnNew: The number of requests that required a new connection.
+//   - ReportQueueBlockedNs: The time in nanoseconds the reporter has been
+//     blocked waiting for the report workers to take the metrics.
+//
 // The Monitor also has the following options:
 //
 //   - HTTP client: The Monitor uses a custom HTTP client with a retry strategy
@@ -144,14 +225,19 @@ func NewMonitor(opts ...Option) *Monitor {
 		newCPUutilizationMetric(),
 	)
 
-	client := httpclient.NewHTTPClient()
-
 	mon := &Monitor{
 		log:           zap.Must(zap.NewDevelopment()),
-		client:        client,
 		memstat:       &memstat,
+		updatesPath:   "/updates",
+		compression:   CompressionGzip,
 		metrics:       metrics,
 		gopsutilstats: gopsutilstats,
+		selfPrefix:    "agent_",
+		rateLimit:     1,
+
+		gopsutilFailures: make(map[string]int),
+		failureThreshold: 3,
+		flushTimeout:     5 * time.Second,
 	}
 
 	// Apply options.
@@ -159,13 +245,34 @@ func NewMonitor(opts ...Option) *Monitor {
 		opt(mon)
 	}
 
+	mon.selfstats = newSelfMetrics(mon.selfPrefix)
+
+	// Keep an idle connection per report worker so that every worker
+	// reuses its connection instead of dialing a new one on each request.
+	clientOpts := []httpclient.Option{
+		httpclient.WithMaxIdleConnsPerHost(mon.rateLimit),
+		httpclient.WithHTTP2(mon.http2),
+		httpclient.WithBackoff(1*time.Second, 10*time.Second),
+	}
+
+	baseURL := mon.serverAddr
+
+	// The requests to the server on a Unix domain socket are sent with
+	// a placeholder host, as the client dials the socket anyway.
+	if path, ok := httpclient.UnixSocketPath(mon.serverAddr); ok {
+		clientOpts = append(clientOpts, httpclient.WithUnixSocket(path))
+		baseURL = "http://localhost"
+	}
+
+	client := httpclient.NewHTTPClient(clientOpts...)
+
+	mon.client = client
+
 	// Configure the retry strategy.
 	client.
+		SetBaseURL(baseURL).
 		SetLogger(mon.log.Sugar()).
-		SetRetryCount(3).                  // Number of retry attempts
-		SetRetryWaitTime(1 * time.Second). // Initial wait time between retries
-		SetRetryMaxWaitTime(10 * time.Second).
-		SetRetryAfter(retryAfterWithInterval(2)).
+		SetRetryCount(3). // Number of retry attempts
 		AddRetryCondition(func(_ *resty.Response, err error) bool {
 			// Retry for retryable errors.
 			return isRetryableError(err)
@@ -174,13 +281,6 @@ func NewMonitor(opts ...Option) *Monitor {
 	return mon
 }
 
-// retryAfterWithInterval returns duration intervals between retries.
-func retryAfterWithInterval(retryWaitInterval int) resty.RetryAfterFunc {
-	return func(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
-		return time.Duration((resp.Request.Attempt*retryWaitInterval - 1)) * time.Second, nil
-	}
-}
-
 // Option is a monitor option.
 type Option func(m *Monitor)
 
@@ -194,7 +294,23 @@ func WithLogger(logger *zap.Logger) Option {
 // WithServerAddr is a monitor option that sets server address.
 func WithServerAddr(addr string) Option {
 	return func(m *Monitor) {
-		m.client.SetBaseURL(addr)
+		m.serverAddr = addr
+	}
+}
+
+// WithUpdatesPath is a monitor option that sets the HTTP path of the batch
+// updates endpoint. It allows reaching a server mounted under a route prefix.
+func WithUpdatesPath(path string) Option {
+	return func(m *Monitor) {
+		m.updatesPath = path
+	}
+}
+
+// WithSelfMetricsPrefix is a monitor option that sets the name prefix
+// of the metrics the monitor reports about itself.
+func WithSelfMetricsPrefix(prefix string) Option {
+	return func(m *Monitor) {
+		m.selfPrefix = prefix
 	}
 }
 
@@ -205,6 +321,14 @@ func WithSignKey(signKey []byte) Option {
 	}
 }
 
+// WithSignPrivateKey is a monitor option that sets the ed25519 private key
+// the payload is signed with instead of the sign key.
+func WithSignPrivateKey(key ed25519.PrivateKey) Option {
+	return func(m *Monitor) {
+		m.signPrivKey = key
+	}
+}
+
 // WithCryptoPubKey is a monitor option that sets crypto public key.
 func WithCryptoPubKey(cryptoPubKey *rsa.PublicKey) Option {
 	return func(m *Monitor) {
@@ -219,6 +343,25 @@ func WithPollInterval(pollInterval time.Duration) Option {
 	}
 }
 
+// WithGopsutilInterval is a monitor option that sets the interval of the
+// system metrics collection, so the relatively expensive gopsutil calls are
+// made less often than the runtime metrics are polled. The CPU utilization
+// is computed over this interval.
+func WithGopsutilInterval(interval time.Duration) Option {
+	return func(m *Monitor) {
+		m.gopsutilInterval = interval
+	}
+}
+
+// WithPollJitter is a monitor option that sets the maximum random delay
+// of the collection after each poll tick. It keeps the co-located agents from
+// stopping the world with ReadMemStats at the same moment. Zero disables it.
+func WithPollJitter(jitter time.Duration) Option {
+	return func(m *Monitor) {
+		m.pollJitter = jitter
+	}
+}
+
 // WithReportInterval is a monitor option that sets report interval.
 func WithReportInterval(reportInterval time.Duration) Option {
 	return func(m *Monitor) {
@@ -227,9 +370,55 @@ func WithReportInterval(reportInterval time.Duration) Option {
 }
 
 // WithRateLimit is a monitor option that sets rate limit.
+//
+// The rate limit is the number of simultaneous outgoing requests to the
+// server. Values lower than 1 fall back to a single request at a time,
+// since no requests at all would block the reporter forever.
 func WithRateLimit(rateLimit int) Option {
 	return func(m *Monitor) {
-		m.rateLimit = rateLimit
+		m.rateLimit = max(rateLimit, 1)
+	}
+}
+
+// WithCompression is a monitor option that sets the compression algorithm
+// of the updates request payload: gzip (default) or zstd.
+func WithCompression(algo string) Option {
+	return func(m *Monitor) {
+		m.compression = algo
+	}
+}
+
+// WithHTTP2 is a monitor option that enables HTTP/2 transport for requests
+// to the remote server. It falls back to HTTP/1.1 if the server does not support it.
+func WithHTTP2(enabled bool) Option {
+	return func(m *Monitor) {
+		m.http2 = enabled
+	}
+}
+
+// WithFailureThreshold is a monitor option that sets the number of
+// consecutive collection failures of a system metric after which a warning
+// is logged and, if enabled, the metric is unregistered.
+func WithFailureThreshold(n int) Option {
+	return func(m *Monitor) {
+		m.failureThreshold = n
+	}
+}
+
+// WithDropFailingMetrics is a monitor option that makes the monitor stop
+// reporting the system metrics that keep failing to be collected, instead
+// of reporting their last (possibly zero) value forever.
+func WithDropFailingMetrics(enabled bool) Option {
+	return func(m *Monitor) {
+		m.dropFailing = enabled
+	}
+}
+
+// WithFlushTimeout is a monitor option that sets the time limit of the final
+// flush of the metrics to the remote server on shutdown.
+func WithFlushTimeout(timeout time.Duration) Option {
+	return func(m *Monitor) {
+		m.flushTimeout = timeout
 	}
 }
 
@@ -244,14 +433,26 @@ func (m *Monitor) RunCollector(ctx context.Context) {
 			return
 
 		case <-pollTicker.C:
+			if !m.waitPollJitter(ctx) {
+				return
+			}
+
 			m.collect()
 		}
 	}
 }
 
-// RunCollectorGopsutils runs the collector.
+// RunCollectorGopsutils runs the collector of the system metrics.
+//
+// The system metrics are collected every gopsutil interval, which falls back
+// to the poll interval if not set.
 func (m *Monitor) RunCollectorGopsutils(ctx context.Context) {
-	pollTicker := time.NewTicker(m.pollInterval)
+	interval := m.gopsutilInterval
+	if interval <= 0 {
+		interval = m.pollInterval
+	}
+
+	pollTicker := time.NewTicker(interval)
 	defer pollTicker.Stop()
 
 	for {
@@ -260,18 +461,99 @@ func (m *Monitor) RunCollectorGopsutils(ctx context.Context) {
 			return
 
 		case <-pollTicker.C:
-			for _, v := range m.gopsutilstats {
-				v.Collect()
+			if !m.waitPollJitter(ctx) {
+				return
 			}
+
+			m.collectGopsutils()
 		}
 	}
 }
 
+// collectGopsutils collects the system metrics.
+//
+// A warning is logged once a metric fails to be collected failureThreshold
+// times in a row. The failing metric is unregistered if dropFailing is set.
+func (m *Monitor) collectGopsutils() {
+	m.gopsutilMu.Lock()
+	defer m.gopsutilMu.Unlock()
+
+	kept := make([]Metric, 0, len(m.gopsutilstats))
+
+	for _, v := range m.gopsutilstats {
+		v.Collect()
+
+		if m.collectFailed(v) && m.dropFailing {
+			m.log.Warn("Unregistering failing system metric", zap.String("metric", v.GetName()))
+
+			delete(m.gopsutilFailures, v.GetName())
+
+			continue
+		}
+
+		kept = append(kept, v)
+	}
+
+	m.gopsutilstats = kept
+}
+
+// collectFailed accounts the last collection error of the metric and reports
+// whether the metric has failed failureThreshold times in a row.
+func (m *Monitor) collectFailed(metric Metric) bool {
+	e, ok := metric.(CollectErrorer)
+	if !ok {
+		return false
+	}
+
+	err := e.CollectError()
+	if err == nil {
+		delete(m.gopsutilFailures, metric.GetName())
+
+		return false
+	}
+
+	m.gopsutilFailures[metric.GetName()]++
+
+	failures := m.gopsutilFailures[metric.GetName()]
+
+	if failures == m.failureThreshold {
+		m.log.Warn("System metric collection keeps failing",
+			zap.String("metric", metric.GetName()),
+			zap.Int("failures", failures),
+			zap.Error(err),
+		)
+	}
+
+	return failures >= m.failureThreshold
+}
+
+// waitPollJitter waits for a random delay up to the poll jitter.
+// It returns false if ctx is done while waiting.
+func (m *Monitor) waitPollJitter(ctx context.Context) bool {
+	if m.pollJitter <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(mathrand.N(m.pollJitter))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
 // RunReporter runs the reporter.
 //
 // It starts a ticker that triggers every reportInterval.
 // When the ticker triggers, it calls ReportMetrics with the metrics
 // from the monitor and the gopsutil metrics.
+//
+// On shutdown it collects a final sample before flushing the metrics,
+// so the values polled after the last report tick are not lost. The flush
+// is bounded by flushTimeout, as ctx is already done by then.
 func (m *Monitor) RunReporter(ctx context.Context) {
 	reportTicker := time.NewTicker(m.reportInterval)
 	defer reportTicker.Stop()
@@ -282,18 +564,58 @@ func (m *Monitor) RunReporter(ctx context.Context) {
 			m.log.Info("Stopping metrics reporter")
 			m.log.Info("Flushing metrics to remote server")
 
-			m.reportMetrics(append(m.metrics, m.gopsutilstats...))
+			m.collect()
+
+			flushCtx, cancel := context.WithTimeout(context.Background(), m.flushTimeout)
+			sent, dropped := m.reportMetrics(flushCtx, m.reportedMetrics())
+
+			cancel()
+
+			m.log.Info("Metrics flushed to remote server",
+				zap.Int("flushed", sent),
+				zap.Int("dropped", dropped),
+			)
 
 			return
 
 		case <-reportTicker.C:
-			m.reportMetrics(append(m.metrics, m.gopsutilstats...))
+			m.reportMetrics(context.Background(), m.reportedMetrics())
 		}
 	}
 }
 
+// RunOnce collects all the metrics a single time and reports them.
+//
+// It returns once the metrics have been sent to the remote server.
+func (m *Monitor) RunOnce() {
+	m.collect()
+	m.collectGopsutils()
+
+	m.reportMetrics(context.Background(), m.reportedMetrics())
+}
+
+// reportedMetrics returns all the metrics to be reported to the remote server.
+func (m *Monitor) reportedMetrics() []Metric {
+	m.gopsutilMu.RLock()
+	defer m.gopsutilMu.RUnlock()
+
+	metrics := make([]Metric, 0, len(m.metrics)+len(m.gopsutilstats)+len(m.selfstats.metrics()))
+
+	metrics = append(metrics, m.metrics...)
+	metrics = append(metrics, m.gopsutilstats...)
+	metrics = append(metrics, m.selfstats.metrics()...)
+
+	return metrics
+}
+
 // Collect collects metrics.
+//
+// It is guarded by a mutex as the reporter collects the final sample on
+// shutdown concurrently with the collector.
 func (m *Monitor) collect() {
+	m.collectMu.Lock()
+	defer m.collectMu.Unlock()
+
 	runtime.ReadMemStats(m.memstat)
 
 	for _, v := range m.metrics {
@@ -302,30 +624,45 @@ func (m *Monitor) collect() {
 }
 
 // ReportMetrics pushes metrics to the remote server.
-func (m *Monitor) reportMetrics(metrics []Metric) {
+//
+// It returns the number of metrics sent and the number of metrics dropped
+// because their batch failed to be sent, e.g. when ctx is done.
+func (m *Monitor) reportMetrics(ctx context.Context, metrics []Metric) (int, int) {
 	metricsChan := make(chan Metric, m.rateLimit)
 
 	wg := &sync.WaitGroup{}
 
+	var sent, dropped atomic.Int64
+
 	// Spawn workers
 	for w := 1; w <= m.rateLimit; w++ {
 		wg.Add(1)
-		go m.reportWorker(wg, metricsChan)
+		go m.reportWorker(ctx, wg, metricsChan, &sent, &dropped)
 	}
 
-	// Send metrics to the metrics channel
+	// Send metrics to the metrics channel, accounting the time spent
+	// waiting for the busy workers.
 	for _, v := range metrics {
-		metricsChan <- v
+		select {
+		case metricsChan <- v:
+		default:
+			start := time.Now()
+			metricsChan <- v
+			m.selfstats.queueBlocked.Add(time.Since(start).Nanoseconds())
+		}
 	}
 
 	// Close channel and send signal to stop workers
 	close(metricsChan)
 
 	wg.Wait()
+
+	return int(sent.Load()), int(dropped.Load())
 }
 
-// reportWorker sends metrics to the remote server.
-func (m *Monitor) reportWorker(wg *sync.WaitGroup, metricsChan <-chan Metric) {
+// reportWorker sends metrics to the remote server and accounts the number
+// of the sent and dropped metrics.
+func (m *Monitor) reportWorker(ctx context.Context, wg *sync.WaitGroup, metricsChan <-chan Metric, sent, dropped *atomic.Int64) {
 	defer wg.Done()
 
 	const batchSize int = 100
@@ -365,12 +702,15 @@ func (m *Monitor) reportWorker(wg *sync.WaitGroup, metricsChan <-chan Metric) {
 			})
 		}
 
-		// Batch size limit
+		// Batch size limit. The batch failed to be sent is dropped like the
+		// last one, so that it is not resent with the next batch.
 		if len(metrics) >= batchSize {
-			if err := m.sendRequest(metrics); err != nil {
+			if err := m.sendRequest(ctx, metrics); err != nil {
 				m.log.Error("sendRequest: " + err.Error())
 
-				continue
+				dropped.Add(int64(len(metrics)))
+			} else {
+				sent.Add(int64(len(metrics)))
 			}
 
 			// Flush slice
@@ -384,9 +724,15 @@ func (m *Monitor) reportWorker(wg *sync.WaitGroup, metricsChan <-chan Metric) {
 	}
 
 	if len(metrics) > 0 {
-		if err := m.sendRequest(metrics); err != nil {
+		if err := m.sendRequest(ctx, metrics); err != nil {
 			m.log.Error("sendRequest: " + err.Error())
+
+			dropped.Add(int64(len(metrics)))
+
+			return
 		}
+
+		sent.Add(int64(len(metrics)))
 	}
 }
 
@@ -429,7 +775,7 @@ func (m *Monitor) Report() {
 
 		// Batch limit
 		if len(metrics) >= batchSize {
-			if err := m.sendRequest(metrics); err != nil {
+			if err := m.sendRequest(context.Background(), metrics); err != nil {
 				m.log.Error("sendRequest: " + err.Error())
 
 				continue
@@ -445,29 +791,97 @@ func (m *Monitor) Report() {
 	}
 
 	if len(metrics) > 0 {
-		if err := m.sendRequest(metrics); err != nil {
+		if err := m.sendRequest(context.Background(), metrics); err != nil {
 			m.log.Error("sendRequest: " + err.Error())
 		}
 	}
 }
 
 // sendRequest sends metrics to the remote server.
-func (m *Monitor) sendRequest(metrics []models.Metrics) error {
+func (m *Monitor) sendRequest(ctx context.Context, metrics []models.Metrics) error {
+	req, err := m.newUpdatesRequest(metrics)
+	if err != nil {
+		return err
+	}
+
+	// Send payload data to the remote server.
+	resp, err := req.SetContext(ctx).Post(m.updatesPath)
+	if err != nil {
+		return fmt.Errorf("client.Request: %w", err)
+	}
+
+	m.traceConn(resp)
+
+	return nil
+}
+
+// UpdateMetricsSync sends metrics to the remote server and waits for
+// the server to confirm the number of metrics it has stored. The confirmation
+// is requested with the X-Updates-Result header.
+//
+// Unlike the background reporter, it returns an error if the server
+// does not respond with a successful status code.
+func (m *Monitor) UpdateMetricsSync(ctx context.Context, metrics []models.Metrics) (*models.UpdateMetricsResult, error) {
+	req, err := m.newUpdatesRequest(metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	result := new(models.UpdateMetricsResult)
+
+	resp, err := req.
+		SetContext(ctx).
+		SetHeader("Accept", "application/json").
+		SetHeader("X-Updates-Result", "json").
+		SetResult(result).
+		Post(m.updatesPath)
+	if err != nil {
+		return nil, fmt.Errorf("client.Request: %w", err)
+	}
+
+	m.traceConn(resp)
+
+	if resp.IsError() {
+		return nil, fmt.Errorf("%w: %s: %s", ErrUpdateRejected, resp.Status(), resp.String())
+	}
+
+	return result, nil
+}
+
+// newUpdatesRequest creates a request to the batch updates endpoint.
+//
+// The metrics payload is signed with the ed25519 private key or the sign
+// key, encrypted with the crypto public key and compressed with gzip or zstd.
+func (m *Monitor) newUpdatesRequest(metrics []models.Metrics) (*resty.Request, error) {
 	payload, err := json.Marshal(metrics)
 	if err != nil {
-		return fmt.Errorf("json.Marshal: %w", err)
+		return nil, fmt.Errorf("json.Marshal: %w", err)
 	}
 
-	// Calculate hash sum of the payload with a signature key.
-	if len(m.signKey) > 0 {
+	req := m.client.R().
+		EnableTrace().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Content-Encoding", m.compression)
+
+	// Sign the payload with the ed25519 private key if it is set, or
+	// calculate hash sum of the payload with a signature key otherwise.
+	switch {
+	case len(m.signPrivKey) > 0:
+		sign := signature.SignEd25519(m.signPrivKey, payload)
+
+		m.log.Debug("payload signature", zap.String("ed25519", hex.EncodeToString(sign)))
+
+		req.SetHeader("X-Signature-Ed25519", hex.EncodeToString(sign))
+
+	case len(m.signKey) > 0:
 		sign, err := signature.CalculateHashSum(m.signKey, payload)
 		if err != nil {
-			return fmt.Errorf("signPayload: %w", err)
+			return nil, fmt.Errorf("signPayload: %w", err)
 		}
 
 		m.log.Debug("payload signature", zap.String("hashsum", hex.EncodeToString(sign)))
 
-		m.client.SetHeader("HashSHA256", hex.EncodeToString(sign))
+		req.SetHeader("HashSHA256", hex.EncodeToString(sign))
 	}
 
 	// Encrypt payload data with a public RSA key.
@@ -475,28 +889,37 @@ func (m *Monitor) sendRequest(metrics []models.Metrics) error {
 
 	encryptedBody, err := cryptutils.EncryptOAEP(cryptoHash, rand.Reader, m.cryptoPubKey, payload, nil)
 	if err != nil {
-		return fmt.Errorf("cryptutils.EncryptOAEP: %w", err)
+		return nil, fmt.Errorf("cryptutils.EncryptOAEP: %w", err)
 	}
 
 	m.log.Debug("encrypted payload content", zap.Any("data", encryptedBody))
 
-	// Compress payload data with gzip compression method.
-	body, err := compressDataGzip(encryptedBody)
+	// Compress payload data with the configured compression method.
+	body, err := compressData(m.compression, encryptedBody)
 	if err != nil {
-		return fmt.Errorf("failed to compress payload data with gzip: %w", err)
+		return nil, fmt.Errorf("failed to compress payload data with %s: %w", m.compression, err)
 	}
 
-	// Send payload data to the remote server.
-	_, err = m.client.R().
-		SetHeader("Content-Type", "application/json").
-		SetHeader("Content-Encoding", "gzip").
-		SetBody(body).
-		Post("/updates")
-	if err != nil {
-		return fmt.Errorf("client.Request: %w", err)
+	return req.SetBody(body), nil
+}
+
+// traceConn records whether the request has been sent over a reused connection.
+func (m *Monitor) traceConn(resp *resty.Response) {
+	trace := resp.Request.TraceInfo()
+
+	if trace.IsConnReused {
+		m.selfstats.connReused.Add(1)
+	} else {
+		m.selfstats.connNew.Add(1)
 	}
 
-	return nil
+	m.log.Debug("connection trace",
+		zap.Bool("reused", trace.IsConnReused),
+		zap.Bool("was_idle", trace.IsConnWasIdle),
+		zap.Duration("conn_idle_time", trace.ConnIdleTime),
+		zap.Duration("conn_time", trace.ConnTime),
+		zap.Stringer("remote_addr", trace.RemoteAddr),
+	)
 }
 
 // isRetryableError checks if the error is a retryable error.
@@ -536,6 +959,18 @@ func isRetryableError(err error) bool {
 	return errors.As(err, &opErr)
 }
 
+// compressData compresses the given data using the compression algorithm.
+func compressData(algo string, data []byte) ([]byte, error) {
+	switch algo {
+	case CompressionGzip:
+		return compressDataGzip(data)
+	case CompressionZstd:
+		return compressDataZstd(data)
+	default:
+		return nil, fmt.Errorf("unsupported compression: %q", algo)
+	}
+}
+
 // compressDataGzip compresses the given data using gzip.
 //
 // The function writes the given data to a gzip writer and then closes the writer.
@@ -557,3 +992,13 @@ func compressDataGzip(data []byte) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// compressDataZstd compresses the given data using zstd.
+func compressDataZstd(data []byte) ([]byte, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("zstd.NewWriter: %w", err)
+	}
+
+	return enc.EncodeAll(data, nil), nil
+}
@@ -0,0 +1,651 @@
+package monitor
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/andymarkow/go-metrics-collector/internal/cryptutils"
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+	"github.com/andymarkow/go-metrics-collector/internal/signature"
+)
+
+func newTestPrivateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	return key
+}
+
+func TestSendRequestReusesConnection(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	key := newTestPrivateKey(t)
+
+	mon := NewMonitor(
+		WithLogger(zap.NewNop()),
+		WithServerAddr(ts.URL),
+		WithCryptoPubKey(&key.PublicKey),
+	)
+
+	val := 1.0
+	metrics := []models.Metrics{{ID: "testGauge", MType: "gauge", Value: &val}}
+
+	for range 3 {
+		require.NoError(t, mon.sendRequest(context.Background(), metrics))
+	}
+
+	assert.Equal(t, int64(1), mon.selfstats.connNew.GetValue())
+	assert.Equal(t, int64(2), mon.selfstats.connReused.GetValue())
+}
+
+func TestNewUpdatesRequestSignature(t *testing.T) {
+	key := newTestPrivateKey(t)
+
+	signPubKey, signPrivKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	val := 1.0
+	metrics := []models.Metrics{{ID: "testGauge", MType: "gauge", Value: &val}}
+
+	payload, err := json.Marshal(metrics)
+	require.NoError(t, err)
+
+	hashSum, err := signature.CalculateHashSum([]byte("secret"), payload)
+	require.NoError(t, err)
+
+	t.Run("HashSum", func(t *testing.T) {
+		mon := NewMonitor(
+			WithLogger(zap.NewNop()),
+			WithSignKey([]byte("secret")),
+			WithCryptoPubKey(&key.PublicKey),
+		)
+
+		req, err := mon.newUpdatesRequest(metrics)
+		require.NoError(t, err)
+
+		assert.Equal(t, hex.EncodeToString(hashSum), req.Header.Get("HashSHA256"))
+		assert.Empty(t, req.Header.Get("X-Signature-Ed25519"))
+	})
+
+	// The ed25519 private key takes precedence over the sign key.
+	t.Run("Ed25519", func(t *testing.T) {
+		mon := NewMonitor(
+			WithLogger(zap.NewNop()),
+			WithSignKey([]byte("secret")),
+			WithSignPrivateKey(signPrivKey),
+			WithCryptoPubKey(&key.PublicKey),
+		)
+
+		req, err := mon.newUpdatesRequest(metrics)
+		require.NoError(t, err)
+
+		sign, err := hex.DecodeString(req.Header.Get("X-Signature-Ed25519"))
+		require.NoError(t, err)
+
+		assert.True(t, signature.VerifyEd25519(signPubKey, payload, sign))
+		assert.Empty(t, req.Header.Get("HashSHA256"))
+	})
+}
+
+func TestReportMetricsZeroRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	key := newTestPrivateKey(t)
+
+	mon := NewMonitor(
+		WithLogger(zap.NewNop()),
+		WithServerAddr(ts.URL),
+		WithCryptoPubKey(&key.PublicKey),
+		WithRateLimit(0),
+	)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		mon.reportMetrics(context.Background(), mon.reportedMetrics())
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reportMetrics is blocked with zero rate limit")
+	}
+}
+
+func TestUpdateMetricsSync(t *testing.T) {
+	key := newTestPrivateKey(t)
+
+	val := 1.0
+	metrics := []models.Metrics{{ID: "testGauge", MType: "gauge", Value: &val}}
+
+	testCases := []struct {
+		name       string
+		response   string
+		statusCode int
+		want       *models.UpdateMetricsResult
+		wantErr    bool
+	}{
+		{"Confirmed", `{"updated": 1}`, http.StatusOK, &models.UpdateMetricsResult{Updated: 1}, false},
+		{"Rejected", "internal error", http.StatusInternalServerError, nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "application/json", r.Header.Get("Accept"))
+				assert.Equal(t, "json", r.Header.Get("X-Updates-Result"))
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.statusCode)
+				_, _ = w.Write([]byte(tc.response))
+			}))
+			defer ts.Close()
+
+			mon := NewMonitor(
+				WithLogger(zap.NewNop()),
+				WithServerAddr(ts.URL),
+				WithCryptoPubKey(&key.PublicKey),
+			)
+
+			result, err := mon.UpdateMetricsSync(context.Background(), metrics)
+			if tc.wantErr {
+				require.ErrorIs(t, err, ErrUpdateRejected)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, result)
+		})
+	}
+}
+
+func TestSelfMetricsPrefix(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		{
+			name: "DefaultPrefix",
+			want: []string{"agent_HTTPConnReused", "agent_HTTPConnNew", "agent_ReportQueueBlockedNs"},
+		},
+		{
+			name: "CustomPrefix",
+			opts: []Option{WithSelfMetricsPrefix("collector_")},
+			want: []string{"collector_HTTPConnReused", "collector_HTTPConnNew", "collector_ReportQueueBlockedNs"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mon := NewMonitor(append([]Option{WithLogger(zap.NewNop())}, tc.opts...)...)
+
+			names := make([]string, 0)
+			for _, m := range mon.selfstats.metrics() {
+				names = append(names, m.GetName())
+			}
+
+			assert.Equal(t, tc.want, names)
+		})
+	}
+}
+
+func TestRunReporterUsesReportInterval(t *testing.T) {
+	received := make(chan struct{}, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	key := newTestPrivateKey(t)
+
+	mon := NewMonitor(
+		WithLogger(zap.NewNop()),
+		WithServerAddr(ts.URL),
+		WithCryptoPubKey(&key.PublicKey),
+		WithReportInterval(1*time.Second),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		mon.RunReporter(ctx)
+	}()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(1500 * time.Millisecond):
+		t.Fatal("metrics batch is not reported within the report interval")
+	}
+}
+
+func TestRunReporterCollectsOnShutdown(t *testing.T) {
+	key := newTestPrivateKey(t)
+
+	var (
+		mu       sync.Mutex
+		reported = make(map[string]models.Metrics)
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+
+		encrypted, err := io.ReadAll(gz)
+		require.NoError(t, err)
+
+		payload, err := cryptutils.DecryptOAEP(sha256.New(), rand.Reader, key, encrypted, nil)
+		require.NoError(t, err)
+
+		var metrics []models.Metrics
+		require.NoError(t, json.Unmarshal(payload, &metrics))
+
+		mu.Lock()
+		for _, metric := range metrics {
+			reported[metric.ID] = metric
+		}
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	mon := NewMonitor(
+		WithLogger(zap.NewNop()),
+		WithServerAddr(ts.URL),
+		WithCryptoPubKey(&key.PublicKey),
+		WithReportInterval(1*time.Hour),
+	)
+
+	// The report tick never fires, so the metrics are only sent on shutdown.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	mon.RunReporter(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	pollCount, ok := reported["PollCount"]
+	require.True(t, ok, "PollCount is not reported")
+	require.NotNil(t, pollCount.Delta)
+	assert.Equal(t, int64(1), *pollCount.Delta)
+
+	alloc, ok := reported["Alloc"]
+	require.True(t, ok, "Alloc is not reported")
+	require.NotNil(t, alloc.Value)
+	assert.Positive(t, *alloc.Value)
+}
+
+func TestReportMetricsCountsQueueBlocking(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	key := newTestPrivateKey(t)
+
+	mon := NewMonitor(
+		WithLogger(zap.NewNop()),
+		WithServerAddr(ts.URL),
+		WithCryptoPubKey(&key.PublicKey),
+		WithRateLimit(1),
+	)
+
+	// More metrics than a single batch, so the only worker is busy sending
+	// the first batch while the reporter hands over the rest.
+	metrics := make([]Metric, 0, 150)
+	for range 150 {
+		metrics = append(metrics, newRandomValueMetric())
+	}
+
+	mon.reportMetrics(context.Background(), metrics)
+
+	blocked, ok := mon.selfstats.queueBlocked.GetValue().(int64)
+	require.True(t, ok)
+	assert.Positive(t, blocked)
+}
+
+func TestReportMetricsDropsFailedBatch(t *testing.T) {
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	key := newTestPrivateKey(t)
+
+	mon := NewMonitor(
+		WithLogger(zap.NewNop()),
+		WithServerAddr(ts.URL),
+		WithCryptoPubKey(&key.PublicKey),
+		WithRateLimit(1),
+	)
+
+	// The NaN value fails to be encoded, so the first full batch fails to
+	// be sent, while the last partial batch is sent.
+	invalid := newRandomValueMetric()
+	invalid.value = math.NaN()
+
+	metrics := []Metric{invalid}
+	for range 149 {
+		metrics = append(metrics, newRandomValueMetric())
+	}
+
+	sent, dropped := mon.reportMetrics(context.Background(), metrics)
+
+	assert.Equal(t, 50, sent)
+	assert.Equal(t, 100, dropped)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestRunOnce(t *testing.T) {
+	var requests atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	key := newTestPrivateKey(t)
+
+	mon := NewMonitor(
+		WithLogger(zap.NewNop()),
+		WithServerAddr(ts.URL),
+		WithCryptoPubKey(&key.PublicKey),
+	)
+
+	mon.RunOnce()
+
+	// The metrics are sent by the time RunOnce returns.
+	assert.Positive(t, requests.Load())
+
+	for _, m := range mon.metrics {
+		if m.GetName() == "PollCount" {
+			assert.Equal(t, int64(0), m.GetValue(), "PollCount is reset once reported")
+		}
+	}
+}
+
+func TestWaitPollJitter(t *testing.T) {
+	mon := NewMonitor(WithLogger(zap.NewNop()), WithPollJitter(50*time.Millisecond))
+
+	start := time.Now()
+
+	assert.True(t, mon.waitPollJitter(context.Background()))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// The wait is interrupted on shutdown.
+	mon = NewMonitor(WithLogger(zap.NewNop()), WithPollJitter(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.False(t, mon.waitPollJitter(ctx))
+
+	// Zero jitter does not wait at all.
+	mon = NewMonitor(WithLogger(zap.NewNop()))
+
+	assert.True(t, mon.waitPollJitter(ctx))
+}
+
+func TestSendRequestCompression(t *testing.T) {
+	key := newTestPrivateKey(t)
+
+	val := 1.0
+	metrics := []models.Metrics{{ID: "testGauge", MType: "gauge", Value: &val}}
+
+	testCases := []struct {
+		decompress func(r io.Reader) ([]byte, error)
+		name       string
+		algo       string
+	}{
+		{
+			name: "Gzip",
+			algo: CompressionGzip,
+			decompress: func(r io.Reader) ([]byte, error) {
+				gz, err := gzip.NewReader(r)
+				if err != nil {
+					return nil, err
+				}
+
+				return io.ReadAll(gz)
+			},
+		},
+		{
+			name: "Zstd",
+			algo: CompressionZstd,
+			decompress: func(r io.Reader) ([]byte, error) {
+				dec, err := zstd.NewReader(r)
+				if err != nil {
+					return nil, err
+				}
+				defer dec.Close()
+
+				return io.ReadAll(dec)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var reported []models.Metrics
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tc.algo, r.Header.Get("Content-Encoding"))
+
+				encrypted, err := tc.decompress(r.Body)
+				require.NoError(t, err)
+
+				payload, err := cryptutils.DecryptOAEP(sha256.New(), rand.Reader, key, encrypted, nil)
+				require.NoError(t, err)
+
+				require.NoError(t, json.Unmarshal(payload, &reported))
+
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+
+			mon := NewMonitor(
+				WithLogger(zap.NewNop()),
+				WithServerAddr(ts.URL),
+				WithCryptoPubKey(&key.PublicKey),
+				WithCompression(tc.algo),
+			)
+
+			require.NoError(t, mon.sendRequest(context.Background(), metrics))
+			assert.Equal(t, metrics, reported)
+		})
+	}
+}
+
+// failingMetric is a system metric that fails to be collected while
+// the fail flag is set.
+type failingMetric struct {
+	SystemMetric
+	fail bool
+}
+
+func (m *failingMetric) Collect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = nil
+
+	if m.fail {
+		m.err = errors.New("not implemented yet")
+	}
+}
+
+func TestCollectGopsutilsFailures(t *testing.T) {
+	testCases := []struct {
+		name        string
+		dropFailing bool
+		wantLen     int
+	}{
+		{"Keep", false, 2},
+		{"Drop", true, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+
+			mon := NewMonitor(
+				WithLogger(zap.New(core)),
+				WithFailureThreshold(3),
+				WithDropFailingMetrics(tc.dropFailing),
+			)
+
+			failing := &failingMetric{SystemMetric: newSystemMetric("FreeMemory"), fail: true}
+			healthy := &failingMetric{SystemMetric: newSystemMetric("TotalMemory")}
+
+			mon.gopsutilstats = []Metric{failing, healthy}
+
+			// A recovery resets the consecutive failures.
+			mon.collectGopsutils()
+			failing.fail = false
+			mon.collectGopsutils()
+			failing.fail = true
+
+			for range 2 {
+				mon.collectGopsutils()
+			}
+
+			assert.Zero(t, logs.FilterMessage("System metric collection keeps failing").Len())
+			assert.Len(t, mon.gopsutilstats, 2)
+
+			for range 3 {
+				mon.collectGopsutils()
+			}
+
+			// The warning is logged once.
+			assert.Equal(t, 1, logs.FilterMessage("System metric collection keeps failing").Len())
+			assert.Len(t, mon.gopsutilstats, tc.wantLen)
+			assert.Len(t, mon.reportedMetrics(), len(mon.metrics)+tc.wantLen+len(mon.selfstats.metrics()))
+		})
+	}
+}
+
+func TestRunReporterFlushOnShutdown(t *testing.T) {
+	testCases := []struct {
+		name        string
+		hang        bool
+		wantFlushed bool
+	}{
+		{"Flushed", false, true},
+		{"DroppedOnTimeout", true, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			release := make(chan struct{})
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.hang {
+					select {
+					case <-r.Context().Done():
+					case <-release:
+					}
+				}
+
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer ts.Close()
+			defer close(release)
+
+			key := newTestPrivateKey(t)
+
+			core, logs := observer.New(zap.InfoLevel)
+
+			mon := NewMonitor(
+				WithLogger(zap.New(core)),
+				WithServerAddr(ts.URL),
+				WithCryptoPubKey(&key.PublicKey),
+				WithReportInterval(1*time.Hour),
+				WithFlushTimeout(200*time.Millisecond),
+			)
+
+			// The reported metrics fit into a single partial batch.
+			total := len(mon.reportedMetrics())
+			require.Less(t, total, 100)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			start := time.Now()
+
+			mon.RunReporter(ctx)
+
+			// The flush does not outlive the flush timeout much.
+			assert.Less(t, time.Since(start), 5*time.Second)
+
+			entries := logs.FilterMessage("Metrics flushed to remote server").All()
+			require.Len(t, entries, 1)
+
+			fields := entries[0].ContextMap()
+
+			if tc.wantFlushed {
+				assert.EqualValues(t, total, fields["flushed"])
+				assert.EqualValues(t, 0, fields["dropped"])
+			} else {
+				assert.EqualValues(t, 0, fields["flushed"])
+				assert.EqualValues(t, total, fields["dropped"])
+			}
+		})
+	}
+}
@@ -2,28 +2,93 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/caarlos0/env"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+	"github.com/andymarkow/go-metrics-collector/internal/server/httpserver/router/middlewares"
 )
 
 // config represents the server configuration.
 //
 //nolint:tagalign,tagliatelle
 type config struct {
-	ConfigFile    string `env:"CONFIG" json:"config"`
-	ServerAddr    string `env:"ADDRESS" json:"address"`
-	LogLevel      string `env:"LOG_LEVEL" json:"log_level"`
-	DatabaseDSN   string `env:"DATABASE_DSN" json:"database_dsn"`
-	SignKey       string `env:"KEY" json:"sign_key"`
-	CryptoKey     string `env:"CRYPTO_KEY" json:"crypto_key"`
-	StoreFile     string `env:"FILE_STORAGE_PATH" json:"store_file"`
-	StoreInterval int    `env:"STORE_INTERVAL" json:"store_interval"`
-	RestoreOnBoot bool   `env:"RESTORE" json:"restore"`
+	ConfigFile           string  `env:"CONFIG" json:"config"`
+	ServerAddr           string  `env:"ADDRESS" json:"address"`
+	LogLevel             string  `env:"LOG_LEVEL" json:"log_level"`
+	DatabaseDSN          string  `env:"DATABASE_DSN" json:"database_dsn"`
+	DatabaseReplicaDSN   string  `env:"DATABASE_REPLICA_DSN" json:"database_replica_dsn"`
+	RedisDSN             string  `env:"REDIS_DSN" json:"redis_dsn"`
+	SQLitePath           string  `env:"SQLITE_PATH" json:"sqlite_path"`
+	SignKey              string  `env:"KEY" json:"sign_key"`
+	SignPublicKey        string  `env:"SIGN_PUBLIC_KEY" json:"sign_public_key"`
+	CryptoKey            string  `env:"CRYPTO_KEY" json:"crypto_key"`
+	StoreFile            string  `env:"FILE_STORAGE_PATH" json:"store_file"`
+	StoreInterval        int     `env:"STORE_INTERVAL" json:"store_interval"`
+	FieldMap             string  `env:"METRICS_FIELD_MAP" json:"metrics_field_map"`
+	GaugeAggregate       string  `env:"GAUGE_AGGREGATE" json:"gauge_aggregate"`
+	GaugeAggregateWindow int     `env:"GAUGE_AGGREGATE_WINDOW" json:"gauge_aggregate_window"`
+	RestoreOnBoot        bool    `env:"RESTORE" json:"restore"`
+	RestoreMaxAge        int     `env:"RESTORE_MAX_AGE" json:"restore_max_age"`
+	FileStoreWithDB      bool    `env:"FILE_STORE_WITH_DB" json:"file_store_with_db"`
+	StrictCounters       bool    `env:"STRICT_COUNTERS" json:"strict_counters"`
+	RequiredFields       string  `env:"REQUIRED_FIELDS" json:"required_fields"`
+	Retention            int     `env:"METRICS_RETENTION" json:"metrics_retention"`
+	CompactInterval      int     `env:"COMPACT_INTERVAL" json:"compact_interval"`
+	CompactCounters      bool    `env:"COMPACT_COUNTERS" json:"compact_counters"`
+	TLSCertFile          string  `env:"TLS_CERT_FILE" json:"tls_cert_file"`
+	TLSKeyFile           string  `env:"TLS_KEY_FILE" json:"tls_key_file"`
+	TrustedSubnet        string  `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
+	TrustedProxies       string  `env:"TRUSTED_PROXIES" json:"trusted_proxies"`
+	HistogramBuckets     string  `env:"HISTOGRAM_BUCKETS" json:"histogram_buckets"`
+	MaxBatchLength       int     `env:"MAX_BATCH_LENGTH" json:"max_batch_length"`
+	MaxNameLength        int     `env:"MAX_METRIC_NAME_LENGTH" json:"max_metric_name_length"`
+	MaxDecompressedBytes int64   `env:"MAX_DECOMPRESSED_BYTES" json:"max_decompressed_bytes"`
+	JSONUpdatesResponse  bool    `env:"JSON_UPDATES_RESPONSE" json:"json_updates_response"`
+	PreferMinimal        bool    `env:"PREFER_MINIMAL" json:"prefer_minimal"`
+	InitOnRead           bool    `env:"INIT_ON_READ" json:"init_on_read"`
+	ShutdownTimeout      int     `env:"SHUTDOWN_TIMEOUT" json:"shutdown_timeout"`
+	AllowedOrigins       string  `env:"ALLOWED_ORIGINS" json:"allowed_origins"`
+	RateLimit            float64 `env:"SERVER_RATE_LIMIT" json:"server_rate_limit"`
+	RateBurst            int     `env:"SERVER_RATE_BURST" json:"server_rate_burst"`
+	DBMaxOpenConns       int     `env:"DB_MAX_OPEN_CONNS" json:"db_max_open_conns"`
+	DBMaxIdleConns       int     `env:"DB_MAX_IDLE_CONNS" json:"db_max_idle_conns"`
+	DBConnMaxIdleTime    int     `env:"DB_CONN_MAX_IDLE_TIME" json:"db_conn_max_idle_time"`
+	DBConnMaxLifetime    int     `env:"DB_CONN_MAX_LIFETIME" json:"db_conn_max_lifetime"`
+	HealthCheckTimeout   int     `env:"HEALTH_CHECK_TIMEOUT" json:"health_check_timeout"`
+	StatsdAddr           string  `env:"STATSD_ADDR" json:"statsd_addr"`
+
+	// storeIntervalSet is set when the store interval is explicitly set,
+	// to tell the zero interval from the missing one.
+	storeIntervalSet bool
+
+	// configFileMissing is set when the default config file does not exist.
+	configFileMissing bool
+
+	// dumpConfig is set to print the effective config and exit.
+	dumpConfig bool
 }
 
+// ErrConfigDumped is returned when the effective config has been printed
+// by the -dump-config flag and the server should exit.
+var ErrConfigDumped = errors.New("config dumped")
+
+// defaultConfigFile is the config file path used when none is given.
+const defaultConfigFile = "./config/server.json"
+
 // newConfig creates a new config for the server.
 //
 // It uses both environment variables and command line flags to populate the
@@ -33,44 +98,228 @@ type config struct {
 // If there is an error while parsing the environment variables, it will return
 // an error.
 func newConfig() (config, error) {
+	cfg, err := parseConfig(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.dumpConfig {
+		if err := writeConfig(os.Stdout, cfg); err != nil {
+			return cfg, fmt.Errorf("writeConfig: %w", err)
+		}
+
+		return cfg, ErrConfigDumped
+	}
+
+	return cfg, nil
+}
+
+// writeConfig writes the config with the secret values redacted as JSON.
+func writeConfig(w io.Writer, cfg config) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+
+	if err := encoder.Encode(cfg.redacted()); err != nil {
+		return fmt.Errorf("encoder.Encode: %w", err)
+	}
+
+	return nil
+}
+
+// parseConfig populates the config from the given flag set and arguments,
+// environment variables and the config file.
+//
+// A missing config file is not an error when its path is the default one,
+// but it is when the path is explicitly set by the flag or environment variable.
+func parseConfig(fs *flag.FlagSet, args []string) (config, error) {
 	cfg := config{}
 
-	flag.StringVar(&cfg.ConfigFile, "c", "./config/server.json", "path to config file [env:CONFIG]")
-	flag.StringVar(&cfg.ServerAddr, "a", "", "server listening address [env:ADDRESS]")
-	flag.StringVar(&cfg.LogLevel, "l", "", "log output level [env:LOG_LEVEL]")
-	flag.StringVar(&cfg.DatabaseDSN, "d", "", "database connection string [env:DATABASE_DSN]")
-	flag.StringVar(&cfg.SignKey, "k", "", "signing key [env:KEY]")
-	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "path to RSA private key file to decrypt messages from Agent [env:CRYPTO_KEY]")
-	flag.StringVar(&cfg.StoreFile, "f", "", "filepath to store metrics data to [env:FILE_STORAGE_PATH]")
-	flag.IntVar(&cfg.StoreInterval, "i", 0, "interval in seconds to store metrics data into file [env:STORE_INTERVAL]")
-	flag.BoolVar(&cfg.RestoreOnBoot, "r", false, "whether or not to restore metrics data from file [env:RESTORE]")
-	flag.Parse()
+	fs.StringVar(&cfg.ConfigFile, "c", defaultConfigFile, "comma-separated list of config files merged in order [env:CONFIG]")
+	fs.StringVar(&cfg.ServerAddr, "a", "", "server listening address or unix:/path/to.sock Unix domain socket [env:ADDRESS]")
+	fs.StringVar(&cfg.LogLevel, "l", "", "log output level [env:LOG_LEVEL]")
+	fs.StringVar(&cfg.LogLevel, "log-level", "", "log output level; alias of -l [env:LOG_LEVEL]")
+	fs.StringVar(&cfg.DatabaseDSN, "d", "", "database connection string [env:DATABASE_DSN]")
+	fs.StringVar(&cfg.DatabaseReplicaDSN, "database-replica-dsn", "", "database read replica connection string the data saver reads metrics from [env:DATABASE_REPLICA_DSN]")
+	fs.StringVar(&cfg.RedisDSN, "redis-dsn", "", "Redis connection string, e.g. redis://localhost:6379/0; database and SQLite storages take precedence [env:REDIS_DSN]")
+	fs.StringVar(&cfg.SQLitePath, "sqlite-path", "", "SQLite database file path, e.g. /var/lib/metrics.db; database storage takes precedence [env:SQLITE_PATH]")
+	fs.StringVar(&cfg.SignKey, "k", "", "signing key [env:KEY]")
+	fs.StringVar(&cfg.SignPublicKey, "sign-public-key", "", "path to ed25519 public key file to verify messages from Agent with instead of the signing key [env:SIGN_PUBLIC_KEY]")
+	fs.StringVar(&cfg.CryptoKey, "crypto-key", "", "path to RSA private key file to decrypt messages from Agent [env:CRYPTO_KEY]")
+	fs.StringVar(&cfg.StoreFile, "f", "", "filepath to store metrics data to [env:FILE_STORAGE_PATH]")
+	fs.IntVar(&cfg.StoreInterval, "i", 0, "interval in seconds to store metrics data into file; 0 saves the data after each batch update [env:STORE_INTERVAL]")
+	fs.StringVar(&cfg.FieldMap, "field-map", "", "comma-separated list of alternate=field JSON field names accepted by /updates [env:METRICS_FIELD_MAP]")
+	fs.StringVar(&cfg.GaugeAggregate, "gauge-aggregate", "", "aggregate gauge writes within a window by avg, min, max or last; disabled if empty [env:GAUGE_AGGREGATE]")
+	fs.IntVar(&cfg.GaugeAggregateWindow, "gauge-aggregate-window", 0, "gauge aggregation window in seconds [env:GAUGE_AGGREGATE_WINDOW]")
+	fs.BoolVar(&cfg.RestoreOnBoot, "r", false, "whether or not to restore metrics data from file [env:RESTORE]")
+	fs.BoolVar(&cfg.FileStoreWithDB, "file-store-with-db", false, "store and restore metrics data file with the database storage as well [env:FILE_STORE_WITH_DB]")
+	fs.IntVar(&cfg.RestoreMaxAge, "restore-max-age", 0, "skip restoring metrics data from file modified more than the age in seconds ago; no limit if 0 [env:RESTORE_MAX_AGE]")
+	fs.BoolVar(&cfg.StrictCounters, "strict-counters", false, "reject non-integer counter values instead of truncating them [env:STRICT_COUNTERS]")
+	fs.StringVar(&cfg.RequiredFields, "required-fields", "", "comma-separated list of config environment variable names that must be set, e.g. DATABASE_DSN,KEY [env:REQUIRED_FIELDS]")
+	fs.BoolVar(&cfg.dumpConfig, "dump-config", false, "print the effective config as JSON with secrets redacted and exit")
+	fs.IntVar(&cfg.Retention, "retention", 0, "delete database gauges not updated within retention in seconds; disabled if 0 [env:METRICS_RETENTION]")
+	fs.IntVar(&cfg.DBMaxOpenConns, "db-max-open-conns", 0, "maximum number of open database connections [env:DB_MAX_OPEN_CONNS]")
+	fs.IntVar(&cfg.DBMaxIdleConns, "db-max-idle-conns", 0, "maximum number of idle database connections [env:DB_MAX_IDLE_CONNS]")
+	fs.IntVar(&cfg.DBConnMaxIdleTime, "db-conn-max-idle-time", 0, "time in seconds a database connection may be idle [env:DB_CONN_MAX_IDLE_TIME]")
+	fs.IntVar(&cfg.DBConnMaxLifetime, "db-conn-max-lifetime", 0, "time in seconds a database connection may be reused [env:DB_CONN_MAX_LIFETIME]")
+	fs.StringVar(&cfg.StatsdAddr, "statsd-addr", "", "UDP address to listen for StatsD metrics on, e.g. :8125; disabled if empty [env:STATSD_ADDR]")
+	fs.IntVar(&cfg.HealthCheckTimeout, "health-check-timeout", 0, "time in seconds to wait for the storage health check of the /ready endpoint [env:HEALTH_CHECK_TIMEOUT]")
+	fs.IntVar(&cfg.CompactInterval, "compact-interval", 0, "interval in seconds to delete stale database metrics [env:COMPACT_INTERVAL]")
+	fs.BoolVar(&cfg.CompactCounters, "compact-counters", false, "whether or not to delete stale database counters as well [env:COMPACT_COUNTERS]")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", "", "path to TLS certificate file; HTTPS is enabled if set along with the key file [env:TLS_CERT_FILE]")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", "", "path to TLS private key file [env:TLS_KEY_FILE]")
+	fs.IntVar(&cfg.MaxBatchLength, "max-batch-length", 0, "maximum number of metrics accepted in a single /updates request [env:MAX_BATCH_LENGTH]")
+	fs.IntVar(&cfg.MaxNameLength, "max-name-length", 0, "maximum metric name length in bytes [env:MAX_METRIC_NAME_LENGTH]")
+	fs.Int64Var(&cfg.MaxDecompressedBytes, "max-decompressed-bytes", 0, "maximum size of the decompressed gzip request body in bytes [env:MAX_DECOMPRESSED_BYTES]")
+	fs.BoolVar(&cfg.JSONUpdatesResponse, "json-updates-response", false, "respond to /updates with {\"updated\": N} JSON instead of the plain OK [env:JSON_UPDATES_RESPONSE]")
+	fs.BoolVar(&cfg.PreferMinimal, "prefer-minimal", false, "respond with 204 No Content to the update requests with the Prefer: return=minimal header [env:PREFER_MINIMAL]")
+	fs.BoolVar(&cfg.InitOnRead, "init-on-read", false, "respond with zero value instead of 404 to the get requests of the metrics never written [env:INIT_ON_READ]")
+	fs.IntVar(&cfg.ShutdownTimeout, "shutdown-timeout", 0, "time in seconds to wait for the in-flight requests to complete on shutdown [env:SHUTDOWN_TIMEOUT]")
+	fs.StringVar(&cfg.AllowedOrigins, "allowed-origins", "", "comma-separated list of origins allowed to make cross-origin requests, * for any; CORS is disabled if empty [env:ALLOWED_ORIGINS]")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", 0, "maximum requests per second from a single client IP address; unlimited if 0 [env:SERVER_RATE_LIMIT]")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", 0, "maximum burst of requests from a single client IP address [env:SERVER_RATE_BURST]")
+	fs.StringVar(&cfg.HistogramBuckets, "histogram-buckets", "", "comma-separated list of in-memory histograms buckets upper bounds [env:HISTOGRAM_BUCKETS]")
+	fs.StringVar(&cfg.TrustedSubnet, "t", "", "CIDR of the subnet the admin endpoints are allowed from, e.g. 10.0.0.0/8; denied if empty [env:TRUSTED_SUBNET]")
+	fs.StringVar(&cfg.TrustedProxies, "trusted-proxies", "", "comma-separated list of CIDRs of the reverse proxies the X-Real-IP header is honored from [env:TRUSTED_PROXIES]")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, fmt.Errorf("fs.Parse: %w", err)
+	}
 
 	// Highest precedence for environment variables.
 	if err := env.Parse(&cfg); err != nil {
 		return cfg, fmt.Errorf("env.Parse: %w", err)
 	}
 
+	_, explicitConfigFile := os.LookupEnv("CONFIG")
+	_, cfg.storeIntervalSet = os.LookupEnv("STORE_INTERVAL")
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "c":
+			explicitConfigFile = true
+		case "i":
+			cfg.storeIntervalSet = true
+		}
+	})
+
+	// Explicitly given config files must exist.
+	if explicitConfigFile {
+		for _, file := range strings.Split(cfg.ConfigFile, ",") {
+			if _, err := os.Stat(strings.TrimSpace(file)); err != nil {
+				return cfg, fmt.Errorf("os.Stat: %w", err)
+			}
+		}
+	}
+
 	// Lowest precedence for configuration file.
 	if err := readConfigFile(cfg.ConfigFile, &cfg); err != nil {
 		return cfg, fmt.Errorf("readConfigFile: %w", err)
 	}
 
+	if err := validateRequiredFields(cfg); err != nil {
+		return cfg, fmt.Errorf("validateRequiredFields: %w", err)
+	}
+
 	return cfg, nil
 }
 
-func readConfigFile(file string, cfg *config) error {
-	f, err := os.ReadFile(file)
-	if err != nil {
-		return fmt.Errorf("os.ReadFile: %w", err)
+// validateRequiredFields checks that the config fields listed in RequiredFields
+// by their environment variable names are set.
+func validateRequiredFields(cfg config) error {
+	if cfg.RequiredFields == "" {
+		return nil
 	}
 
+	// Map the config fields values by their environment variable names.
+	fields := make(map[string]reflect.Value)
+
+	v := reflect.ValueOf(cfg)
+
+	for i := range v.NumField() {
+		if name := v.Type().Field(i).Tag.Get("env"); name != "" {
+			fields[name] = v.Field(i)
+		}
+	}
+
+	for _, name := range strings.Split(cfg.RequiredFields, ",") {
+		name = strings.TrimSpace(name)
+
+		field, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("unknown required field: %q", name)
+		}
+
+		if field.IsZero() {
+			return fmt.Errorf("required field %s is not set", name)
+		}
+	}
+
+	return nil
+}
+
+// readConfigFile merges the config files values into the config.
+//
+// The files is a comma-separated list of config files merged in order,
+// so that values of the later files override the earlier ones.
+// Missing config files are skipped.
+func readConfigFile(files string, cfg *config) error {
 	fileCfg := new(config)
 
-	if err := json.Unmarshal(f, fileCfg); err != nil {
-		return fmt.Errorf("json.Unmarshal: %w", err)
+	// Assume all the files are missing until one is read.
+	cfg.configFileMissing = true
+
+	for _, file := range strings.Split(files, ",") {
+		f, err := os.ReadFile(strings.TrimSpace(file))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("os.ReadFile: %w", err)
+		}
+
+		layerCfg := new(config)
+
+		if err := json.Unmarshal(f, layerCfg); err != nil {
+			return fmt.Errorf("json.Unmarshal(%s): %w", file, err)
+		}
+
+		mergeConfig(fileCfg, layerCfg)
+
+		// The zero store interval is not merged as a missing value, so it
+		// is looked up in the file keys.
+		var keys map[string]json.RawMessage
+
+		if err := json.Unmarshal(f, &keys); err != nil {
+			return fmt.Errorf("json.Unmarshal(%s): %w", file, err)
+		}
+
+		if _, ok := keys["store_interval"]; ok {
+			fileCfg.StoreInterval = layerCfg.StoreInterval
+			fileCfg.storeIntervalSet = true
+		}
+
+		cfg.configFileMissing = false
 	}
 
+	applyConfigFile(fileCfg, cfg)
+
+	return nil
+}
+
+// mergeConfig sets the dst config fields to the non-zero src config fields.
+func mergeConfig(dst, src *config) {
+	dv := reflect.ValueOf(dst).Elem()
+	sv := reflect.ValueOf(src).Elem()
+
+	for i := range sv.NumField() {
+		if field := sv.Field(i); dv.Field(i).CanSet() && !field.IsZero() {
+			dv.Field(i).Set(field)
+		}
+	}
+}
+
+// applyConfigFile sets the config values that are not set yet from fileCfg,
+// falling back to the default values.
+func applyConfigFile(fileCfg, cfg *config) {
 	if cfg.CryptoKey == "" {
 		if fileCfg.CryptoKey == "" {
 			cfg.CryptoKey = "./tls/private.key"
@@ -83,6 +332,18 @@ func readConfigFile(file string, cfg *config) error {
 		cfg.DatabaseDSN = fileCfg.DatabaseDSN
 	}
 
+	if cfg.DatabaseReplicaDSN == "" {
+		cfg.DatabaseReplicaDSN = fileCfg.DatabaseReplicaDSN
+	}
+
+	if cfg.RedisDSN == "" {
+		cfg.RedisDSN = fileCfg.RedisDSN
+	}
+
+	if cfg.SQLitePath == "" {
+		cfg.SQLitePath = fileCfg.SQLitePath
+	}
+
 	if cfg.LogLevel == "" {
 		if fileCfg.LogLevel == "" {
 			cfg.LogLevel = "info"
@@ -103,6 +364,26 @@ func readConfigFile(file string, cfg *config) error {
 		cfg.SignKey = fileCfg.SignKey
 	}
 
+	if cfg.SignPublicKey == "" {
+		cfg.SignPublicKey = fileCfg.SignPublicKey
+	}
+
+	if cfg.FieldMap == "" {
+		cfg.FieldMap = fileCfg.FieldMap
+	}
+
+	if cfg.GaugeAggregate == "" {
+		cfg.GaugeAggregate = fileCfg.GaugeAggregate
+	}
+
+	if cfg.GaugeAggregateWindow == 0 {
+		if fileCfg.GaugeAggregateWindow == 0 {
+			cfg.GaugeAggregateWindow = 10
+		} else {
+			cfg.GaugeAggregateWindow = fileCfg.GaugeAggregateWindow
+		}
+	}
+
 	if cfg.StoreFile == "" {
 		if fileCfg.StoreFile == "" {
 			cfg.StoreFile = "/tmp/metrics-db.json"
@@ -111,11 +392,12 @@ func readConfigFile(file string, cfg *config) error {
 		}
 	}
 
-	if cfg.StoreInterval == 0 {
-		if fileCfg.StoreInterval == 0 {
-			cfg.StoreInterval = 300
-		} else {
+	// The explicit zero store interval turns on the synchronous save.
+	if cfg.StoreInterval == 0 && !cfg.storeIntervalSet {
+		if fileCfg.storeIntervalSet {
 			cfg.StoreInterval = fileCfg.StoreInterval
+		} else {
+			cfg.StoreInterval = 300
 		}
 	}
 
@@ -127,5 +409,312 @@ func readConfigFile(file string, cfg *config) error {
 		}
 	}
 
-	return nil
+	if cfg.RestoreMaxAge == 0 {
+		cfg.RestoreMaxAge = fileCfg.RestoreMaxAge
+	}
+
+	if !cfg.FileStoreWithDB {
+		cfg.FileStoreWithDB = fileCfg.FileStoreWithDB
+	}
+
+	if !cfg.StrictCounters {
+		cfg.StrictCounters = fileCfg.StrictCounters
+	}
+
+	if cfg.RequiredFields == "" {
+		cfg.RequiredFields = fileCfg.RequiredFields
+	}
+
+	if cfg.Retention == 0 {
+		cfg.Retention = fileCfg.Retention
+	}
+
+	if cfg.DBMaxOpenConns == 0 {
+		if fileCfg.DBMaxOpenConns == 0 {
+			cfg.DBMaxOpenConns = 10
+		} else {
+			cfg.DBMaxOpenConns = fileCfg.DBMaxOpenConns
+		}
+	}
+
+	if cfg.DBMaxIdleConns == 0 {
+		if fileCfg.DBMaxIdleConns == 0 {
+			cfg.DBMaxIdleConns = 5
+		} else {
+			cfg.DBMaxIdleConns = fileCfg.DBMaxIdleConns
+		}
+	}
+
+	if cfg.DBConnMaxIdleTime == 0 {
+		if fileCfg.DBConnMaxIdleTime == 0 {
+			cfg.DBConnMaxIdleTime = 180
+		} else {
+			cfg.DBConnMaxIdleTime = fileCfg.DBConnMaxIdleTime
+		}
+	}
+
+	if cfg.DBConnMaxLifetime == 0 {
+		if fileCfg.DBConnMaxLifetime == 0 {
+			cfg.DBConnMaxLifetime = 3600
+		} else {
+			cfg.DBConnMaxLifetime = fileCfg.DBConnMaxLifetime
+		}
+	}
+
+	if cfg.HealthCheckTimeout == 0 {
+		if fileCfg.HealthCheckTimeout == 0 {
+			cfg.HealthCheckTimeout = 1
+		} else {
+			cfg.HealthCheckTimeout = fileCfg.HealthCheckTimeout
+		}
+	}
+
+	if cfg.StatsdAddr == "" {
+		cfg.StatsdAddr = fileCfg.StatsdAddr
+	}
+
+	if cfg.CompactInterval == 0 {
+		if fileCfg.CompactInterval == 0 {
+			cfg.CompactInterval = 3600
+		} else {
+			cfg.CompactInterval = fileCfg.CompactInterval
+		}
+	}
+
+	if !cfg.CompactCounters {
+		cfg.CompactCounters = fileCfg.CompactCounters
+	}
+
+	if cfg.TLSCertFile == "" {
+		cfg.TLSCertFile = fileCfg.TLSCertFile
+	}
+
+	if cfg.TLSKeyFile == "" {
+		cfg.TLSKeyFile = fileCfg.TLSKeyFile
+	}
+
+	if cfg.TrustedSubnet == "" {
+		cfg.TrustedSubnet = fileCfg.TrustedSubnet
+	}
+
+	if cfg.TrustedProxies == "" {
+		cfg.TrustedProxies = fileCfg.TrustedProxies
+	}
+
+	if cfg.MaxBatchLength == 0 {
+		if fileCfg.MaxBatchLength == 0 {
+			cfg.MaxBatchLength = 10000
+		} else {
+			cfg.MaxBatchLength = fileCfg.MaxBatchLength
+		}
+	}
+
+	if cfg.MaxNameLength == 0 {
+		if fileCfg.MaxNameLength == 0 {
+			cfg.MaxNameLength = models.DefaultMaxNameLength
+		} else {
+			cfg.MaxNameLength = fileCfg.MaxNameLength
+		}
+	}
+
+	if cfg.MaxDecompressedBytes == 0 {
+		if fileCfg.MaxDecompressedBytes == 0 {
+			cfg.MaxDecompressedBytes = middlewares.DefaultMaxDecompressedBytes
+		} else {
+			cfg.MaxDecompressedBytes = fileCfg.MaxDecompressedBytes
+		}
+	}
+
+	if !cfg.JSONUpdatesResponse {
+		cfg.JSONUpdatesResponse = fileCfg.JSONUpdatesResponse
+	}
+
+	if !cfg.PreferMinimal {
+		cfg.PreferMinimal = fileCfg.PreferMinimal
+	}
+
+	if !cfg.InitOnRead {
+		cfg.InitOnRead = fileCfg.InitOnRead
+	}
+
+	if cfg.ShutdownTimeout == 0 {
+		if fileCfg.ShutdownTimeout == 0 {
+			cfg.ShutdownTimeout = 30
+		} else {
+			cfg.ShutdownTimeout = fileCfg.ShutdownTimeout
+		}
+	}
+
+	if cfg.AllowedOrigins == "" {
+		cfg.AllowedOrigins = fileCfg.AllowedOrigins
+	}
+
+	if cfg.RateLimit == 0 {
+		cfg.RateLimit = fileCfg.RateLimit
+	}
+
+	if cfg.RateBurst == 0 {
+		if fileCfg.RateBurst == 0 {
+			cfg.RateBurst = 10
+		} else {
+			cfg.RateBurst = fileCfg.RateBurst
+		}
+	}
+
+	if cfg.HistogramBuckets == "" {
+		if fileCfg.HistogramBuckets == "" {
+			cfg.HistogramBuckets = "0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10"
+		} else {
+			cfg.HistogramBuckets = fileCfg.HistogramBuckets
+		}
+	}
+}
+
+// parseFieldMap parses a comma-separated list of alternate=field JSON field
+// names mappings, e.g. "metric_id=id,metric_type=type".
+func parseFieldMap(s string) (map[string]string, error) {
+	fieldMap := make(map[string]string)
+
+	if s == "" {
+		return fieldMap, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		alias, field, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || alias == "" {
+			return nil, fmt.Errorf("invalid field mapping: %q", pair)
+		}
+
+		switch field {
+		case "id", "type", "delta", "value":
+		default:
+			return nil, fmt.Errorf("invalid field mapping %q: unknown metrics field %q", pair, field)
+		}
+
+		fieldMap[alias] = field
+	}
+
+	return fieldMap, nil
+}
+
+// parseAllowedOrigins parses a comma-separated list of the CORS allowed
+// origins, e.g. "https://dashboard.example.com,http://localhost:3000".
+func parseAllowedOrigins(s string) []string {
+	origins := make([]string, 0)
+
+	for _, origin := range strings.Split(s, ",") {
+		// The trailing slash is not a part of the Origin header value.
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+
+	return origins
+}
+
+// parseTrustedProxies parses a comma-separated list of the trusted reverse
+// proxies CIDRs, e.g. "10.0.0.0/8,192.168.1.1/32".
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	proxies := make([]*net.IPNet, 0)
+
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %q", cidr)
+		}
+
+		proxies = append(proxies, subnet)
+	}
+
+	return proxies, nil
+}
+
+// parseHistogramBuckets parses a comma-separated list of the histogram
+// buckets upper bounds, e.g. "0.1,0.5,1".
+func parseHistogramBuckets(s string) ([]float64, error) {
+	buckets := make([]float64, 0)
+
+	for _, bound := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(bound), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid histogram bucket: %q", bound)
+		}
+
+		if slices.Contains(buckets, v) {
+			return nil, fmt.Errorf("duplicate histogram bucket: %q", bound)
+		}
+
+		buckets = append(buckets, v)
+	}
+
+	return buckets, nil
+}
+
+// Placeholders of the secret config values.
+const (
+	// redactedValue replaces the secret values of the logged and dumped config.
+	redactedValue = "REDACTED"
+	// maskedValue replaces the secret values of the config served by the
+	// config endpoint.
+	maskedValue = "***"
+)
+
+// passwordPattern matches the password of a keyword/value connection string.
+var passwordPattern = regexp.MustCompile(`password=\S+`)
+
+// redact returns a copy of the config with the secret values replaced with
+// the placeholder.
+func (c config) redact(placeholder string) config {
+	if c.SignKey != "" {
+		c.SignKey = placeholder
+	}
+
+	c.DatabaseDSN = redactDSN(c.DatabaseDSN, placeholder)
+	c.DatabaseReplicaDSN = redactDSN(c.DatabaseReplicaDSN, placeholder)
+	c.RedisDSN = redactDSN(c.RedisDSN, placeholder)
+
+	return c
+}
+
+// redacted returns a copy of the config with the secret values redacted.
+func (c config) redacted() config {
+	return c.redact(redactedValue)
+}
+
+// masked returns a copy of the config with the secret values and the crypto
+// key masked.
+func (c config) masked() config {
+	c = c.redact(maskedValue)
+
+	if c.CryptoKey != "" {
+		c.CryptoKey = maskedValue
+	}
+
+	return c
+}
+
+// redactDSN replaces the password of the URL or keyword/value connection
+// string with the placeholder.
+func redactDSN(dsn, placeholder string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); !ok {
+			return u.String()
+		}
+
+		u.User = url.UserPassword(u.User.Username(), placeholder)
+
+		// The placeholder is kept unescaped to be readable, e.g. "***".
+		escaped := url.UserPassword("", placeholder).String() + "@"
+
+		return strings.Replace(u.String(), escaped, ":"+placeholder+"@", 1)
+	}
+
+	return passwordPattern.ReplaceAllString(dsn, "password="+placeholder)
 }
@@ -3,16 +3,23 @@ package httpserver
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 )
 
 type HTTPServer struct {
-	log    *zap.Logger
-	server *http.Server
+	log      *zap.Logger
+	server   *http.Server
+	certFile string
+	keyFile  string
 }
 
 // NewHTTPServer creates a new HTTP server.
@@ -65,6 +72,15 @@ func WithWriteTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithTLS is a HTTP server option that enables TLS with the given
+// certificate and private key files.
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *HTTPServer) {
+		s.certFile = certFile
+		s.keyFile = keyFile
+	}
+}
+
 // WithLogger is a HTTP server option that sets logger.
 func WithLogger(log *zap.Logger) Option {
 	return func(s *HTTPServer) {
@@ -72,17 +88,81 @@ func WithLogger(log *zap.Logger) Option {
 	}
 }
 
+// unixAddrPrefix is the server address prefix of a Unix domain socket,
+// e.g. "unix:/run/metrics.sock".
+const unixAddrPrefix = "unix:"
+
+// unixSocketPath returns the socket file path of the Unix domain socket
+// server address.
+func unixSocketPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+
+	return path, ok && path != ""
+}
+
 // Start starts the HTTP server.
+//
+// The server is served over TLS if both certificate and private key files
+// are set, otherwise over plain HTTP. The server listens on a Unix domain
+// socket if the address has the "unix:" prefix, otherwise on TCP.
 func (s *HTTPServer) Start() error {
+	ln, err := s.listen()
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	if s.certFile != "" && s.keyFile != "" {
+		s.log.Info("Starting HTTPS server", zap.String("addr", s.server.Addr))
+
+		err := s.server.ServeTLS(ln, s.certFile, s.keyFile)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("server.ServeTLS: %w", err)
+		}
+
+		return nil
+	}
+
 	s.log.Info("Starting HTTP server", zap.String("addr", s.server.Addr))
 
-	if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		return fmt.Errorf("server.ListenAndServe: %w", err)
+	if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("server.Serve: %w", err)
 	}
 
 	return nil
 }
 
+// listen creates the listener on the server address.
+//
+// A stale socket file left by a server that was not shut down cleanly is
+// removed before listening on the Unix domain socket. Other files at the
+// socket path are left intact, so listening fails on them.
+func (s *HTTPServer) listen() (net.Listener, error) {
+	path, ok := unixSocketPath(s.server.Addr)
+	if !ok {
+		ln, err := net.Listen("tcp", s.server.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("net.Listen: %w", err)
+		}
+
+		return ln, nil
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("os.Remove: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("net.Listen: %w", err)
+	}
+
+	return ln, nil
+}
+
+// Shutdown gracefully shuts down the HTTP server and removes the socket file
+// of the Unix domain socket server.
 func (s *HTTPServer) Shutdown(ctx context.Context) error {
 	s.log.Info("Shutting down HTTP server")
 
@@ -90,5 +170,13 @@ func (s *HTTPServer) Shutdown(ctx context.Context) error {
 		return fmt.Errorf("server.Shutdown: %w", err)
 	}
 
+	// The listener unlinks the socket file on close, the removal only makes
+	// sure no file is left behind.
+	if path, ok := unixSocketPath(s.server.Addr); ok {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("os.Remove: %w", err)
+		}
+	}
+
 	return nil
 }
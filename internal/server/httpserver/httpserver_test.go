@@ -0,0 +1,250 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+	"github.com/andymarkow/go-metrics-collector/internal/storage"
+)
+
+// writeTestCert writes a self-signed certificate and its private key
+// into the temporary directory and returns their paths.
+func writeTestCert(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+
+	return certFile, keyFile
+}
+
+// freeAddr returns a local address with a free port.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	return addr
+}
+
+func TestStartTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	addr := freeAddr(t)
+
+	srv := NewHTTPServer(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		WithLogger(zap.NewNop()),
+		WithServerAddr(addr),
+		WithTLS(certFile, keyFile),
+	)
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Start()
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		},
+	}
+
+	require.Eventually(t, func() bool {
+		resp, err := client.Get("https://" + addr)
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+
+		return resp.StatusCode == http.StatusOK && resp.TLS != nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, srv.Shutdown(context.Background()))
+
+	// Shutdown is a clean stop for the TLS server as well.
+	assert.NoError(t, <-errCh)
+}
+
+func TestShutdownDrainsInFlightWrite(t *testing.T) {
+	addr := freeAddr(t)
+
+	strg := storage.NewMemStorage()
+
+	started := make(chan struct{})
+
+	srv := NewHTTPServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+
+			// The write is still in progress when the shutdown begins.
+			time.Sleep(200 * time.Millisecond)
+
+			delta := int64(1)
+			value := 1.5
+
+			err := strg.SetMetrics(r.Context(), []models.Metrics{
+				{ID: "testCounter", MType: "counter", Delta: &delta},
+				{ID: "testGauge", MType: "gauge", Value: &value},
+			})
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+		}),
+		WithLogger(zap.NewNop()),
+		WithServerAddr(addr),
+	)
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Start()
+	}()
+
+	respCh := make(chan int, 1)
+
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+
+		return conn.Close() == nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	go func() {
+		resp, err := http.Post("http://"+addr+"/updates", "application/json", strings.NewReader("[]")) //nolint:noctx
+		if err != nil {
+			respCh <- 0
+
+			return
+		}
+		defer resp.Body.Close()
+
+		respCh <- resp.StatusCode
+	}()
+
+	<-started
+
+	require.NoError(t, srv.Shutdown(context.Background()))
+	require.NoError(t, <-errCh)
+
+	// The in-flight write is completed as a whole before the server stops.
+	assert.Equal(t, http.StatusOK, <-respCh)
+
+	data, err := strg.GetAllMetrics(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, data, 2)
+}
+
+func TestStartUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+
+	// A stale socket file of a previous run does not prevent listening.
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, ln.Close())
+
+	srv := NewHTTPServer(
+		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		WithLogger(zap.NewNop()),
+		WithServerAddr("unix:"+path),
+	)
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- srv.Start()
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	require.Eventually(t, func() bool {
+		resp, err := client.Get("http://localhost/")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, srv.Shutdown(context.Background()))
+	require.NoError(t, <-errCh)
+
+	// The socket file is removed on shutdown.
+	assert.NoFileExists(t, path)
+}
+
+func TestStartUnixSocketNotSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+	srv := NewHTTPServer(http.NotFoundHandler(),
+		WithLogger(zap.NewNop()),
+		WithServerAddr("unix:"+path),
+	)
+
+	// A regular file at the socket path is not removed.
+	require.Error(t, srv.Start())
+	assert.FileExists(t, path)
+}
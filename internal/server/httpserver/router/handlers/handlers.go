@@ -2,14 +2,19 @@
 package handlers
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"slices"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
@@ -22,15 +27,40 @@ import (
 
 // Handlers is a collection of router handlers.
 type Handlers struct {
-	log     *zap.Logger
-	storage storage.Storage
+	log            *zap.Logger
+	storage        storage.Storage
+	fieldMap       map[string]string
+	maxBatchLength int
+	maxNameLength  int
+	strictCounters bool
+	jsonUpdates    bool
+	preferMinimal  bool
+	initOnRead     bool
+
+	// writeTracker reports the last write age self-metric, if set.
+	writeTracker storage.WriteTracker
+
+	// counterLocks serializes the line protocol writes of the same counters.
+	counterLocks keyedLocks
+
+	// config is the effective server config served by the config handler.
+	config any
+
+	buildInfo models.BuildInfo
 }
 
 // NewHandlers returns a new Handlers instance.
 func NewHandlers(strg storage.Storage, opts ...Option) *Handlers {
 	handlers := &Handlers{
-		storage: strg,
-		log:     zap.NewNop(),
+		storage:       strg,
+		log:           zap.NewNop(),
+		maxNameLength: models.DefaultMaxNameLength,
+	}
+
+	// The storage tracking its writes reports the last write age unless
+	// another tracker is set.
+	if tracker, ok := strg.(storage.WriteTracker); ok {
+		handlers.writeTracker = tracker
 	}
 
 	// Apply options
@@ -51,6 +81,99 @@ func WithLogger(logger *zap.Logger) Option {
 	}
 }
 
+// WithFieldMapping is an option for Handlers instance that sets alternate
+// JSON field names accepted by the batch update handler.
+//
+// The fieldMap maps an alternate field name to a models.Metrics JSON
+// field name, e.g. {"metric_id": "id", "metric_type": "type"}.
+func WithFieldMapping(fieldMap map[string]string) Option {
+	return func(h *Handlers) {
+		h.fieldMap = fieldMap
+	}
+}
+
+// WithMaxBatchLength is an option for Handlers instance that limits the number
+// of metrics accepted by the batch update handler. Zero means no limit.
+func WithMaxBatchLength(n int) Option {
+	return func(h *Handlers) {
+		h.maxBatchLength = n
+	}
+}
+
+// WithMaxNameLength is an option for Handlers instance that limits the length
+// of the metric names in bytes. The default length is used if n is not
+// positive.
+func WithMaxNameLength(n int) Option {
+	return func(h *Handlers) {
+		if n > 0 {
+			h.maxNameLength = n
+		}
+	}
+}
+
+// WithWriteTracker is an option for Handlers instance that sets the tracker
+// of the storage writes the last write age self-metric is reported from,
+// e.g. when the storage is wrapped. The storage one is kept if tracker is nil.
+func WithWriteTracker(tracker storage.WriteTracker) Option {
+	return func(h *Handlers) {
+		if tracker != nil {
+			h.writeTracker = tracker
+		}
+	}
+}
+
+// WithStrictCounters is an option for Handlers instance that makes the
+// update handler reject non-integer counter values instead of truncating them.
+func WithStrictCounters(strict bool) Option {
+	return func(h *Handlers) {
+		h.strictCounters = strict
+	}
+}
+
+// WithJSONUpdatesResponse is an option for Handlers instance that makes the
+// batch update handler respond with the number of stored metrics as JSON
+// instead of the plain "OK" to all the clients.
+func WithJSONUpdatesResponse(enabled bool) Option {
+	return func(h *Handlers) {
+		h.jsonUpdates = enabled
+	}
+}
+
+// WithPreferMinimal is an option for Handlers instance that makes the update
+// handlers respond with 204 No Content to the requests with the
+// "Prefer: return=minimal" header.
+func WithPreferMinimal(enabled bool) Option {
+	return func(h *Handlers) {
+		h.preferMinimal = enabled
+	}
+}
+
+// WithInitOnRead is an option for Handlers instance that makes the get metric
+// handlers respond with the zero value for the counters and gauges never
+// written instead of 404. The metrics are not created in the storage.
+func WithInitOnRead(enabled bool) Option {
+	return func(h *Handlers) {
+		h.initOnRead = enabled
+	}
+}
+
+// WithConfig is an option for Handlers instance that sets the effective
+// server config served by the config handler. The secret values are expected
+// to be redacted by the caller.
+func WithConfig(cfg any) Option {
+	return func(h *Handlers) {
+		h.config = cfg
+	}
+}
+
+// WithBuildInfo is an option for Handlers instance that sets the server build
+// information served by the version handler.
+func WithBuildInfo(info models.BuildInfo) Option {
+	return func(h *Handlers) {
+		h.buildInfo = info
+	}
+}
+
 // Ping handles ping request.
 func (h *Handlers) Ping(w http.ResponseWriter, r *http.Request) {
 	if err := h.storage.Ping(r.Context()); err != nil {
@@ -64,41 +187,266 @@ func (h *Handlers) Ping(w http.ResponseWriter, r *http.Request) {
 	h.checkRespError(w.Write([]byte("OK")))
 }
 
-// GetAllMetrics handles get all metrics request.
+// Ready handles the readiness probe request.
+//
+// Unlike Ping, it checks the storage once without retries, so the probe
+// fails fast with 503 while the storage is unavailable.
+func (h *Handlers) Ready(w http.ResponseWriter, r *http.Request) {
+	if err := h.storage.HealthCheck(r.Context()); err != nil {
+		h.handleError(w, err, http.StatusServiceUnavailable)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	h.checkRespError(w.Write([]byte("OK")))
+}
+
+// NotFound handles requests to unknown routes.
+//
+// It responds with a body that differs from the metric not found error,
+// so clients can tell a mistyped URL from a missing metric.
+func (h *Handlers) NotFound(w http.ResponseWriter, _ *http.Request) {
+	h.handleError(w, errormsg.ErrRouteNotFound, http.StatusNotFound)
+}
+
+// GetAllMetrics handles get all metrics request. The metrics are filtered
+// by the name glob pattern of the "match" query parameter, e.g. ?match=Heap*.
 func (h *Handlers) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+	data, status, err := h.matchedMetrics(r.Context(), r.URL.Query().Get("match"))
+	if err != nil {
+		h.handleError(w, err, status)
 
-	result := make([]string, 0)
+		return
+	}
+
+	result := make([]string, 0, len(data))
+
+	for _, metric := range storage.SortedMetrics(data) {
+		result = append(result, fmt.Sprintf("%s %s", metric.Name, metric.StringValue()))
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	h.checkRespError(w.Write([]byte(strings.Join(result, "\n"))))
+}
+
+// matchedMetrics returns the metrics which names match the glob pattern,
+// e.g. "Heap*", along with the response status code on error. All the
+// metrics are returned if the pattern is empty.
+//
+// The patterns of a literal prefix followed by "*" are matched by the storage,
+// so Postgres filters them in the query. The other patterns are matched in
+// memory with path.Match.
+func (h *Handlers) matchedMetrics(ctx context.Context, pattern string) (map[string]storage.Metric, int, error) {
+	if pattern == "" {
+		data, err := h.storage.GetAllMetrics(ctx)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("storage.GetAllMetrics: %w", err)
+		}
+
+		return data, http.StatusOK, nil
+	}
+
+	// The pattern is validated up front, so a malformed pattern is rejected
+	// even if no metrics are stored.
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid match pattern: %w", err)
+	}
+
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok && !strings.ContainsAny(prefix, `*?[\`) {
+		data, err := h.storage.GetMetricsByPrefix(ctx, prefix)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("storage.GetMetricsByPrefix: %w", err)
+		}
+
+		return data, http.StatusOK, nil
+	}
 
 	data, err := h.storage.GetAllMetrics(ctx)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("storage.GetAllMetrics: %w", err)
+	}
+
+	for name := range data {
+		// The pattern is valid, so no error is returned.
+		if ok, _ := path.Match(pattern, name); !ok {
+			delete(data, name)
+		}
+	}
+
+	return data, http.StatusOK, nil
+}
+
+// GetMetricsByPrefix handles get metrics which names start with the prefix
+// request. It responds with an empty JSON object if no metrics match.
+// The JSON object keys are sorted by the metric name.
+func (h *Handlers) GetMetricsByPrefix(w http.ResponseWriter, r *http.Request) {
+	prefix := chi.URLParam(r, "prefix")
+
+	data, err := h.storage.GetMetricsByPrefix(r.Context(), prefix)
 	if err != nil {
 		h.handleError(w, err, http.StatusInternalServerError)
 
 		return
 	}
 
-	for k, v := range data {
-		result = append(result, fmt.Sprintf("%s %s", k, v.StringValue()))
+	resp, err := json.Marshal(data)
+	if err != nil {
+		h.handleError(w, err, http.StatusInternalServerError)
+
+		return
 	}
 
-	slices.Sort(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	h.checkRespError(w.Write(resp))
+}
 
-	w.Header().Set("Content-Type", "text/html")
+// defaultStaleAge is the default window the metrics are considered stale
+// if not updated within.
+const defaultStaleAge = 5 * time.Minute
+
+// GetStaleMetrics handles get metrics not updated within the age window request,
+// e.g. /stale?age=10m. The window defaults to 5 minutes.
+func (h *Handlers) GetStaleMetrics(w http.ResponseWriter, r *http.Request) {
+	age := defaultStaleAge
+
+	if s := r.URL.Query().Get("age"); s != "" {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			h.handleError(w, fmt.Errorf("invalid age: %w", err), http.StatusBadRequest)
+
+			return
+		}
+
+		age = v
+	}
+
+	data, err := h.storage.GetStaleMetrics(r.Context(), age)
+	if err != nil {
+		h.handleError(w, err, storageErrorStatus(err))
+
+		return
+	}
+
+	resp, err := json.Marshal(data)
+	if err != nil {
+		h.handleError(w, err, http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	h.checkRespError(w.Write([]byte(strings.Join(result, "\n"))))
+	h.checkRespError(w.Write(resp))
+}
+
+// GetAllMetricsPrometheus handles get all metrics request in the Prometheus
+// text exposition format version 0.0.4. The metrics are filtered by the
+// "match" query parameter like in GetAllMetrics.
+func (h *Handlers) GetAllMetricsPrometheus(w http.ResponseWriter, r *http.Request) {
+	data, status, err := h.matchedMetrics(r.Context(), r.URL.Query().Get("match"))
+	if err != nil {
+		h.handleError(w, err, status)
+
+		return
+	}
+
+	var sb strings.Builder
+
+	for _, metric := range storage.SortedMetrics(data) {
+		name := sanitizePrometheusName(metric.Name)
+
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", name, metric.Type)
+
+		if hist, ok := metric.Value.(storage.HistogramValue); ok {
+			writePrometheusHistogram(&sb, name, hist)
+
+			continue
+		}
+
+		fmt.Fprintf(&sb, "%s %s\n", name, metric.StringValue())
+	}
+
+	var lastWriteAge time.Duration
+	if h.writeTracker != nil {
+		lastWriteAge = h.writeTracker.LastWriteAge()
+	}
+
+	writePrometheusSelfMetrics(&sb, r.URL.Query().Get("match"), lastWriteAge)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	h.checkRespError(w.Write([]byte(sb.String())))
 }
 
+// writePrometheusSelfMetrics writes the server self-metrics matching the
+// pattern. The pattern is validated by matchedMetrics already. The last write
+// age is not written if it is zero, i.e. the writes are not tracked.
+func writePrometheusSelfMetrics(sb *strings.Builder, pattern string, age time.Duration) {
+	if age == 0 {
+		return
+	}
+
+	if matched, _ := path.Match(pattern, storage.LastWriteAgeMetric); pattern != "" && !matched {
+		return
+	}
+
+	fmt.Fprintf(sb, "# TYPE %s gauge\n", storage.LastWriteAgeMetric)
+	fmt.Fprintf(sb, "%s %s\n", storage.LastWriteAgeMetric, strconv.FormatFloat(age.Seconds(), 'f', 3, 64))
+}
+
+// writePrometheusHistogram writes the histogram buckets, sum and count samples.
+func writePrometheusHistogram(sb *strings.Builder, name string, hist storage.HistogramValue) {
+	for i, count := range hist.CumulativeCounts() {
+		fmt.Fprintf(sb, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(hist.Buckets[i], 'f', -1, 64), count)
+	}
+
+	fmt.Fprintf(sb, "%s_bucket{le=\"+Inf\"} %d\n", name, hist.Count)
+	fmt.Fprintf(sb, "%s_sum %s\n", name, strconv.FormatFloat(hist.Sum, 'f', -1, 64))
+	fmt.Fprintf(sb, "%s_count %d\n", name, hist.Count)
+}
+
+// sanitizePrometheusName replaces the characters that are not valid in
+// a Prometheus metric name with underscores.
+func sanitizePrometheusName(name string) string {
+	sanitized := []rune(name)
+
+	for i, c := range sanitized {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			sanitized[i] = '_'
+		}
+	}
+
+	return string(sanitized)
+}
+
+// GetMetric handles get metric value request. The gauge value is formatted
+// with the number of decimal places of the "precision" query parameter if it
+// is set, and with the shortest representation otherwise.
 func (h *Handlers) GetMetric(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
 	metricName := chi.URLParam(r, "metricName")
 	metricType := chi.URLParam(r, "metricType")
 
+	precision, err := parsePrecision(r)
+	if err != nil {
+		h.handleError(w, err, http.StatusBadRequest)
+
+		return
+	}
+
 	var metricValue string
 
 	switch metricType {
 	case string(monitor.MetricCounter):
-		val, err := h.storage.GetCounter(ctx, metricName)
+		val, err := h.getCounter(ctx, metricName)
 		if errors.Is(err, storage.ErrMetricNotFound) {
 			h.handleError(w, err, http.StatusNotFound)
 
@@ -112,7 +460,7 @@ func (h *Handlers) GetMetric(w http.ResponseWriter, r *http.Request) {
 		metricValue = fmt.Sprintf("%d", val)
 
 	case string(monitor.MetricGauge):
-		val, err := h.storage.GetGauge(ctx, metricName)
+		val, err := h.getGauge(ctx, metricName)
 		if errors.Is(err, storage.ErrMetricNotFound) {
 			h.handleError(w, err, http.StatusNotFound)
 
@@ -125,7 +473,7 @@ func (h *Handlers) GetMetric(w http.ResponseWriter, r *http.Request) {
 
 		// Remove trailing zeros in string value to make check tests pass
 		// More info: https://github.com/andymarkow/go-metrics-collector/actions/runs/8584210095/job/23524237884#step:11:32
-		metricValue = strconv.FormatFloat(val, 'f', -1, 64)
+		metricValue = strconv.FormatFloat(val, 'f', precision, 64)
 
 	default:
 		h.handleError(w, errormsg.ErrMetricInvalidType, http.StatusBadRequest)
@@ -138,6 +486,87 @@ func (h *Handlers) GetMetric(w http.ResponseWriter, r *http.Request) {
 	h.checkRespError(io.WriteString(w, metricValue))
 }
 
+// DeleteMetric handles delete metric request.
+func (h *Handlers) DeleteMetric(w http.ResponseWriter, r *http.Request) {
+	metricName := chi.URLParam(r, "metricName")
+	metricType := chi.URLParam(r, "metricType")
+
+	switch metricType {
+	case string(monitor.MetricCounter), string(monitor.MetricGauge):
+	default:
+		h.handleError(w, errormsg.ErrMetricInvalidType, http.StatusBadRequest)
+
+		return
+	}
+
+	err := h.storage.DeleteMetric(r.Context(), metricType, metricName)
+	if errors.Is(err, storage.ErrMetricNotFound) {
+		h.handleError(w, err, http.StatusNotFound)
+
+		return
+	} else if err != nil {
+		h.handleError(w, err, http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	h.checkRespError(w.Write([]byte(http.StatusText(http.StatusOK))))
+}
+
+// ResetCounters sets all the counters to zero and responds with the number
+// of counters reset. The gauges are left untouched.
+func (h *Handlers) ResetCounters(w http.ResponseWriter, r *http.Request) {
+	reset, err := h.storage.ResetCounters(r.Context())
+	if err != nil {
+		h.handleError(w, err, http.StatusInternalServerError)
+
+		return
+	}
+
+	h.log.Info("Counters reset", zap.Int64("count", reset))
+
+	resp, err := json.Marshal(models.ResetCountersResult{Reset: reset})
+	if err != nil {
+		h.handleError(w, err, http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	h.checkRespError(w.Write(resp))
+}
+
+// GetConfig handles the request of the effective server config.
+func (h *Handlers) GetConfig(w http.ResponseWriter, _ *http.Request) {
+	resp, err := json.Marshal(h.config)
+	if err != nil {
+		h.handleError(w, err, http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	h.checkRespError(w.Write(resp))
+}
+
+// GetVersion handles the request of the server build information.
+func (h *Handlers) GetVersion(w http.ResponseWriter, _ *http.Request) {
+	resp, err := json.Marshal(h.buildInfo)
+	if err != nil {
+		h.handleError(w, err, http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	h.checkRespError(w.Write(resp))
+}
+
 func (h *Handlers) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -150,24 +579,38 @@ func (h *Handlers) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metricValue, err := parseGaugeMetricValue(metricValueRaw)
+	metricType := chi.URLParam(r, "metricType")
+
+	mode, err := parseUpdateMode(r, metricType)
 	if err != nil {
-		h.handleError(w, errormsg.ErrMetricInvalidValue, http.StatusBadRequest)
+		h.handleError(w, err, http.StatusBadRequest)
 
 		return
 	}
 
-	metricType := chi.URLParam(r, "metricType")
-
 	switch metricType {
 	case string(monitor.MetricCounter):
-		if err := h.storage.SetCounter(ctx, metricName, int64(metricValue)); err != nil {
-			h.handleError(w, err, http.StatusInternalServerError)
+		metricValue, err := h.parseCounterMetricValue(metricValueRaw)
+		if err != nil {
+			h.handleError(w, errormsg.ErrMetricInvalidValue, http.StatusBadRequest)
+
+			return
+		}
+
+		if err := h.storage.SetCounter(ctx, metricName, metricValue); err != nil {
+			h.handleError(w, err, storageErrorStatus(err))
 
 			return
 		}
 	case string(monitor.MetricGauge):
-		if err := h.storage.SetGauge(ctx, metricName, metricValue); err != nil {
+		metricValue, err := parseGaugeMetricValue(metricValueRaw)
+		if err != nil {
+			h.handleError(w, errormsg.ErrMetricInvalidValue, http.StatusBadRequest)
+
+			return
+		}
+
+		if err := h.setGauge(ctx, mode, metricName, metricValue); err != nil {
 			h.handleError(w, err, http.StatusInternalServerError)
 
 			return
@@ -178,76 +621,127 @@ func (h *Handlers) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
+	if h.returnMinimal(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	h.checkRespError(w.Write([]byte(http.StatusText(http.StatusOK))))
+}
+
+func (h *Handlers) GetMetricJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var metricPayload models.Metrics
+
+	if err := json.NewDecoder(r.Body).Decode(&metricPayload); err != nil {
+		if errors.Is(err, io.EOF) {
+			h.handleJSONError(w, errormsg.ErrEmptyRequestPayload, http.StatusBadRequest)
+
+			return
+		}
+
+		h.handleJSONError(w, err, http.StatusInternalServerError)
+
+		return
+	}
+
+	if err := metricPayload.Validate(h.maxNameLength); err != nil {
+		h.handleJSONError(w, err, http.StatusBadRequest)
+
+		return
+	}
+
+	metricResult, err := h.getMetric(ctx, metricPayload)
+	if errors.Is(err, storage.ErrMetricNotFound) {
+		h.handleJSONError(w, err, http.StatusNotFound)
+
+		return
+	} else if err != nil {
+		h.handleJSONError(w, err, http.StatusInternalServerError)
+
+		return
+	}
+
+	resp, err := json.Marshal(metricResult)
+	if err != nil {
+		h.handleJSONError(w, err, http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	h.checkRespError(w.Write([]byte(http.StatusText(http.StatusOK))))
+	h.checkRespError(w.Write(resp))
 }
 
-func (h *Handlers) GetMetricJSON(w http.ResponseWriter, r *http.Request) {
+// GetMetricsJSON handles the batch get metrics request with the JSON array
+// of the metrics identified by id and type.
+//
+// The response holds the found metrics with their values in the request
+// order. The metrics not found are omitted from the metrics array and
+// reported in the errors array instead, so a partially found batch is
+// still responded with 200. The batch containing an invalid metric is
+// rejected with 400.
+func (h *Handlers) GetMetricsJSON(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	var metricPayload models.Metrics
-	var metricResult models.Metrics
+	var metricsPayload []models.Metrics
 
-	if err := json.NewDecoder(r.Body).Decode(&metricPayload); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&metricsPayload); err != nil {
 		if errors.Is(err, io.EOF) {
-			h.handleError(w, errormsg.ErrEmptyRequestPayload, http.StatusBadRequest)
+			h.handleJSONError(w, errormsg.ErrEmptyRequestPayload, http.StatusBadRequest)
 
 			return
 		}
 
-		h.handleError(w, err, http.StatusInternalServerError)
+		h.handleJSONError(w, err, http.StatusBadRequest)
 
 		return
 	}
 
-	if err := metricPayload.Validate(); err != nil {
-		h.handleError(w, err, http.StatusBadRequest)
+	if h.maxBatchLength > 0 && len(metricsPayload) > h.maxBatchLength {
+		h.handleJSONError(w, fmt.Errorf("%w: more than %d metrics", errormsg.ErrBatchTooLarge, h.maxBatchLength),
+			http.StatusRequestEntityTooLarge)
 
 		return
 	}
 
-	switch metricPayload.MType {
-	case string(monitor.MetricCounter):
-		val, err := h.storage.GetCounter(ctx, metricPayload.ID)
-		if errors.Is(err, storage.ErrMetricNotFound) {
-			h.handleError(w, err, http.StatusNotFound)
-
-			return
-		} else if err != nil {
-			h.handleError(w, err, http.StatusInternalServerError)
+	for _, metric := range metricsPayload {
+		if err := metric.Validate(h.maxNameLength); err != nil {
+			h.handleJSONError(w, fmt.Errorf("invalid metric (%s): %w", metric.ID, err), http.StatusBadRequest)
 
 			return
 		}
+	}
 
-		metricResult = models.Metrics{
-			ID:    metricPayload.ID,
-			MType: metricPayload.MType,
-			Delta: &val,
-		}
+	result := models.GetMetricsResult{
+		Metrics: make([]models.Metrics, 0, len(metricsPayload)),
+	}
 
-	case string(monitor.MetricGauge):
-		val, err := h.storage.GetGauge(ctx, metricPayload.ID)
+	for _, metric := range metricsPayload {
+		metricResult, err := h.getMetric(ctx, metric)
 		if errors.Is(err, storage.ErrMetricNotFound) {
-			h.handleError(w, err, http.StatusNotFound)
+			result.Errors = append(result.Errors, models.MetricError{
+				ID:    metric.ID,
+				MType: metric.MType,
+				Error: err.Error(),
+			})
 
-			return
+			continue
 		} else if err != nil {
-			h.handleError(w, err, http.StatusInternalServerError)
+			h.handleJSONError(w, err, http.StatusInternalServerError)
 
 			return
 		}
 
-		metricResult = models.Metrics{
-			ID:    metricPayload.ID,
-			MType: metricPayload.MType,
-			Value: &val,
-		}
+		result.Metrics = append(result.Metrics, metricResult)
 	}
 
-	resp, err := json.Marshal(metricResult)
+	resp, err := json.Marshal(result)
 	if err != nil {
-		h.handleError(w, err, http.StatusInternalServerError)
+		h.handleJSONError(w, err, http.StatusInternalServerError)
 
 		return
 	}
@@ -257,6 +751,57 @@ func (h *Handlers) GetMetricJSON(w http.ResponseWriter, r *http.Request) {
 	h.checkRespError(w.Write(resp))
 }
 
+// getCounter returns the counter value from the storage. The counter never
+// written is read as zero in the init on read mode.
+func (h *Handlers) getCounter(ctx context.Context, name string) (int64, error) {
+	val, err := h.storage.GetCounter(ctx, name)
+	if errors.Is(err, storage.ErrMetricNotFound) && h.initOnRead {
+		return 0, nil
+	}
+
+	return val, err
+}
+
+// getGauge returns the gauge value from the storage. The gauge never
+// written is read as zero in the init on read mode.
+func (h *Handlers) getGauge(ctx context.Context, name string) (float64, error) {
+	val, err := h.storage.GetGauge(ctx, name)
+	if errors.Is(err, storage.ErrMetricNotFound) && h.initOnRead {
+		return 0, nil
+	}
+
+	return val, err
+}
+
+// getMetric returns the validated metric with the value from the storage.
+// The storage error is returned as is to be responded to the client.
+func (h *Handlers) getMetric(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
+	result := models.Metrics{
+		ID:    metric.ID,
+		MType: metric.MType,
+	}
+
+	switch metric.MType {
+	case string(monitor.MetricCounter):
+		val, err := h.getCounter(ctx, metric.ID)
+		if err != nil {
+			return models.Metrics{}, err
+		}
+
+		result.Delta = &val
+
+	case string(monitor.MetricGauge):
+		val, err := h.getGauge(ctx, metric.ID)
+		if err != nil {
+			return models.Metrics{}, err
+		}
+
+		result.Value = &val
+	}
+
+	return result, nil
+}
+
 func (h *Handlers) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -265,20 +810,27 @@ func (h *Handlers) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 
 	if err := json.NewDecoder(r.Body).Decode(&metricPayload); err != nil {
 		if errors.Is(err, io.EOF) {
-			h.handleError(w, errormsg.ErrEmptyRequestPayload, http.StatusBadRequest)
+			h.handleJSONError(w, errormsg.ErrEmptyRequestPayload, http.StatusBadRequest)
 
 			return
 		}
 
-		h.handleError(w, err, http.StatusBadRequest)
+		h.handleJSONError(w, err, http.StatusBadRequest)
 
 		return
 	}
 
 	h.log.Sugar().Debugf("payload: %+v", metricPayload)
 
-	if err := metricPayload.ValidateUpdate(); err != nil {
-		h.handleError(w, err, http.StatusBadRequest)
+	if err := metricPayload.ValidateUpdate(h.maxNameLength); err != nil {
+		h.handleJSONError(w, err, http.StatusBadRequest)
+
+		return
+	}
+
+	mode, err := parseUpdateMode(r, metricPayload.MType)
+	if err != nil {
+		h.handleJSONError(w, err, http.StatusBadRequest)
 
 		return
 	}
@@ -286,14 +838,14 @@ func (h *Handlers) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 	switch metricPayload.MType {
 	case string(monitor.MetricCounter):
 		if err := h.storage.SetCounter(ctx, metricPayload.ID, *metricPayload.Delta); err != nil {
-			h.handleError(w, err, http.StatusInternalServerError)
+			h.handleJSONError(w, err, storageErrorStatus(err))
 
 			return
 		}
 
 		val, err := h.storage.GetCounter(ctx, metricPayload.ID)
 		if err != nil {
-			h.handleError(w, err, http.StatusInternalServerError)
+			h.handleJSONError(w, err, http.StatusInternalServerError)
 
 			return
 		}
@@ -305,8 +857,15 @@ func (h *Handlers) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 		}
 
 	case string(monitor.MetricGauge):
-		if err := h.storage.SetGauge(ctx, metricPayload.ID, *metricPayload.Value); err != nil {
-			h.handleError(w, err, http.StatusInternalServerError)
+		if err := h.setGauge(ctx, mode, metricPayload.ID, *metricPayload.Value); err != nil {
+			h.handleJSONError(w, err, http.StatusInternalServerError)
+
+			return
+		}
+
+		val, err := h.storage.GetGauge(ctx, metricPayload.ID)
+		if err != nil {
+			h.handleJSONError(w, err, http.StatusInternalServerError)
 
 			return
 		}
@@ -314,13 +873,27 @@ func (h *Handlers) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 		metricResult = models.Metrics{
 			ID:    metricPayload.ID,
 			MType: metricPayload.MType,
-			Value: metricPayload.Value,
+			Value: &val,
+		}
+
+	case string(monitor.MetricHistogram):
+		if err := h.storage.ObserveHistogram(ctx, metricPayload.ID, *metricPayload.Value); err != nil {
+			h.handleJSONError(w, err, storageErrorStatus(err))
+
+			return
 		}
+
+		// The histogram has no single value, so the observation is confirmed.
+		metricResult = metricPayload
+	}
+
+	if h.returnMinimal(w, r) {
+		return
 	}
 
 	resp, err := json.Marshal(metricResult)
 	if err != nil {
-		h.handleError(w, err, http.StatusInternalServerError)
+		h.handleJSONError(w, err, http.StatusInternalServerError)
 
 		return
 	}
@@ -333,32 +906,140 @@ func (h *Handlers) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 func (h *Handlers) UpdateMetricsJSON(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	var metricsPayload []models.Metrics
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-ndjson" {
+		h.updateMetricsNDJSON(w, r)
 
-	if err := json.NewDecoder(r.Body).Decode(&metricsPayload); err != nil {
+		return
+	}
+
+	metricsPayload, err := h.decodeMetrics(r.Body)
+	if err != nil {
 		if errors.Is(err, io.EOF) {
-			h.handleError(w, errormsg.ErrEmptyRequestPayload, http.StatusBadRequest)
+			h.handleJSONError(w, errormsg.ErrEmptyRequestPayload, http.StatusBadRequest)
 
 			return
 		}
 
-		h.handleError(w, err, http.StatusBadRequest)
+		if errors.Is(err, errormsg.ErrBatchTooLarge) {
+			h.handleJSONError(w, err, http.StatusRequestEntityTooLarge)
+
+			return
+		}
+
+		h.handleJSONError(w, err, http.StatusBadRequest)
 
 		return
 	}
 
 	h.log.Sugar().Debugf("payload: %+v", metricsPayload)
 
-	for _, metric := range metricsPayload {
-		if err := metric.ValidateUpdate(); err != nil {
-			h.handleError(w, err, http.StatusBadRequest)
+	// The whole batch is stored at once to not apply it partially.
+	if err := h.storage.SetMetrics(ctx, metricsPayload); err != nil {
+		h.handleJSONError(w, err, storageErrorStatus(err))
+
+		return
+	}
+
+	h.writeUpdatesResponse(w, r, len(metricsPayload))
+}
+
+// ndjsonChunkSize is the number of metrics of the NDJSON batch stored at once.
+const ndjsonChunkSize = 100
+
+// updateMetricsNDJSON handles the batch update with the newline-delimited
+// JSON body, one metric object per line.
+//
+// The metrics are decoded and validated like the JSON array batch elements,
+// with the field mapping and the max batch length applied. They are stored
+// in chunks as they are read, so the batch is not buffered entirely. Unlike
+// the JSON array batch, it may be applied partially: the chunks read before
+// an invalid line or the line exceeding the max batch length are already
+// stored.
+func (h *Handlers) updateMetricsNDJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	reader := bufio.NewReader(r.Body)
+
+	chunk := make([]models.Metrics, 0, ndjsonChunkSize)
+
+	var stored, read int
+
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			h.handleJSONError(w, fmt.Errorf("reader.ReadBytes: %w", err), http.StatusBadRequest)
 
 			return
 		}
+
+		eof := err != nil
+
+		// The blank lines, e.g. the trailing one, are skipped.
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			if read++; h.maxBatchLength > 0 && read > h.maxBatchLength {
+				err := fmt.Errorf("line %d: %w: more than %d metrics", line, errormsg.ErrBatchTooLarge, h.maxBatchLength)
+				h.handleJSONError(w, err, http.StatusRequestEntityTooLarge)
+
+				return
+			}
+
+			metric, err := h.decodeMetric(data)
+			if err != nil {
+				h.handleJSONError(w, fmt.Errorf("line %d: %w", line, err), http.StatusBadRequest)
+
+				return
+			}
+
+			chunk = append(chunk, metric)
+		}
+
+		if len(chunk) == ndjsonChunkSize || (eof && len(chunk) > 0) {
+			if err := h.storage.SetMetrics(ctx, chunk); err != nil {
+				h.handleJSONError(w, err, storageErrorStatus(err))
+
+				return
+			}
+
+			stored += len(chunk)
+			chunk = chunk[:0]
+		}
+
+		if eof {
+			break
+		}
 	}
 
-	if err := h.storage.SetMetrics(ctx, metricsPayload); err != nil {
-		h.handleError(w, err, http.StatusInternalServerError)
+	if stored == 0 {
+		h.handleJSONError(w, errormsg.ErrEmptyRequestPayload, http.StatusBadRequest)
+
+		return
+	}
+
+	h.writeUpdatesResponse(w, r, stored)
+}
+
+// writeUpdatesResponse writes the successful batch update response.
+func (h *Handlers) writeUpdatesResponse(w http.ResponseWriter, r *http.Request, stored int) {
+	if h.returnMinimal(w, r) {
+		return
+	}
+
+	// Confirm the number of stored metrics to all the clients if the JSON
+	// response is enabled, or to the clients requesting it with the
+	// X-Updates-Result header otherwise, like the UpdateMetricsSync client.
+	// The metrics are stored at this point since SetMetrics returns
+	// only after the storage transaction is committed.
+	if h.jsonUpdates || r.Header.Get("X-Updates-Result") == "json" {
+		resp, err := json.Marshal(models.UpdateMetricsResult{Updated: stored})
+		if err != nil {
+			h.handleJSONError(w, err, http.StatusInternalServerError)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		h.checkRespError(w.Write(resp))
 
 		return
 	}
@@ -368,6 +1049,238 @@ func (h *Handlers) UpdateMetricsJSON(w http.ResponseWriter, r *http.Request) {
 	h.checkRespError(w.Write([]byte("OK")))
 }
 
+// returnMinimal responds with 204 No Content if enabled and the client
+// prefers the minimal response by the "Prefer: return=minimal" header.
+// It reports whether the response has been written.
+func (h *Handlers) returnMinimal(w http.ResponseWriter, r *http.Request) bool {
+	if !h.preferMinimal {
+		return false
+	}
+
+	for _, header := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(header, ",") {
+			// Drop the preference parameters, e.g. "return=minimal; foo=bar".
+			pref, _, _ = strings.Cut(pref, ";")
+
+			if strings.EqualFold(strings.TrimSpace(pref), "return=minimal") {
+				w.Header().Set("Preference-Applied", "return=minimal")
+				w.WriteHeader(http.StatusNoContent)
+
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// storageErrorStatus returns the HTTP status code of the storage error.
+func storageErrorStatus(err error) int {
+	if errors.Is(err, errormsg.ErrMetricCounterOverflow) {
+		return http.StatusBadRequest
+	}
+
+	if errors.Is(err, storage.ErrHistogramsNotSupported) || errors.Is(err, storage.ErrStaleMetricsNotSupported) {
+		return http.StatusNotImplemented
+	}
+
+	return http.StatusInternalServerError
+}
+
+// Update modes of the metric update handlers.
+const (
+	// updateModeSet replaces the gauge value.
+	updateModeSet = "set"
+	// updateModeMax replaces the gauge value only if the new one is greater.
+	updateModeMax = "max"
+)
+
+// maxPrecision is the maximum number of decimal places of the gauge value,
+// which keeps the formatted value within a reasonable size.
+const maxPrecision = 64
+
+// parsePrecision parses the "precision" query parameter of the get metric
+// request. It returns -1, the shortest representation, if the parameter is
+// not set.
+func parsePrecision(r *http.Request) (int, error) {
+	if !r.URL.Query().Has("precision") {
+		return -1, nil
+	}
+
+	s := r.URL.Query().Get("precision")
+
+	precision, err := strconv.Atoi(s)
+	if err != nil || precision < 0 || precision > maxPrecision {
+		return 0, fmt.Errorf("%w: %q: expected an integer from 0 to %d", errormsg.ErrInvalidPrecision, s, maxPrecision)
+	}
+
+	return precision, nil
+}
+
+// parseUpdateMode parses the "mode" query parameter of the update request.
+//
+// The "max" mode is supported by gauges only.
+func parseUpdateMode(r *http.Request, metricType string) (string, error) {
+	mode := r.URL.Query().Get("mode")
+
+	switch mode {
+	case "", updateModeSet:
+		return updateModeSet, nil
+
+	case updateModeMax:
+		if metricType != string(monitor.MetricGauge) {
+			return "", fmt.Errorf("%w: %s mode is not supported for %s metric", errormsg.ErrInvalidUpdateMode, mode, metricType)
+		}
+
+		return updateModeMax, nil
+
+	default:
+		return "", fmt.Errorf("%w: %s", errormsg.ErrInvalidUpdateMode, mode)
+	}
+}
+
+// setGauge stores the gauge value according to the update mode.
+func (h *Handlers) setGauge(ctx context.Context, mode, name string, value float64) error {
+	if mode == updateModeMax {
+		return h.storage.SetGaugeMax(ctx, name, value) //nolint:wrapcheck
+	}
+
+	return h.storage.SetGauge(ctx, name, value) //nolint:wrapcheck
+}
+
+// decodeMetrics decodes and validates a batch of metrics from the request body.
+//
+// The alternate JSON field names are renamed according to the handlers
+// field mapping if it is set.
+//
+// The batch is decoded and validated one metric at a time, so that only the
+// metric being decoded is buffered and a batch exceeding the max batch length
+// or containing an invalid metric is rejected before it is decoded entirely.
+// With the field mapping set, the batch is checked once it is decoded.
+func (h *Handlers) decodeMetrics(body io.Reader) ([]models.Metrics, error) {
+	var metrics []models.Metrics
+
+	if len(h.fieldMap) == 0 {
+		return h.decodeMetricsStream(json.NewDecoder(body))
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+
+	metrics, err = models.UnmarshalMetricsJSON(data, h.fieldMap)
+	if err != nil {
+		return nil, fmt.Errorf("models.UnmarshalMetricsJSON: %w", err)
+	}
+
+	if h.maxBatchLength > 0 && len(metrics) > h.maxBatchLength {
+		return nil, fmt.Errorf("%w: more than %d metrics", errormsg.ErrBatchTooLarge, h.maxBatchLength)
+	}
+
+	for _, metric := range metrics {
+		if err := h.validateUpdate(metric); err != nil {
+			return nil, err
+		}
+	}
+
+	return metrics, nil
+}
+
+// decodeMetric decodes a single metric of the batch with the field mapping
+// and validates it like the batch elements.
+func (h *Handlers) decodeMetric(data []byte) (models.Metrics, error) {
+	metric, err := models.UnmarshalMetricJSON(data, h.fieldMap)
+	if err != nil {
+		return metric, fmt.Errorf("models.UnmarshalMetricJSON: %w", err)
+	}
+
+	if err := h.validateUpdate(metric); err != nil {
+		return metric, err
+	}
+
+	return metric, nil
+}
+
+// validateUpdate validates the metric of the batch update.
+func (h *Handlers) validateUpdate(metric models.Metrics) error {
+	if err := metric.ValidateUpdate(h.maxNameLength); err != nil {
+		return fmt.Errorf("invalid metric (%s): %w", metric.ID, err)
+	}
+
+	return nil
+}
+
+// decodeMetricsStream decodes a JSON array of metrics element by element.
+func (h *Handlers) decodeMetricsStream(decoder *json.Decoder) ([]models.Metrics, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, fmt.Errorf("decoder.Token: %w", err)
+	}
+
+	// A null batch is decoded as an empty one.
+	if token == nil {
+		return nil, nil
+	}
+
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("invalid metrics batch: expected JSON array, got %v", token)
+	}
+
+	var metrics []models.Metrics
+
+	for decoder.More() {
+		if h.maxBatchLength > 0 && len(metrics) >= h.maxBatchLength {
+			return nil, fmt.Errorf("%w: more than %d metrics", errormsg.ErrBatchTooLarge, h.maxBatchLength)
+		}
+
+		var metric models.Metrics
+
+		if err := decoder.Decode(&metric); err != nil {
+			return nil, fmt.Errorf("decoder.Decode: %w", err)
+		}
+
+		if err := h.validateUpdate(metric); err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	// Consume the closing bracket to report a truncated batch.
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("decoder.Token: %w", err)
+	}
+
+	return metrics, nil
+}
+
+// parseCounterMetricValue parses counter metric value from string.
+//
+// In strict mode a non-integer value is rejected, otherwise it is parsed
+// as a float and truncated to an integer for lenient clients.
+func (h *Handlers) parseCounterMetricValue(s string) (int64, error) {
+	if h.strictCounters {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("strconv.ParseInt: %w", err)
+		}
+
+		return v, nil
+	}
+
+	v, err := parseGaugeMetricValue(s)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(v), nil
+}
+
 // parseGaugeMetricValue parses gauge metric value from string.
 func parseGaugeMetricValue(s string) (float64, error) {
 	v, err := strconv.ParseFloat(s, 64)
@@ -384,10 +1297,41 @@ func (h *Handlers) checkRespError(_ int, err error) {
 	}
 }
 
+// handleJSONError logs the error and writes it as the JSON error response
+// to the clients of the JSON handlers. The body too large error is responded
+// with 413 like in handleError.
+func (h *Handlers) handleJSONError(w http.ResponseWriter, err error, statusCode int) {
+	if errors.Is(err, errormsg.ErrRequestBodyTooLarge) {
+		statusCode = http.StatusRequestEntityTooLarge
+	}
+
+	h.log.Error(err.Error())
+
+	resp, mErr := json.Marshal(models.ErrorResponse{Error: err.Error(), Code: statusCode})
+	if mErr != nil {
+		http.Error(w, err.Error(), statusCode)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	h.checkRespError(w.Write(resp))
+}
+
 // handleError handles error response.
+//
+// The request body exceeding the decompressed size limit is responded with
+// 413 regardless of the status code given, since it is only detected by
+// the handlers reading the body.
 func (h *Handlers) handleError(
 	w http.ResponseWriter, err error, statusCode int,
 ) {
+	if errors.Is(err, errormsg.ErrRequestBodyTooLarge) {
+		statusCode = http.StatusRequestEntityTooLarge
+	}
+
 	h.log.Error(err.Error())
 	http.Error(w, err.Error(), statusCode)
 }
@@ -2,18 +2,26 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+	"github.com/andymarkow/go-metrics-collector/internal/models"
 	"github.com/andymarkow/go-metrics-collector/internal/storage"
 )
 
@@ -127,6 +135,74 @@ func TestGetAllMetricsHandler(t *testing.T) {
 	}
 }
 
+func TestGetAllMetricsHandlersMatch(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	ctx := context.Background()
+
+	require.NoError(t, strg.SetGauge(ctx, "HeapAlloc", 1))
+	require.NoError(t, strg.SetGauge(ctx, "HeapIdle", 2))
+	require.NoError(t, strg.SetGauge(ctx, "StackInuse", 3))
+	require.NoError(t, strg.SetCounter(ctx, "PollCount", 4))
+
+	h := NewHandlers(strg)
+
+	testCases := []struct {
+		name       string
+		match      string
+		statusCode int
+		want       []string
+	}{
+		{"NoPattern", "", http.StatusOK, []string{"HeapAlloc", "HeapIdle", "PollCount", "StackInuse"}},
+		{"Prefix", "Heap*", http.StatusOK, []string{"HeapAlloc", "HeapIdle"}},
+		{"Glob", "*In?se", http.StatusOK, []string{"StackInuse"}},
+		{"Literal", "PollCount", http.StatusOK, []string{"PollCount"}},
+		{"NoneMatch", "Gc*", http.StatusOK, []string{}},
+		{"InvalidPattern", "Heap[", http.StatusBadRequest, nil},
+	}
+
+	handlers := map[string]http.HandlerFunc{
+		"Text":       h.GetAllMetrics,
+		"Prometheus": h.GetAllMetricsPrometheus,
+	}
+
+	for format, handler := range handlers {
+		for _, tc := range testCases {
+			t.Run(format+tc.name, func(t *testing.T) {
+				req := newChiHTTPRequest(http.MethodGet, "/?match="+url.QueryEscape(tc.match), nil, nil)
+
+				w := httptest.NewRecorder()
+
+				handler(w, req)
+
+				resp := w.Result()
+				defer func() {
+					require.NoError(t, resp.Body.Close())
+				}()
+
+				assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+				if tc.want == nil {
+					return
+				}
+
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				names := make([]string, 0)
+
+				for _, line := range strings.Split(string(body), "\n") {
+					if fields := strings.Fields(line); len(fields) == 2 && !strings.HasPrefix(line, "#") {
+						names = append(names, fields[0])
+					}
+				}
+
+				assert.Equal(t, tc.want, names)
+			})
+		}
+	}
+}
+
 // TestGetMetric tests the GetMetric handler.
 func TestGetMetricHandler(t *testing.T) {
 	type want struct {
@@ -237,6 +313,69 @@ func TestGetMetricHandler(t *testing.T) {
 	}
 }
 
+func TestGetMetricHandlerPrecision(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	ctx := context.Background()
+
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 1))
+	require.NoError(t, strg.SetGauge(ctx, "testGauge", 3.14159))
+
+	h := NewHandlers(strg)
+
+	testCases := []struct {
+		name       string
+		metricType string
+		query      string
+		statusCode int
+		response   string
+	}{
+		{name: "Default", metricType: "gauge", query: "", statusCode: http.StatusOK, response: "3.14159"},
+		{name: "Precision", metricType: "gauge", query: "?precision=3", statusCode: http.StatusOK, response: "3.142"},
+		{name: "ZeroPrecision", metricType: "gauge", query: "?precision=0", statusCode: http.StatusOK, response: "3"},
+		{name: "PaddedPrecision", metricType: "gauge", query: "?precision=7", statusCode: http.StatusOK, response: "3.1415900"},
+		{name: "CounterIgnoresPrecision", metricType: "counter", query: "?precision=3", statusCode: http.StatusOK, response: "1"},
+		{name: "NegativePrecision", metricType: "gauge", query: "?precision=-1", statusCode: http.StatusBadRequest},
+		{name: "NonNumericPrecision", metricType: "gauge", query: "?precision=abc", statusCode: http.StatusBadRequest},
+		{name: "EmptyPrecision", metricType: "gauge", query: "?precision=", statusCode: http.StatusBadRequest},
+		{name: "TooLargePrecision", metricType: "gauge", query: "?precision=65", statusCode: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metricName := "testGauge"
+			if tc.metricType == "counter" {
+				metricName = "testCounter"
+			}
+
+			req := newChiHTTPRequest(http.MethodGet, "/value/{metricType}/{metricName}"+tc.query, map[string]string{
+				"metricName": metricName,
+				"metricType": tc.metricType,
+			}, nil)
+
+			w := httptest.NewRecorder()
+
+			h.GetMetric(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+			if tc.statusCode == http.StatusOK {
+				assert.Equal(t, tc.response, string(body))
+			} else {
+				assert.Contains(t, string(body), errormsg.ErrInvalidPrecision.Error())
+			}
+		})
+	}
+}
+
 // TestUpdateMetric tests the UpdateMetric handler.
 func TestUpdateMetricHandler(t *testing.T) {
 	type want struct {
@@ -367,6 +506,18 @@ func TestUpdateMetricHandler(t *testing.T) {
 	}
 }
 
+// assertJSONError asserts the body is the JSON error response with the status code.
+func assertJSONError(t *testing.T, statusCode int, body []byte) {
+	t.Helper()
+
+	var errResp models.ErrorResponse
+
+	require.NoError(t, json.Unmarshal(body, &errResp))
+
+	assert.Equal(t, statusCode, errResp.Code)
+	assert.NotEmpty(t, errResp.Error)
+}
+
 // TestGetMetricJSONHandler tests the GetMetricJSON handler.
 func TestGetMetricJSONHandler(t *testing.T) {
 	type want struct {
@@ -414,7 +565,7 @@ func TestGetMetricJSONHandler(t *testing.T) {
 			name: "EmptyRequestPayload",
 			body: "",
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -423,7 +574,7 @@ func TestGetMetricJSONHandler(t *testing.T) {
 			name: "EmptyMetricName",
 			body: `{"id": "", "type": "counter"}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -432,7 +583,7 @@ func TestGetMetricJSONHandler(t *testing.T) {
 			name: "EmptyMetricType",
 			body: `{"id": "testCounter", "type": ""}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -441,7 +592,7 @@ func TestGetMetricJSONHandler(t *testing.T) {
 			name: "NonExistingCounterMetric",
 			body: `{"id": "nonexistingCounter", "type": "counter"}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusNotFound,
 				response:    "",
 			},
@@ -450,7 +601,7 @@ func TestGetMetricJSONHandler(t *testing.T) {
 			name: "NonExistingGaugeMetric",
 			body: `{"id": "nonexistingGauge", "type": "gauge"}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusNotFound,
 				response:    "",
 			},
@@ -459,7 +610,7 @@ func TestGetMetricJSONHandler(t *testing.T) {
 			name: "InvalidMetricType",
 			body: `{"id": "testGauge", "type": "invalid"}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -468,7 +619,7 @@ func TestGetMetricJSONHandler(t *testing.T) {
 			name: "InvalidJSONPayload",
 			body: `{"id": "testGauge", "type": "counter}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusInternalServerError,
 				response:    "",
 			},
@@ -494,6 +645,10 @@ func TestGetMetricJSONHandler(t *testing.T) {
 			assert.Equal(t, tc.want.contentType, resp.Header.Get("Content-Type"))
 			assert.Equal(t, tc.want.statusCode, resp.StatusCode)
 
+			if tc.want.statusCode != http.StatusOK {
+				assertJSONError(t, tc.want.statusCode, body)
+			}
+
 			if tc.want.response != "" {
 				assert.JSONEq(t, tc.want.response, string(body))
 			}
@@ -501,6 +656,173 @@ func TestGetMetricJSONHandler(t *testing.T) {
 	}
 }
 
+func TestGetMetricsJSONHandler(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	ctx := context.Background()
+
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 1))
+	require.NoError(t, strg.SetGauge(ctx, "testGauge", 3.14))
+
+	h := NewHandlers(strg, WithMaxBatchLength(3))
+
+	testCases := []struct {
+		name       string
+		body       string
+		response   string
+		statusCode int
+	}{
+		{
+			name: "AllFound",
+			body: `[{"id": "testGauge", "type": "gauge"}, {"id": "testCounter", "type": "counter"}]`,
+			response: `{"metrics": [
+				{"id": "testGauge", "type": "gauge", "value": 3.14},
+				{"id": "testCounter", "type": "counter", "delta": 1}
+			]}`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name: "PartiallyFound",
+			body: `[{"id": "testCounter", "type": "counter"}, {"id": "nonexisting", "type": "gauge"}]`,
+			response: `{
+				"metrics": [{"id": "testCounter", "type": "counter", "delta": 1}],
+				"errors": [{"id": "nonexisting", "type": "gauge", "error": "metric not found"}]
+			}`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "EmptyBatch",
+			body:       `[]`,
+			response:   `{"metrics": []}`,
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "InvalidMetricType",
+			body:       `[{"id": "testCounter", "type": "counter"}, {"id": "testGauge", "type": "invalid"}]`,
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "EmptyRequestPayload",
+			body:       "",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name: "BatchTooLarge",
+			body: `[{"id": "a", "type": "gauge"}, {"id": "b", "type": "gauge"},
+				{"id": "c", "type": "gauge"}, {"id": "d", "type": "gauge"}]`,
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newChiHTTPRequest(http.MethodPost, "/values", nil, strings.NewReader(tc.body))
+
+			w := httptest.NewRecorder()
+
+			h.GetMetricsJSON(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+			if tc.response != "" {
+				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+				assert.JSONEq(t, tc.response, string(body))
+			}
+		})
+	}
+}
+
+func TestGetMetricHandlersInitOnRead(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	require.NoError(t, strg.SetCounter(context.Background(), "testCounter", 5))
+
+	testCases := []struct {
+		name       string
+		initOnRead bool
+		statusCode int
+		response   string
+	}{
+		{"Disabled", false, http.StatusNotFound, ""},
+		{"Enabled", true, http.StatusOK, "0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHandlers(strg, WithInitOnRead(tc.initOnRead))
+
+			// The written metric is read as is.
+			req := newChiHTTPRequest(http.MethodGet, "/value/counter/testCounter",
+				map[string]string{"metricType": "counter", "metricName": "testCounter"}, nil)
+
+			w := httptest.NewRecorder()
+
+			h.GetMetric(w, req)
+
+			resp := w.Result()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "5", string(body))
+
+			for _, metricType := range []string{"counter", "gauge"} {
+				req := newChiHTTPRequest(http.MethodGet, "/value/"+metricType+"/unwritten",
+					map[string]string{"metricType": metricType, "metricName": "unwritten"}, nil)
+
+				w := httptest.NewRecorder()
+
+				h.GetMetric(w, req)
+
+				resp := w.Result()
+
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+
+				assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+				if tc.response != "" {
+					assert.Equal(t, tc.response, string(body))
+				}
+			}
+
+			req = newChiHTTPRequest(http.MethodPost, "/value", nil,
+				strings.NewReader(`{"id": "unwritten", "type": "gauge"}`))
+
+			w = httptest.NewRecorder()
+
+			h.GetMetricJSON(w, req)
+
+			resp = w.Result()
+
+			body, err = io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+			if tc.response != "" {
+				assert.JSONEq(t, `{"id": "unwritten", "type": "gauge", "value": 0}`, string(body))
+			}
+
+			// The metric is not created on read.
+			_, err = strg.GetGauge(context.Background(), "unwritten")
+			require.ErrorIs(t, err, storage.ErrMetricNotFound)
+		})
+	}
+}
+
 // TestUpdateMetricJSONHandler tests the UpdateMetricJSON handler.
 func TestUpdateMetricJSONHandler(t *testing.T) {
 	type want struct {
@@ -540,7 +862,7 @@ func TestUpdateMetricJSONHandler(t *testing.T) {
 			name: "EmptyRequestPayload",
 			body: "",
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -549,7 +871,7 @@ func TestUpdateMetricJSONHandler(t *testing.T) {
 			name: "EmptyMetricName",
 			body: `{"id": "", "type": "gauge", "value": 3.14}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -558,7 +880,7 @@ func TestUpdateMetricJSONHandler(t *testing.T) {
 			name: "EmptyMetricType",
 			body: `{"id": "testCounter", "type": "", "delta": 1}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -567,7 +889,7 @@ func TestUpdateMetricJSONHandler(t *testing.T) {
 			name: "EmptyCounterDelta",
 			body: `{"id": "testCounter", "type": "counter"}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -576,7 +898,7 @@ func TestUpdateMetricJSONHandler(t *testing.T) {
 			name: "EmptyGaugeValue",
 			body: `{"id": "testGauge", "type": "gauge"}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -585,7 +907,7 @@ func TestUpdateMetricJSONHandler(t *testing.T) {
 			name: "InvalidMetricType",
 			body: `{"id": "testGauge", "type": "invalid", "value": 3.14}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -594,7 +916,7 @@ func TestUpdateMetricJSONHandler(t *testing.T) {
 			name: "InvalidCounterDelta",
 			body: `{"id": "testCounter", "type": "counter", "delta": "1"}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -603,7 +925,7 @@ func TestUpdateMetricJSONHandler(t *testing.T) {
 			name: "InvalidGaugeValue",
 			body: `{"id": "testGauge", "type": "gauge", "value": "3.14"}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -612,7 +934,7 @@ func TestUpdateMetricJSONHandler(t *testing.T) {
 			name: "InvalidJSONPayload",
 			body: `{"id": "testGauge", "type": "gauge", "value": "3.14}`,
 			want: want{
-				contentType: "text/plain; charset=utf-8",
+				contentType: "application/json",
 				statusCode:  http.StatusBadRequest,
 				response:    "",
 			},
@@ -638,9 +960,970 @@ func TestUpdateMetricJSONHandler(t *testing.T) {
 			body, err := io.ReadAll(resp.Body)
 			require.NoError(t, err)
 
+			if tc.want.statusCode != http.StatusOK {
+				assertJSONError(t, tc.want.statusCode, body)
+			}
+
 			if tc.want.response != "" {
 				assert.JSONEq(t, tc.want.response, string(body))
 			}
 		})
 	}
 }
+
+// TestUpdateMetricsJSONHandler tests the UpdateMetricsJSON handler.
+func TestUpdateMetricsJSONHandler(t *testing.T) {
+	type want struct {
+		contentType string
+		response    string
+		statusCode  int
+	}
+
+	strg := storage.NewMemStorage()
+
+	h := NewHandlers(strg)
+
+	testCases := []struct {
+		name   string
+		body   string
+		accept string
+		result string
+		want   want
+	}{
+		{
+			name: "UpdateMetrics",
+			body: `[{"id": "testCounter", "type": "counter", "delta": 1}, {"id": "testGauge", "type": "gauge", "value": 3.14}]`,
+			want: want{
+				contentType: "text/html",
+				statusCode:  http.StatusOK,
+				response:    "OK",
+			},
+		},
+		{
+			name: "UpdateMetricsAcceptJSON",
+			body: `[{"id": "testCounter", "type": "counter", "delta": 1}, {"id": "testGauge", "type": "gauge", "value": 3.14}]`,
+			// Accepting JSON alone keeps the plain OK response.
+			accept: "application/json",
+			want: want{
+				contentType: "text/html",
+				statusCode:  http.StatusOK,
+				response:    "OK",
+			},
+		},
+		{
+			name:   "UpdateMetricsResultJSON",
+			body:   `[{"id": "testCounter", "type": "counter", "delta": 1}, {"id": "testGauge", "type": "gauge", "value": 3.14}]`,
+			result: "json",
+			want: want{
+				contentType: "application/json",
+				statusCode:  http.StatusOK,
+				response:    `{"updated": 2}`,
+			},
+		},
+		{
+			name: "EmptyRequestPayload",
+			body: "",
+			want: want{
+				contentType: "application/json",
+				statusCode:  http.StatusBadRequest,
+			},
+		},
+		{
+			name: "EmptyCounterDelta",
+			body: `[{"id": "testCounter", "type": "counter"}]`,
+			want: want{
+				contentType: "application/json",
+				statusCode:  http.StatusBadRequest,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newChiHTTPRequest(http.MethodPost, "/updates", nil, strings.NewReader(tc.body))
+			req.Header.Set("Accept", tc.accept)
+			req.Header.Set("X-Updates-Result", tc.result)
+
+			w := httptest.NewRecorder()
+
+			h.UpdateMetricsJSON(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			assert.Equal(t, tc.want.statusCode, resp.StatusCode)
+			assert.Equal(t, tc.want.contentType, resp.Header.Get("Content-Type"))
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			switch {
+			case tc.want.statusCode != http.StatusOK:
+				assertJSONError(t, tc.want.statusCode, body)
+			case tc.want.contentType == "application/json":
+				assert.JSONEq(t, tc.want.response, string(body))
+			case tc.want.contentType == "text/html":
+				assert.Equal(t, tc.want.response, string(body))
+			}
+		})
+	}
+}
+
+// TestUpdateMetricsJSONHandlerJSONResponse tests the UpdateMetricsJSON handler
+// with the JSON response enabled.
+func TestUpdateMetricsJSONHandlerJSONResponse(t *testing.T) {
+	h := NewHandlers(storage.NewMemStorage(), WithJSONUpdatesResponse(true))
+
+	testCases := []struct {
+		name     string
+		result   string
+		response string
+	}{
+		{"Enabled", "", `{"updated": 2}`},
+		// The X-Updates-Result header requests the same response.
+		{"UpdatesResultJSON", "json", `{"updated": 2}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := `[{"id": "testCounter", "type": "counter", "delta": 1}, {"id": "testGauge", "type": "gauge", "value": 3.14}]`
+
+			req := newChiHTTPRequest(http.MethodPost, "/updates", nil, strings.NewReader(body))
+			req.Header.Set("X-Updates-Result", tc.result)
+
+			w := httptest.NewRecorder()
+
+			h.UpdateMetricsJSON(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+			data, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.response, string(data))
+		})
+	}
+}
+
+// TestUpdateMetricsJSONHandlerFieldMapping tests the UpdateMetricsJSON handler
+// with alternate JSON field names.
+func TestUpdateMetricsJSONHandlerFieldMapping(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	h := NewHandlers(strg, WithFieldMapping(map[string]string{
+		"metric_id":   "id",
+		"metric_type": "type",
+	}))
+
+	body := `[{"metric_id": "testCounter", "metric_type": "counter", "delta": 1}, {"id": "testGauge", "type": "gauge", "value": 3.14}]`
+
+	req := newChiHTTPRequest(http.MethodPost, "/updates", nil, strings.NewReader(body))
+
+	w := httptest.NewRecorder()
+
+	h.UpdateMetricsJSON(w, req)
+
+	resp := w.Result()
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	counter, err := strg.GetCounter(context.Background(), "testCounter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counter)
+
+	gauge, err := strg.GetGauge(context.Background(), "testGauge")
+	require.NoError(t, err)
+	assert.InEpsilon(t, 3.14, gauge, 0.001)
+}
+
+// TestUpdateMetricHandlerMaxMode tests the UpdateMetric handler with the max update mode.
+func TestUpdateMetricHandlerMaxMode(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	h := NewHandlers(strg)
+
+	testCases := []struct {
+		name       string
+		kind       string
+		value      string
+		statusCode int
+		want       float64
+	}{
+		{"SetInitialValue", "gauge", "2.5", http.StatusOK, 2.5},
+		{"IgnoreSmallerValue", "gauge", "1.5", http.StatusOK, 2.5},
+		{"SetGreaterValue", "gauge", "3.5", http.StatusOK, 3.5},
+		{"CounterNotSupported", "counter", "1", http.StatusBadRequest, 3.5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newChiHTTPRequest(http.MethodPost, "/update/{metricType}/{metricName}/{metricValue}?mode=max", map[string]string{
+				"metricName":  "testGauge",
+				"metricType":  tc.kind,
+				"metricValue": tc.value,
+			}, nil)
+
+			w := httptest.NewRecorder()
+
+			h.UpdateMetric(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+			val, err := strg.GetGauge(context.Background(), "testGauge")
+			require.NoError(t, err)
+			assert.InDelta(t, tc.want, val, 0)
+		})
+	}
+}
+
+func TestUpdateMetricHandlerCounterOverflow(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	require.NoError(t, strg.SetCounter(context.Background(), "testCounter", math.MaxInt64))
+
+	h := NewHandlers(strg)
+
+	req := newChiHTTPRequest(http.MethodPost, "/update/{metricType}/{metricName}/{metricValue}", map[string]string{
+		"metricName":  "testCounter",
+		"metricType":  "counter",
+		"metricValue": "1",
+	}, nil)
+
+	w := httptest.NewRecorder()
+
+	h.UpdateMetric(w, req)
+
+	resp := w.Result()
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, string(body), errormsg.ErrMetricCounterOverflow.Error())
+}
+
+// TestUpdateMetricHandlerStrictCounters tests the UpdateMetric handler
+// with a non-integer counter value in strict and lenient modes.
+func TestUpdateMetricHandlerStrictCounters(t *testing.T) {
+	testCases := []struct {
+		name       string
+		strict     bool
+		statusCode int
+		want       int64
+	}{
+		{"Lenient", false, http.StatusOK, 3},
+		{"Strict", true, http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			strg := storage.NewMemStorage()
+
+			h := NewHandlers(strg, WithStrictCounters(tc.strict))
+
+			req := newChiHTTPRequest(http.MethodPost, "/update/{metricType}/{metricName}/{metricValue}", map[string]string{
+				"metricName":  "testCounter",
+				"metricType":  "counter",
+				"metricValue": "3.9",
+			}, nil)
+
+			w := httptest.NewRecorder()
+
+			h.UpdateMetric(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+			val, err := strg.GetCounter(context.Background(), "testCounter")
+			if tc.strict {
+				require.ErrorIs(t, err, storage.ErrMetricNotFound)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, val)
+		})
+	}
+}
+
+// TestDeleteMetricHandler tests the DeleteMetric handler.
+func TestDeleteMetricHandler(t *testing.T) {
+	strg := storage.NewMemStorage()
+	require.NoError(t, strg.SetCounter(context.Background(), "testCounter", 1))
+
+	h := NewHandlers(strg)
+
+	testCases := []struct {
+		name       string
+		kind       string
+		statusCode int
+	}{
+		{"DeleteMetric", "counter", http.StatusOK},
+		{"MetricNotFound", "counter", http.StatusNotFound},
+		{"InvalidMetricType", "unknown", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newChiHTTPRequest(http.MethodDelete, "/value/{metricType}/{metricName}", map[string]string{
+				"metricName": "testCounter",
+				"metricType": tc.kind,
+			}, nil)
+
+			w := httptest.NewRecorder()
+
+			h.DeleteMetric(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+		})
+	}
+}
+
+// TestGetAllMetricsPrometheusHandler tests the GetAllMetricsPrometheus handler.
+func TestGetAllMetricsPrometheusHandler(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	ctx := context.Background()
+
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 1))
+	require.NoError(t, strg.SetGauge(ctx, "test.gauge-1", 3.14))
+	require.NoError(t, strg.SetGauge(ctx, "1stGauge", 2))
+
+	h := NewHandlers(strg)
+
+	req, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+
+	h.GetAllMetricsPrometheus(w, req)
+
+	resp := w.Result()
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "# TYPE _stGauge gauge\n_stGauge 2\n"+
+		"# TYPE test_gauge_1 gauge\ntest_gauge_1 3.14\n"+
+		"# TYPE testCounter counter\ntestCounter 1\n", string(body))
+}
+
+func TestReadHandlersStableOrder(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	ctx := context.Background()
+
+	for i := range 50 {
+		require.NoError(t, strg.SetGauge(ctx, fmt.Sprintf("gauge%d", i), float64(i)))
+		require.NoError(t, strg.SetCounter(ctx, fmt.Sprintf("counter%d", i), int64(i)))
+	}
+
+	h := NewHandlers(strg)
+
+	testCases := []struct {
+		handler http.HandlerFunc
+		name    string
+		url     string
+	}{
+		{name: "GetAllMetrics", url: "/", handler: h.GetAllMetrics},
+		{name: "GetAllMetricsPrometheus", url: "/metrics", handler: h.GetAllMetricsPrometheus},
+		{name: "GetMetricsByPrefix", url: "/values/gauge", handler: h.GetMetricsByPrefix},
+		{name: "GetStaleMetrics", url: "/stale?age=-1m", handler: h.GetStaleMetrics},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var first string
+
+			for i := range 10 {
+				req := newChiHTTPRequest(http.MethodGet, tc.url, map[string]string{"prefix": "gauge"}, nil)
+
+				w := httptest.NewRecorder()
+
+				tc.handler(w, req)
+
+				resp := w.Result()
+
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+
+				require.Equal(t, http.StatusOK, resp.StatusCode)
+
+				if i == 0 {
+					first = string(body)
+
+					continue
+				}
+
+				assert.Equal(t, first, string(body))
+			}
+		})
+	}
+}
+
+func TestUpdateMetricJSONHandlerHistogram(t *testing.T) {
+	strg := storage.NewMemStorage(storage.WithHistogramBuckets([]float64{0.1, 1}))
+
+	h := NewHandlers(strg)
+
+	testCases := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"Observe", `{"id":"latency","type":"histogram","value":0.05}`, http.StatusOK},
+		{"ObserveAgain", `{"id":"latency","type":"histogram","value":0.5}`, http.StatusOK},
+		{"EmptyValue", `{"id":"latency","type":"histogram"}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update", strings.NewReader(tc.body))
+
+			w := httptest.NewRecorder()
+
+			h.UpdateMetricJSON(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+
+	w := httptest.NewRecorder()
+
+	h.GetAllMetricsPrometheus(w, req)
+
+	resp := w.Result()
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "# TYPE latency histogram\n"+
+		"latency_bucket{le=\"0.1\"} 1\n"+
+		"latency_bucket{le=\"1\"} 2\n"+
+		"latency_bucket{le=\"+Inf\"} 2\n"+
+		"latency_sum 0.55\n"+
+		"latency_count 2\n", string(body))
+}
+
+func TestGetMetricsByPrefixHandler(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	ctx := context.Background()
+
+	updatedAt := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	require.NoError(t, strg.LoadData(ctx, map[string]storage.Metric{
+		"HeapAlloc": {Type: "gauge", Value: 1.5, UpdatedAt: updatedAt},
+		"HeapSys":   {Type: "gauge", Value: 2.0, UpdatedAt: updatedAt},
+		"PollCount": {Type: "counter", Value: float64(3), UpdatedAt: updatedAt},
+	}))
+
+	h := NewHandlers(strg)
+
+	testCases := []struct {
+		name   string
+		prefix string
+		want   string
+	}{
+		{"Matching", "Heap", `{
+			"HeapAlloc":{"value":1.5,"type":"gauge","updated_at":"2024-01-01T00:00:00Z"},
+			"HeapSys":{"value":2,"type":"gauge","updated_at":"2024-01-01T00:00:00Z"}
+		}`},
+		{"NotMatching", "Stack", `{}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newChiHTTPRequest(http.MethodGet, "/values/{prefix}", map[string]string{
+				"prefix": tc.prefix,
+			}, nil)
+
+			w := httptest.NewRecorder()
+
+			h.GetMetricsByPrefix(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			assert.JSONEq(t, tc.want, string(body))
+		})
+	}
+}
+
+func TestUpdateMetricsJSONHandlerMaxBatchLength(t *testing.T) {
+	fieldMap := map[string]string{"metric_id": "id"}
+
+	testCases := []struct {
+		name       string
+		opts       []Option
+		body       string
+		statusCode int
+	}{
+		{"WithinLimit", nil, `[{"id":"c1","type":"counter","delta":1},{"id":"c2","type":"counter","delta":1}]`, http.StatusOK},
+		{"ExceedsLimit", nil, `[{"id":"c1","type":"counter","delta":1},{"id":"c2","type":"counter","delta":1},{"id":"c3","type":"counter","delta":1}]`, http.StatusRequestEntityTooLarge},
+		{"ExceedsLimitFieldMapping", []Option{WithFieldMapping(fieldMap)}, `[{"metric_id":"c1","type":"counter","delta":1},{"id":"c2","type":"counter","delta":1},{"id":"c3","type":"counter","delta":1}]`, http.StatusRequestEntityTooLarge},
+		{"NullBatch", nil, `null`, http.StatusOK},
+		{"NotArray", nil, `{"id":"c1","type":"counter","delta":1}`, http.StatusBadRequest},
+		{"Truncated", nil, `[{"id":"c1","type":"counter","delta":1}`, http.StatusBadRequest},
+		{"Empty", nil, ``, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHandlers(storage.NewMemStorage(), append([]Option{WithMaxBatchLength(2)}, tc.opts...)...)
+
+			req := newChiHTTPRequest(http.MethodPost, "/updates", nil, strings.NewReader(tc.body))
+
+			w := httptest.NewRecorder()
+
+			h.UpdateMetricsJSON(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+		})
+	}
+}
+
+// BenchmarkUpdateMetricsJSON benchmarks the batch update of 10000 metrics.
+func BenchmarkUpdateMetricsJSON(b *testing.B) {
+	metrics := make([]models.Metrics, 0, 10000)
+
+	for i := range 10000 {
+		value := float64(i)
+		metrics = append(metrics, models.Metrics{ID: fmt.Sprintf("gauge%d", i), MType: "gauge", Value: &value})
+	}
+
+	body, err := json.Marshal(metrics)
+	require.NoError(b, err)
+
+	h := NewHandlers(storage.NewMemStorage())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		req := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(body))
+
+		w := httptest.NewRecorder()
+
+		h.UpdateMetricsJSON(w, req)
+
+		if w.Code != http.StatusOK {
+			b.Fatalf("unexpected status code: %d", w.Code)
+		}
+	}
+}
+
+func TestUpdateMetricsJSONHandlerInvalidMetric(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	h := NewHandlers(strg)
+
+	// The batch is rejected as a whole when any of the metrics is invalid.
+	body := `[{"id":"c1","type":"counter","delta":1},{"id":"c2","type":"counter"},{"id":"c3","type":"counter","delta":1}]`
+
+	req := newChiHTTPRequest(http.MethodPost, "/updates", nil, strings.NewReader(body))
+
+	w := httptest.NewRecorder()
+
+	h.UpdateMetricsJSON(w, req)
+
+	resp := w.Result()
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	data, err := strg.GetAllMetrics(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestGetStaleMetricsHandler(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	ctx := context.Background()
+
+	require.NoError(t, strg.LoadData(ctx, map[string]storage.Metric{
+		"HeapAlloc": {Type: "gauge", Value: 1.5, UpdatedAt: time.Now().Add(-10 * time.Minute)},
+		"PollCount": {Type: "counter", Value: float64(3), UpdatedAt: time.Now().Add(-2 * time.Minute)},
+	}))
+
+	h := NewHandlers(strg)
+
+	testCases := []struct {
+		name       string
+		query      string
+		statusCode int
+		want       []string
+	}{
+		{"DefaultAge", "", http.StatusOK, []string{"HeapAlloc"}},
+		{"CustomAge", "?age=1m", http.StatusOK, []string{"HeapAlloc", "PollCount"}},
+		{"NoneStale", "?age=1h", http.StatusOK, []string{}},
+		{"InvalidAge", "?age=five", http.StatusBadRequest, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newChiHTTPRequest(http.MethodGet, "/stale"+tc.query, nil, nil)
+
+			w := httptest.NewRecorder()
+
+			h.GetStaleMetrics(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+			if tc.want == nil {
+				return
+			}
+
+			var data map[string]storage.Metric
+
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&data))
+
+			names := make([]string, 0, len(data))
+			for name := range data {
+				names = append(names, name)
+			}
+
+			assert.ElementsMatch(t, tc.want, names)
+		})
+	}
+}
+
+func TestGetVersionHandler(t *testing.T) {
+	info := models.BuildInfo{Version: "v1.2.3", Date: "2024-06-01", Commit: "abc123"}
+
+	h := NewHandlers(storage.NewMemStorage(), WithBuildInfo(info))
+
+	req := newChiHTTPRequest(http.MethodGet, "/version", nil, nil)
+
+	w := httptest.NewRecorder()
+
+	h.GetVersion(w, req)
+
+	resp := w.Result()
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var got models.BuildInfo
+
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+
+	assert.Equal(t, info, got)
+}
+
+func TestUpdateHandlersPreferMinimal(t *testing.T) {
+	testCases := []struct {
+		name          string
+		preferMinimal bool
+		prefer        string
+		statusCode    int
+	}{
+		{"Minimal", true, "return=minimal", http.StatusNoContent},
+		{"MinimalAmongOthers", true, "respond-async, Return=Minimal; foo=bar", http.StatusNoContent},
+		{"Representation", true, "return=representation", http.StatusOK},
+		{"NoPreference", true, "", http.StatusOK},
+		{"Disabled", false, "return=minimal", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHandlers(storage.NewMemStorage(), WithPreferMinimal(tc.preferMinimal))
+
+			requests := map[string]struct {
+				handler http.HandlerFunc
+				req     *http.Request
+			}{
+				"UpdateMetric": {h.UpdateMetric, newChiHTTPRequest(http.MethodPost, "/update/{metricType}/{metricName}/{metricValue}", map[string]string{
+					"metricType":  "counter",
+					"metricName":  "testCounter",
+					"metricValue": "1",
+				}, nil)},
+				"UpdateMetricJSON": {h.UpdateMetricJSON, newChiHTTPRequest(http.MethodPost, "/update", nil,
+					strings.NewReader(`{"id": "testCounter", "type": "counter", "delta": 1}`))},
+				"UpdateMetricsJSON": {h.UpdateMetricsJSON, newChiHTTPRequest(http.MethodPost, "/updates", nil,
+					strings.NewReader(`[{"id": "testCounter", "type": "counter", "delta": 1}]`))},
+			}
+
+			for name, r := range requests {
+				r.req.Header.Set("Prefer", tc.prefer)
+
+				w := httptest.NewRecorder()
+
+				r.handler(w, r.req)
+
+				resp := w.Result()
+
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+
+				assert.Equal(t, tc.statusCode, resp.StatusCode, name)
+
+				if tc.statusCode == http.StatusNoContent {
+					assert.Empty(t, body, name)
+					assert.Equal(t, "return=minimal", resp.Header.Get("Preference-Applied"), name)
+				} else {
+					assert.NotEmpty(t, body, name)
+				}
+			}
+		})
+	}
+}
+
+// unhealthyStorage fails the health check.
+type unhealthyStorage struct {
+	storage.Storage
+}
+
+func (s *unhealthyStorage) HealthCheck(_ context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestReadyHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		strg       storage.Storage
+		statusCode int
+	}{
+		{"Healthy", storage.NewMemStorage(), http.StatusOK},
+		{"Unhealthy", &unhealthyStorage{Storage: storage.NewMemStorage()}, http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHandlers(tc.strg)
+
+			w := httptest.NewRecorder()
+
+			h.Ready(w, newChiHTTPRequest(http.MethodGet, "/ready", nil, nil))
+
+			resp := w.Result()
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+			// The ping is not affected by the health check.
+			w = httptest.NewRecorder()
+
+			h.Ping(w, newChiHTTPRequest(http.MethodGet, "/ping", nil, nil))
+
+			resp = w.Result()
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+// chunkStorage counts the SetMetrics calls.
+type chunkStorage struct {
+	storage.Storage
+	chunks []int
+}
+
+func (s *chunkStorage) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+	s.chunks = append(s.chunks, len(metrics))
+
+	return s.Storage.SetMetrics(ctx, metrics) //nolint:wrapcheck
+}
+
+func TestUpdateMetricsJSONHandlerNDJSON(t *testing.T) {
+	var sb strings.Builder
+
+	for i := range 250 {
+		fmt.Fprintf(&sb, "{\"id\": \"gauge%d\", \"type\": \"gauge\", \"value\": %d}\n", i, i)
+	}
+
+	testCases := []struct {
+		name       string
+		body       string
+		statusCode int
+		response   string
+		chunks     []int
+		stored     int
+	}{
+		{
+			name:       "Chunks",
+			body:       sb.String(),
+			statusCode: http.StatusOK,
+			response:   `{"updated": 250}`,
+			chunks:     []int{100, 100, 50},
+			stored:     250,
+		},
+		{
+			name:       "NoTrailingNewline",
+			body:       "{\"id\": \"c1\", \"type\": \"counter\", \"delta\": 1}\n\n{\"id\": \"c2\", \"type\": \"counter\", \"delta\": 2}",
+			statusCode: http.StatusOK,
+			response:   `{"updated": 2}`,
+			chunks:     []int{2},
+			stored:     2,
+		},
+		{
+			name:       "InvalidLine",
+			body:       "{\"id\": \"c1\", \"type\": \"counter\", \"delta\": 1}\n{\"id\": \"c2\", \"type\": \"counter\", \"delta\": 2}\n{\"id\": \"c3\", \"type\": \"counter\"}\n",
+			statusCode: http.StatusBadRequest,
+			response:   `{"error": "line 3: invalid metric (c3): ` + errormsg.ErrMetricEmptyDelta.Error() + `", "code": 400}`,
+		},
+		{
+			name:       "MalformedLine",
+			body:       "{\"id\": \"c1\", \"type\": \"counter\", \"delta\": 1}\n[1, 2]\n",
+			statusCode: http.StatusBadRequest,
+			response:   `{"error": "line 2: models.UnmarshalMetricJSON: json.Unmarshal: json: cannot unmarshal array into Go value of type models.Metrics", "code": 400}`,
+		},
+		{
+			name:       "Empty",
+			body:       "\n",
+			statusCode: http.StatusBadRequest,
+			response:   `{"error": "` + errormsg.ErrEmptyRequestPayload.Error() + `", "code": 400}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			strg := &chunkStorage{Storage: storage.NewMemStorage()}
+
+			h := NewHandlers(strg)
+
+			req := newChiHTTPRequest(http.MethodPost, "/updates", nil, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/x-ndjson")
+			req.Header.Set("X-Updates-Result", "json")
+
+			w := httptest.NewRecorder()
+
+			h.UpdateMetricsJSON(w, req)
+
+			resp := w.Result()
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+			assert.JSONEq(t, tc.response, string(body))
+
+			assert.Equal(t, tc.chunks, strg.chunks)
+
+			data, err := strg.GetAllMetrics(context.Background())
+			require.NoError(t, err)
+			assert.Len(t, data, tc.stored)
+		})
+	}
+}
+
+func TestUpdateMetricsJSONHandlerNDJSONOptions(t *testing.T) {
+	body := "{\"metric_id\": \"c1\", \"type\": \"counter\", \"delta\": 1}\n" +
+		"{\"metric_id\": \"c2\", \"type\": \"counter\", \"delta\": 2}\n" +
+		"{\"metric_id\": \"c3\", \"type\": \"counter\", \"delta\": 3}\n"
+
+	testCases := []struct {
+		name           string
+		maxBatchLength int
+		statusCode     int
+		stored         int
+	}{
+		{"WithinBatchLength", 3, http.StatusOK, 3},
+		{"BatchTooLarge", 2, http.StatusRequestEntityTooLarge, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			strg := storage.NewMemStorage()
+
+			h := NewHandlers(strg,
+				WithMaxBatchLength(tc.maxBatchLength),
+				WithFieldMapping(map[string]string{"metric_id": "id"}),
+			)
+
+			req := newChiHTTPRequest(http.MethodPost, "/updates", nil, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/x-ndjson")
+
+			w := httptest.NewRecorder()
+
+			h.UpdateMetricsJSON(w, req)
+
+			resp := w.Result()
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+
+			// The mapped metric names are stored.
+			data, err := strg.GetAllMetrics(context.Background())
+			require.NoError(t, err)
+			assert.Len(t, data, tc.stored)
+
+			if tc.stored > 0 {
+				assert.Contains(t, data, "c1")
+			}
+		})
+	}
+}
@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+	"github.com/andymarkow/go-metrics-collector/internal/storage"
+)
+
+var (
+	errLineProtocolSyntax    = errors.New("invalid line protocol syntax")
+	errLineProtocolFieldType = errors.New("invalid field value")
+
+	errLineProtocolInvalidMetric = errors.New("invalid metric")
+)
+
+// lineProtocolUnescaper unescapes the measurement, tag and field keys and
+// the string field values of the line protocol.
+var lineProtocolUnescaper = strings.NewReplacer(`\,`, ",", `\=`, "=", `\ `, " ", `\"`, `"`, `\\`, `\`)
+
+// lineProtocolField is a numeric field of the line protocol point.
+// The integer fields are parsed into intValue, the float ones into value.
+type lineProtocolField struct {
+	key      string
+	value    float64
+	intValue int64
+	integer  bool
+}
+
+// lineProtocolPoint is a single line of the InfluxDB line protocol:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// The string and boolean fields are skipped, as they have no metric type,
+// so the point of such fields only has no fields.
+type lineProtocolPoint struct {
+	measurement string
+	tags        map[string]string
+	fields      []lineProtocolField
+	timestamp   int64
+}
+
+// WriteLineProtocol handles the InfluxDB line protocol write request, e.g.
+// from Telegraf, and responds with 204 No Content like InfluxDB does.
+//
+// The float fields are stored as the gauges and the integer fields as the
+// counters named "<measurement>_<field>". The integer fields are expected to
+// be monotonic totals, so the counter is set to the reported total rather
+// than incremented by it. The tags and the timestamp are not stored.
+func (h *Handlers) WriteLineProtocol(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	points, err := parseLineProtocol(r.Body)
+	if err != nil {
+		h.handleError(w, err, http.StatusBadRequest)
+
+		return
+	}
+
+	if len(points) == 0 {
+		h.handleError(w, errormsg.ErrEmptyRequestPayload, http.StatusBadRequest)
+
+		return
+	}
+
+	metrics, counters, err := h.lineProtocolMetrics(points)
+	if err != nil {
+		switch {
+		case errors.Is(err, errormsg.ErrBatchTooLarge):
+			h.handleError(w, err, http.StatusRequestEntityTooLarge)
+		case errors.Is(err, errLineProtocolInvalidMetric):
+			h.handleError(w, err, http.StatusBadRequest)
+		default:
+			h.handleError(w, err, http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if len(metrics) > 0 {
+		if err := h.setLineProtocolMetrics(ctx, metrics, counters); err != nil {
+			h.handleError(w, err, storageErrorStatus(err))
+
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lineProtocolMetrics converts the line protocol points to the metrics batch.
+// It returns the indexes of the counters in the batch along with it, which
+// carry the reported totals instead of the deltas.
+//
+// The counter reported several times in the batch is updated with its last
+// total only.
+func (h *Handlers) lineProtocolMetrics(points []lineProtocolPoint) ([]models.Metrics, []int, error) {
+	metrics := make([]models.Metrics, 0, len(points))
+
+	// counters maps the counter name to its index in the batch.
+	counters := make(map[string]int)
+
+	for _, point := range points {
+		for _, field := range point.fields {
+			metric := models.Metrics{ID: point.measurement + "_" + field.key}
+
+			if field.integer {
+				total := field.intValue
+
+				metric.MType = "counter"
+				metric.Delta = &total
+
+				if idx, ok := counters[metric.ID]; ok {
+					metrics[idx] = metric
+
+					continue
+				}
+
+				counters[metric.ID] = len(metrics)
+			} else {
+				value := field.value
+
+				metric.MType = "gauge"
+				metric.Value = &value
+			}
+
+			if err := metric.ValidateUpdate(h.maxNameLength); err != nil {
+				return nil, nil, fmt.Errorf("%w (%s): %w", errLineProtocolInvalidMetric, metric.ID, err)
+			}
+
+			if h.maxBatchLength > 0 && len(metrics) >= h.maxBatchLength {
+				return nil, nil, fmt.Errorf("%w: more than %d metrics", errormsg.ErrBatchTooLarge, h.maxBatchLength)
+			}
+
+			metrics = append(metrics, metric)
+		}
+	}
+
+	indexes := make([]int, 0, len(counters))
+	for _, idx := range counters {
+		indexes = append(indexes, idx)
+	}
+
+	return metrics, indexes, nil
+}
+
+// setLineProtocolMetrics stores the metrics batch, setting the counters at
+// the indexes to the reported totals.
+//
+// The counter delta is the difference between the reported total and the
+// stored counter value. The counters are locked from reading their values
+// until the batch is stored, so that the concurrent writes of the same
+// counter do not add up the same difference twice.
+func (h *Handlers) setLineProtocolMetrics(ctx context.Context, metrics []models.Metrics, counters []int) error {
+	names := make([]string, 0, len(counters))
+	for _, idx := range counters {
+		names = append(names, metrics[idx].ID)
+	}
+
+	unlock := h.counterLocks.lock(names)
+	defer unlock()
+
+	for _, idx := range counters {
+		current, err := h.storage.GetCounter(ctx, metrics[idx].ID)
+		if err != nil && !errors.Is(err, storage.ErrMetricNotFound) {
+			return fmt.Errorf("storage.GetCounter: %w", err)
+		}
+
+		delta := *metrics[idx].Delta - current
+		metrics[idx].Delta = &delta
+	}
+
+	if err := h.storage.SetMetrics(ctx, metrics); err != nil {
+		return fmt.Errorf("storage.SetMetrics: %w", err)
+	}
+
+	return nil
+}
+
+// keyedLocks is a set of mutexes locked by name. The zero value is ready
+// to use. The mutex is removed once no one holds or waits for it.
+type keyedLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// keyedLock is a mutex with the number of its holders and waiters.
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock locks the mutexes of the names and returns the function unlocking
+// them. The names are locked in order, so that the batches of overlapping
+// names do not deadlock.
+func (l *keyedLocks) lock(names []string) func() {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	locks := make([]*keyedLock, 0, len(names))
+
+	l.mu.Lock()
+
+	if l.locks == nil {
+		l.locks = make(map[string]*keyedLock)
+	}
+
+	for _, name := range names {
+		lock, ok := l.locks[name]
+		if !ok {
+			lock = new(keyedLock)
+			l.locks[name] = lock
+		}
+
+		lock.refs++
+
+		locks = append(locks, lock)
+	}
+
+	l.mu.Unlock()
+
+	for _, lock := range locks {
+		lock.Lock()
+	}
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		for i, lock := range locks {
+			lock.Unlock()
+
+			if lock.refs--; lock.refs == 0 {
+				delete(l.locks, names[i])
+			}
+		}
+	}
+}
+
+// parseLineProtocol parses the InfluxDB line protocol body. The blank lines
+// and the comments starting with "#" are skipped.
+func parseLineProtocol(body io.Reader) ([]lineProtocolPoint, error) {
+	var points []lineProtocolPoint
+
+	scanner := bufio.NewScanner(body)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		point, err := parseLineProtocolPoint(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		points = append(points, point)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanner.Err: %w", err)
+	}
+
+	return points, nil
+}
+
+// parseLineProtocolPoint parses a single line of the line protocol.
+func parseLineProtocolPoint(line string) (lineProtocolPoint, error) {
+	var point lineProtocolPoint
+
+	sections := splitUnescaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return point, fmt.Errorf("%w: %q", errLineProtocolSyntax, line)
+	}
+
+	keys := splitUnescaped(sections[0], ',', false)
+
+	point.measurement = lineProtocolUnescaper.Replace(keys[0])
+	if point.measurement == "" {
+		return point, fmt.Errorf("%w: empty measurement", errLineProtocolSyntax)
+	}
+
+	if len(keys) > 1 {
+		point.tags = make(map[string]string, len(keys)-1)
+
+		for _, tag := range keys[1:] {
+			key, value, ok := cutUnescaped(tag, '=')
+			if !ok || key == "" || value == "" {
+				return point, fmt.Errorf("%w: invalid tag %q", errLineProtocolSyntax, tag)
+			}
+
+			point.tags[lineProtocolUnescaper.Replace(key)] = lineProtocolUnescaper.Replace(value)
+		}
+	}
+
+	for _, field := range splitUnescaped(sections[1], ',', true) {
+		key, value, ok := cutUnescaped(field, '=')
+		if !ok || key == "" || value == "" {
+			return point, fmt.Errorf("%w: invalid field %q", errLineProtocolSyntax, field)
+		}
+
+		parsed, numeric, err := parseLineProtocolFieldValue(value)
+		if err != nil {
+			return point, fmt.Errorf("field %q: %w", key, err)
+		}
+
+		if numeric {
+			parsed.key = lineProtocolUnescaper.Replace(key)
+			point.fields = append(point.fields, parsed)
+		}
+	}
+
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return point, fmt.Errorf("invalid timestamp: %w", err)
+		}
+
+		point.timestamp = ts
+	}
+
+	return point, nil
+}
+
+// parseLineProtocolFieldValue parses the field value. It reports whether the
+// value is numeric, so that the string and boolean fields can be skipped.
+func parseLineProtocolFieldValue(value string) (lineProtocolField, bool, error) {
+	var field lineProtocolField
+
+	switch value {
+	case "t", "T", "true", "True", "TRUE", "f", "F", "false", "False", "FALSE":
+		return field, false, nil
+	}
+
+	if strings.HasPrefix(value, `"`) {
+		if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+			return field, false, fmt.Errorf("%w: unterminated string %s", errLineProtocolFieldType, value)
+		}
+
+		return field, false, nil
+	}
+
+	switch value[len(value)-1] {
+	case 'i':
+		v, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		if err != nil {
+			return field, false, fmt.Errorf("%w: %w", errLineProtocolFieldType, err)
+		}
+
+		field.intValue = v
+		field.integer = true
+
+	case 'u':
+		v, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+		if err != nil {
+			return field, false, fmt.Errorf("%w: %w", errLineProtocolFieldType, err)
+		}
+
+		if v > math.MaxInt64 {
+			return field, false, fmt.Errorf("%w: %s overflows counter", errLineProtocolFieldType, value)
+		}
+
+		field.intValue = int64(v)
+		field.integer = true
+
+	default:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return field, false, fmt.Errorf("%w: %w", errLineProtocolFieldType, err)
+		}
+
+		field.value = v
+	}
+
+	return field, true, nil
+}
+
+// splitUnescaped splits s by the separator not escaped with a backslash and,
+// if quoted is set, not enclosed in double quotes.
+func splitUnescaped(s string, sep byte, quoted bool) []string {
+	var (
+		parts    []string
+		start    int
+		inQuotes bool
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			// Skip the escaped character.
+			i++
+		case quoted && c == '"':
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// cutUnescaped slices s around the first separator not escaped with
+// a backslash.
+func cutUnescaped(s string, sep byte) (before, after string, found bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+
+	return s, "", false
+}
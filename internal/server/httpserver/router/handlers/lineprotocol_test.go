@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andymarkow/go-metrics-collector/internal/storage"
+)
+
+func TestParseLineProtocol(t *testing.T) {
+	body := strings.Join([]string{
+		"# Telegraf output",
+		`cpu,host=server\ 01,region=eu usage_idle=98.5,usage_user=1.5 1700000000000000000`,
+		"",
+		`net,interface=eth0 bytes_recv=1024i,packets_recv=8u,up=true,name="eth 0"`,
+		`weather\,station temp=-1.5e1`,
+		`uptime format="1 day"`,
+	}, "\n")
+
+	points, err := parseLineProtocol(strings.NewReader(body))
+	require.NoError(t, err)
+	require.Len(t, points, 4)
+
+	assert.Equal(t, "cpu", points[0].measurement)
+	assert.Equal(t, map[string]string{"host": "server 01", "region": "eu"}, points[0].tags)
+	assert.Equal(t, []lineProtocolField{
+		{key: "usage_idle", value: 98.5},
+		{key: "usage_user", value: 1.5},
+	}, points[0].fields)
+	assert.Equal(t, int64(1700000000000000000), points[0].timestamp)
+
+	// The string and boolean fields are skipped.
+	assert.Equal(t, []lineProtocolField{
+		{key: "bytes_recv", intValue: 1024, integer: true},
+		{key: "packets_recv", intValue: 8, integer: true},
+	}, points[1].fields)
+
+	assert.Equal(t, "weather,station", points[2].measurement)
+	assert.Equal(t, []lineProtocolField{{key: "temp", value: -15}}, points[2].fields)
+
+	assert.Empty(t, points[3].fields)
+}
+
+func TestParseLineProtocolInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "NoFields", line: "cpu"},
+		{name: "EmptyMeasurement", line: ",host=a value=1"},
+		{name: "InvalidTag", line: "cpu,host value=1"},
+		{name: "InvalidField", line: "cpu value"},
+		{name: "InvalidFloat", line: "cpu value=abc"},
+		{name: "InvalidInteger", line: "cpu value=1.5i"},
+		{name: "UnsignedOverflow", line: "cpu value=18446744073709551615u"},
+		{name: "UnterminatedString", line: `cpu value="abc`},
+		{name: "InvalidTimestamp", line: "cpu value=1 now"},
+		{name: "ExtraSection", line: "cpu value=1 1 2"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseLineProtocol(strings.NewReader("cpu value=1\n" + tc.line))
+			require.Error(t, err)
+
+			assert.Contains(t, err.Error(), "line 2")
+		})
+	}
+}
+
+func TestWriteLineProtocolHandler(t *testing.T) {
+	ctx := context.Background()
+
+	strg := storage.NewMemStorage()
+	require.NoError(t, strg.SetCounter(ctx, "net_bytes_recv", 1000))
+
+	h := NewHandlers(strg)
+
+	doRequest := func(body string) (*http.Response, string) {
+		req := httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		h.WriteLineProtocol(w, req)
+
+		resp := w.Result()
+
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return resp, string(respBody)
+	}
+
+	resp, _ := doRequest(strings.Join([]string{
+		"cpu,host=a usage_idle=98.5",
+		"net,interface=eth0 bytes_recv=1024i,packets_recv=8i 1",
+		"net,interface=eth0 bytes_recv=2048i 2",
+	}, "\n"))
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	gauge, err := strg.GetGauge(ctx, "cpu_usage_idle")
+	require.NoError(t, err)
+	assert.InDelta(t, 98.5, gauge, 0)
+
+	// The counters are set to the last reported totals.
+	cnt, err := strg.GetCounter(ctx, "net_bytes_recv")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2048), cnt)
+
+	cnt, err = strg.GetCounter(ctx, "net_packets_recv")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), cnt)
+
+	resp, _ = doRequest("net bytes_recv=4096i")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	cnt, err = strg.GetCounter(ctx, "net_bytes_recv")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4096), cnt)
+
+	resp, body := doRequest("cpu usage_idle=abc")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Contains(t, body, "line 1")
+
+	resp, _ = doRequest("\n")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	h = NewHandlers(strg, WithMaxBatchLength(1))
+
+	resp, _ = doRequest("cpu usage_idle=1,usage_user=2")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+// slowCounterStorage delays the counter reads to widen the window between
+// reading the counter and storing the new delta.
+type slowCounterStorage struct {
+	storage.Storage
+}
+
+func (s *slowCounterStorage) GetCounter(ctx context.Context, name string) (int64, error) {
+	time.Sleep(10 * time.Millisecond)
+
+	return s.Storage.GetCounter(ctx, name)
+}
+
+func TestWriteLineProtocolHandlerConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	strg := storage.NewMemStorage()
+
+	h := NewHandlers(&slowCounterStorage{Storage: strg})
+
+	// The batches report the same counters in the different order.
+	bodies := []string{
+		"net bytes_recv=1000i,bytes_sent=500i",
+		"net bytes_sent=500i,bytes_recv=1000i",
+	}
+
+	var wg sync.WaitGroup
+
+	for i := range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(bodies[i%len(bodies)]))
+			w := httptest.NewRecorder()
+
+			h.WriteLineProtocol(w, req)
+
+			assert.Equal(t, http.StatusNoContent, w.Code)
+		}()
+	}
+
+	wg.Wait()
+
+	// The counters are set to the reported totals once.
+	cnt, err := strg.GetCounter(ctx, "net_bytes_recv")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), cnt)
+
+	cnt, err = strg.GetCounter(ctx, "net_bytes_sent")
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), cnt)
+
+	// The locks are released once the writes are done.
+	assert.Empty(t, h.counterLocks.locks)
+}
@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"net"
+	"net/http"
+)
+
+// clientIP returns the client IP address of the request.
+//
+// The address is taken from the connection remote address. The "X-Real-IP"
+// header is honored only if the request comes from one of the trusted
+// proxies, since any other client is free to set it. Returns nil if the
+// address cannot be parsed.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(ip) {
+			// The proxy not setting the header is treated as the client itself.
+			if realIP := net.ParseIP(r.Header.Get("X-Real-IP")); realIP != nil {
+				return realIP
+			}
+
+			break
+		}
+	}
+
+	return ip
+}
+
+// clientAddr returns the client IP address of the request as a string,
+// falling back to the connection remote address if it cannot be parsed.
+func (m *Middlewares) clientAddr(r *http.Request) string {
+	if ip := clientIP(r, m.trustedProxies); ip != nil {
+		return ip.String()
+	}
+
+	return r.RemoteAddr
+}
@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxy, err := net.ParseCIDR("192.0.2.0/24")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		proxies    []*net.IPNet
+		want       net.IP
+	}{
+		{"RemoteAddr", "10.0.0.1:1234", "", nil, net.ParseIP("10.0.0.1")},
+		{"UntrustedRealIP", "10.0.0.1:1234", "10.0.0.2", []*net.IPNet{proxy}, net.ParseIP("10.0.0.1")},
+		{"TrustedProxyRealIP", "192.0.2.1:1234", "10.0.0.2", []*net.IPNet{proxy}, net.ParseIP("10.0.0.2")},
+		{"TrustedProxyMissingRealIP", "192.0.2.1:1234", "", []*net.IPNet{proxy}, net.ParseIP("192.0.2.1")},
+		{"TrustedProxyInvalidRealIP", "192.0.2.1:1234", "invalid", []*net.IPNet{proxy}, net.ParseIP("192.0.2.1")},
+		{"RemoteAddrWithoutPort", "10.0.0.1", "", nil, net.ParseIP("10.0.0.1")},
+		{"InvalidRemoteAddr", "invalid", "", nil, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+
+			if tc.realIP != "" {
+				req.Header.Set("X-Real-IP", tc.realIP)
+			}
+
+			assert.Equal(t, tc.want, clientIP(req, tc.proxies))
+		})
+	}
+}
@@ -3,23 +3,53 @@ package middlewares
 
 import (
 	"compress/gzip"
+	"errors"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+)
+
+// Supported content encodings.
+const (
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
 )
 
+// DefaultMaxDecompressedBytes is the default maximum size of the decompressed
+// request body.
+const DefaultMaxDecompressedBytes = 10 << 20
+
 // compressWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера.
 // сжимать передаваемые данные и выставлять правильные HTTP-заголовки.
 type compressWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+	w        http.ResponseWriter
+	zw       io.WriteCloser
+	encoding string
 }
 
-func newCompressWriter(w http.ResponseWriter) *compressWriter {
-	return &compressWriter{
-		w:  w,
-		zw: gzip.NewWriter(w),
+// newCompressWriter creates a compressWriter with the gzip or zstd encoding.
+func newCompressWriter(w http.ResponseWriter, encoding string) (*compressWriter, error) {
+	var zw io.WriteCloser = gzip.NewWriter(w)
+
+	if encoding == encodingZstd {
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+
+		zw = enc
 	}
+
+	return &compressWriter{
+		w:        w,
+		zw:       zw,
+		encoding: encoding,
+	}, nil
 }
 
 func (c *compressWriter) Header() http.Header {
@@ -32,7 +62,7 @@ func (c *compressWriter) Write(p []byte) (int, error) {
 
 func (c *compressWriter) WriteHeader(statusCode int) {
 	if statusCode < 300 {
-		c.w.Header().Set("Content-Encoding", "gzip")
+		c.w.Header().Set("Content-Encoding", c.encoding)
 	}
 	c.w.WriteHeader(statusCode)
 }
@@ -44,25 +74,73 @@ func (c *compressWriter) Close() error {
 
 // compressReader реализует интерфейс io.ReadCloser и позволяет прозрачно для сервера.
 // декомпрессировать получаемые от клиента данные.
+//
+// The decompressed data is limited to limit bytes, so that a small crafted
+// payload does not exhaust the memory. The read fails with the
+// errormsg.ErrRequestBodyTooLarge error once the limit is exceeded.
 type compressReader struct {
-	r  io.ReadCloser
-	zr *gzip.Reader
+	r     io.ReadCloser
+	zr    io.ReadCloser
+	lr    io.Reader
+	limit int64
+	read  int64
 }
 
-func newCompressReader(r io.ReadCloser) (*compressReader, error) {
-	zr, err := gzip.NewReader(r)
-	if err != nil {
-		return nil, err
+// newCompressReader creates a compressReader decompressing the gzip or zstd
+// encoded data.
+func newCompressReader(r io.ReadCloser, encoding string, limit int64) (*compressReader, error) {
+	var zr io.ReadCloser
+
+	switch encoding {
+	case encodingZstd:
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+
+		// The decoder does not allocate the window larger than the limit.
+		if limit > 0 {
+			opts = append(opts, zstd.WithDecoderMaxMemory(uint64(limit)))
+		}
+
+		dec, err := zstd.NewReader(r, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		zr = dec.IOReadCloser()
+
+	default:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		zr = gr
 	}
 
-	return &compressReader{
-		r:  r,
-		zr: zr,
-	}, nil
+	cr := &compressReader{
+		r:     r,
+		zr:    zr,
+		lr:    zr,
+		limit: limit,
+	}
+
+	// One byte over the limit is read to tell the exceeded limit from EOF.
+	if limit > 0 {
+		cr.lr = io.LimitReader(zr, limit+1)
+	}
+
+	return cr, nil
 }
 
-func (c compressReader) Read(p []byte) (int, error) {
-	return c.zr.Read(p)
+func (c *compressReader) Read(p []byte) (int, error) {
+	n, err := c.lr.Read(p)
+
+	c.read += int64(n)
+
+	if c.limit > 0 && c.read > c.limit {
+		return n - int(c.read-c.limit), errormsg.ErrRequestBodyTooLarge
+	}
+
+	return n, err
 }
 
 func (c *compressReader) Close() error {
@@ -89,19 +167,64 @@ func isCompressContentType(contentType string) bool {
 	return false
 }
 
-// Compress is a router middleware that handles gzip requests and responses.
+// negotiateEncoding returns the response content encoding preferred by the
+// client in the Accept-Encoding header: gzip or zstd. The encoding with the
+// higher quality value is preferred, the first one listed on a tie. It returns
+// an empty string if neither is accepted.
+func negotiateEncoding(acceptEncoding string) string {
+	var (
+		encoding string
+		quality  float64
+	)
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encodingGzip && name != encodingZstd {
+			continue
+		}
+
+		q := 1.0
+
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+
+			q = parsed
+		}
+
+		if q > quality {
+			encoding, quality = name, q
+		}
+	}
+
+	return encoding
+}
+
+// Compress is a router middleware that handles gzip and zstd requests and responses.
+//
+// The response is compressed with the encoding preferred by the client,
+// gzip or zstd.
 func (m *Middlewares) Compress(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// по умолчанию устанавливаем оригинальный http.ResponseWriter как тот,
 		// который будем передавать следующей функции
 		ow := w
 
-		// // проверяем, что клиент умеет получать от сервера сжатые данные в формате gzip
-		acceptEncoding := r.Header.Get("Accept-Encoding")
+		// // проверяем, что клиент умеет получать от сервера сжатые данные в формате gzip или zstd
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
 
-		if strings.Contains(acceptEncoding, "gzip") && isCompressContentType(r.Header.Get("Content-Type")) {
+		if encoding != "" && isCompressContentType(r.Header.Get("Content-Type")) {
 			// оборачиваем оригинальный http.ResponseWriter новым с поддержкой сжатия
-			cw := newCompressWriter(w)
+			cw, err := newCompressWriter(w, encoding)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
 			// меняем оригинальный http.ResponseWriter на новый
 			ow = cw
 			// не забываем отправить клиенту все сжатые данные после завершения middleware
@@ -112,12 +235,21 @@ func (m *Middlewares) Compress(next http.Handler) http.Handler {
 			}()
 		}
 
-		// проверяем, что клиент отправил серверу сжатые данные в формате gzip
+		// проверяем, что клиент отправил серверу сжатые данные в формате gzip или zstd
 		contentEncoding := r.Header.Get("Content-Encoding")
 
-		if strings.Contains(contentEncoding, "gzip") {
+		var requestEncoding string
+
+		switch {
+		case strings.Contains(contentEncoding, encodingGzip):
+			requestEncoding = encodingGzip
+		case strings.Contains(contentEncoding, encodingZstd):
+			requestEncoding = encodingZstd
+		}
+
+		if requestEncoding != "" {
 			// оборачиваем тело запроса в io.Reader с поддержкой декомпрессии
-			cr, err := newCompressReader(r.Body)
+			cr, err := newCompressReader(r.Body, requestEncoding, m.maxDecompressedBytes)
 			if err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 
@@ -137,3 +269,12 @@ func (m *Middlewares) Compress(next http.Handler) http.Handler {
 		next.ServeHTTP(ow, r)
 	})
 }
+
+// readBodyErrorStatus returns the HTTP status code for the request body read error.
+func readBodyErrorStatus(err error) int {
+	if errors.Is(err, errormsg.ErrRequestBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,137 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+)
+
+// gzipBody returns the gzip compressed data as the request body.
+func gzipBody(t *testing.T, data string) io.ReadCloser {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	return io.NopCloser(&buf)
+}
+
+func TestCompressReaderLimit(t *testing.T) {
+	testCases := []struct {
+		wantErr error
+		name    string
+		size    int
+		limit   int64
+	}{
+		{name: "UnderLimit", size: 1023, limit: 1024},
+		{name: "AtLimit", size: 1024, limit: 1024},
+		{name: "OverLimit", size: 1025, limit: 1024, wantErr: errormsg.ErrRequestBodyTooLarge},
+		{name: "Unlimited", size: 1 << 20, limit: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cr, err := newCompressReader(gzipBody(t, strings.Repeat(" ", tc.size)), encodingGzip, tc.limit)
+			require.NoError(t, err)
+
+			data, err := io.ReadAll(cr)
+			require.ErrorIs(t, err, tc.wantErr)
+			require.NoError(t, cr.Close())
+
+			if tc.wantErr != nil {
+				assert.Len(t, data, int(tc.limit))
+
+				return
+			}
+
+			assert.Len(t, data, tc.size)
+		})
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"br, deflate", ""},
+		{"gzip", "gzip"},
+		{"zstd", "zstd"},
+		{"gzip, zstd", "gzip"},
+		{"zstd, gzip", "zstd"},
+		{"gzip;q=0.5, zstd", "zstd"},
+		{"zstd;q=0.8, gzip;q=0.9", "gzip"},
+		{"zstd;q=0, gzip;q=0", ""},
+		{"ZSTD, deflate", "zstd"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tc.want, negotiateEncoding(tc.acceptEncoding))
+		})
+	}
+}
+
+func TestCompressZstd(t *testing.T) {
+	mw := New(WithLogger(zap.NewNop()))
+
+	handler := mw.Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		_, err = w.Write(body)
+		require.NoError(t, err)
+	}))
+
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+
+	payload := `[{"id": "test", "type": "gauge", "value": 1}]`
+
+	req := httptest.NewRequest(http.MethodPost, "/updates",
+		bytes.NewReader(enc.EncodeAll([]byte(payload), nil)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "zstd")
+	req.Header.Set("Accept-Encoding", "zstd, gzip")
+
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "zstd", resp.Header.Get("Content-Encoding"))
+
+	dec, err := zstd.NewReader(resp.Body)
+	require.NoError(t, err)
+	defer dec.Close()
+
+	body, err := io.ReadAll(dec)
+	require.NoError(t, err)
+
+	assert.Equal(t, payload, string(body))
+}
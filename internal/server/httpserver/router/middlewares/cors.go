@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"net/http"
+	"slices"
+)
+
+// Cors is a router middleware that allows the cross-origin requests from
+// the allowed origins, e.g. the browser-based dashboards.
+//
+// The "*" allowed origin allows the requests from any origin. The preflight
+// OPTIONS requests from the allowed origins are answered with a 204 status
+// code. If no allowed origins are set, the middleware does nothing.
+func (m *Middlewares) Cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(m.allowedOrigins) == 0 {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		// The response depends on the origin, so the caches must not share it.
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !m.isAllowedOrigin(origin) {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Accept-Encoding, Content-Encoding, Content-Type, HashSHA256")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Middlewares) isAllowedOrigin(origin string) bool {
+	return slices.Contains(m.allowedOrigins, "*") || slices.Contains(m.allowedOrigins, origin)
+}
@@ -18,7 +18,7 @@ func (m *Middlewares) Cryptography(next http.Handler) http.Handler {
 		body, err := io.ReadAll(r.Body)
 		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
 			m.log.Error("read body", zap.Error(err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), readBodyErrorStatus(err))
 
 			return
 		}
@@ -1,12 +1,30 @@
 package middlewares
 
 import (
+	"expvar"
 	"net/http"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// responsesByClass is the number of responses by the status code class,
+// e.g. "2xx", exposed via the expvar stats endpoint.
+var responsesByClass = expvar.NewMap("http_responses")
+
+// statusClasses are the status code classes indexed by the first digit.
+var statusClasses = [...]string{"", "1xx", "2xx", "3xx", "4xx", "5xx"}
+
+// statusClass returns the class of the status code or an empty string
+// if the code is out of the range.
+func statusClass(status int) string {
+	if status < 100 || status >= 600 {
+		return ""
+	}
+
+	return statusClasses[status/100]
+}
+
 type responseData struct {
 	status int
 	size   int
@@ -47,12 +65,17 @@ func (m *Middlewares) Logger(next http.Handler) http.Handler {
 		}
 
 		defer func() {
+			if class := statusClass(responseData.status); class != "" {
+				responsesByClass.Add(class, 1)
+			}
+
 			m.log.Info("request",
+				zap.String("request_id", RequestIDFromContext(r.Context())),
 				zap.String("uri", r.RequestURI),
 				zap.String("method", r.Method),
 				zap.Int("status", responseData.status),
 				zap.Int("size", responseData.size),
-				zap.Duration("duration_ms", time.Since(startTime)*1000),
+				zap.Int64("duration_ms", time.Since(startTime).Milliseconds()),
 			)
 		}()
 
@@ -0,0 +1,80 @@
+package middlewares
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLoggerDuration(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	mw := New(WithLogger(zap.New(core)))
+
+	handler := mw.Logger(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	entries := logs.FilterMessage("request").All()
+	require.Len(t, entries, 1)
+
+	duration, ok := entries[0].ContextMap()["duration_ms"].(int64)
+	require.True(t, ok)
+
+	assert.GreaterOrEqual(t, duration, int64(50))
+	assert.Less(t, duration, int64(1000))
+}
+
+func TestLoggerResponsesByClass(t *testing.T) {
+	mw := New()
+
+	classValue := func(class string) int64 {
+		if v, ok := responsesByClass.Get(class).(*expvar.Int); ok {
+			return v.Value()
+		}
+
+		return 0
+	}
+
+	testCases := []struct {
+		name   string
+		status int
+		class  string
+	}{
+		{"OK", http.StatusOK, "2xx"},
+		{"NotFound", http.StatusNotFound, "4xx"},
+		{"InternalServerError", http.StatusInternalServerError, "5xx"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := mw.Logger(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(tc.status)
+			}))
+
+			before := classValue(tc.class)
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, before+1, classValue(tc.class))
+		})
+	}
+
+	assert.Empty(t, statusClass(99))
+	assert.Empty(t, statusClass(600))
+}
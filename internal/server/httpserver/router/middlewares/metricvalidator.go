@@ -6,10 +6,12 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+	"github.com/andymarkow/go-metrics-collector/internal/models"
 	"github.com/andymarkow/go-metrics-collector/internal/monitor"
 )
 
 // MetricValidator is a router middleware that validates metric name and type.
+// It rejects the metric names and values exceeding the maximum length.
 func (m *Middlewares) MetricValidator(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		metricType := chi.URLParam(r, "metricType")
@@ -29,6 +31,18 @@ func (m *Middlewares) MetricValidator(next http.Handler) http.Handler {
 			return
 		}
 
+		if err := models.ValidateName(metricName, m.maxNameLength); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if len(chi.URLParam(r, "metricValue")) > models.MaxValueLength {
+			http.Error(w, errormsg.ErrMetricValueTooLong.Error(), http.StatusBadRequest)
+
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -2,23 +2,41 @@
 package middlewares
 
 import (
+	"crypto/ed25519"
 	"crypto/rsa"
+	"net"
 
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
 )
 
 // Middlewares is a collection of router middlewares.
 type Middlewares struct {
-	log           *zap.Logger
-	cryptoPrivKey *rsa.PrivateKey
-	signKey       []byte
+	log            *zap.Logger
+	cryptoPrivKey  *rsa.PrivateKey
+	trustedSubnet  *net.IPNet
+	trustedProxies []*net.IPNet
+	signKey        []byte
+	signPubKey     ed25519.PublicKey
+	allowedOrigins []string
+	rateLimiter    *ipRateLimiter
+	tracer         trace.Tracer
+
+	maxDecompressedBytes int64
+	maxNameLength        int
 }
 
 // New creates new Middlewares instance.
 func New(opts ...Option) *Middlewares {
 	// Default Middleware options.
 	mw := &Middlewares{
-		log: zap.Must(zap.NewDevelopment()),
+		log:                  zap.Must(zap.NewDevelopment()),
+		tracer:               noop.NewTracerProvider().Tracer(tracerName),
+		maxDecompressedBytes: DefaultMaxDecompressedBytes,
+		maxNameLength:        models.DefaultMaxNameLength,
 	}
 
 	// Apply options
@@ -46,8 +64,82 @@ func WithSignKey(signKey []byte) Option {
 	}
 }
 
+// WithSignPublicKey is a router middleware option that sets the ed25519
+// public key the request signature is verified with.
+func WithSignPublicKey(key ed25519.PublicKey) Option {
+	return func(m *Middlewares) {
+		m.signPubKey = key
+	}
+}
+
 func WithCryptoPrivateKey(key *rsa.PrivateKey) Option {
 	return func(m *Middlewares) {
 		m.cryptoPrivKey = key
 	}
 }
+
+// WithTrustedSubnet is a router middleware option that sets the subnet
+// the clients are trusted from.
+func WithTrustedSubnet(subnet *net.IPNet) Option {
+	return func(m *Middlewares) {
+		m.trustedSubnet = subnet
+	}
+}
+
+// WithTrustedProxies is a router middleware option that sets the subnets of
+// the reverse proxies the "X-Real-IP" header is honored from.
+func WithTrustedProxies(proxies []*net.IPNet) Option {
+	return func(m *Middlewares) {
+		m.trustedProxies = proxies
+	}
+}
+
+// WithAllowedOrigins is a router middleware option that sets the origins
+// the cross-origin requests are allowed from.
+func WithAllowedOrigins(origins []string) Option {
+	return func(m *Middlewares) {
+		m.allowedOrigins = origins
+	}
+}
+
+// WithRateLimit is a router middleware option that limits the rate of the
+// requests per client IP address to limit requests per second with the
+// burst of requests. The rate is not limited if the limit is not positive.
+func WithRateLimit(limit float64, burst int) Option {
+	return func(m *Middlewares) {
+		if limit <= 0 {
+			m.rateLimiter = nil
+
+			return
+		}
+
+		m.rateLimiter = newIPRateLimiter(limit, max(burst, 1))
+	}
+}
+
+// WithMaxDecompressedBytes is a router middleware option that sets the maximum
+// size of the decompressed request body. The size is not limited if n is not
+// positive.
+func WithMaxDecompressedBytes(n int64) Option {
+	return func(m *Middlewares) {
+		m.maxDecompressedBytes = n
+	}
+}
+
+// WithMaxNameLength is a router middleware option that limits the length of
+// the metric names in bytes. The default length is used if n is not positive.
+func WithMaxNameLength(n int) Option {
+	return func(m *Middlewares) {
+		if n > 0 {
+			m.maxNameLength = n
+		}
+	}
+}
+
+// WithTracerProvider is a router middleware option that sets the tracer
+// provider the request spans are created with.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(m *Middlewares) {
+		m.tracer = tp.Tracer(tracerName)
+	}
+}
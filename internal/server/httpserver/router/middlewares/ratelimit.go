@@ -0,0 +1,118 @@
+package middlewares
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+)
+
+// Idle clients limiters eviction parameters.
+const (
+	// limiterIdleTimeout is the time the client limiter is kept since
+	// the last request.
+	limiterIdleTimeout = 10 * time.Minute
+	// limiterEvictInterval is the interval the idle limiters are evicted at.
+	limiterEvictInterval = time.Minute
+)
+
+// clientLimiter is a rate limiter of a single client.
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter holds the rate limiters of the clients keyed by IP address.
+type ipRateLimiter struct {
+	clients   map[string]*clientLimiter
+	lastEvict time.Time
+	limit     rate.Limit
+	burst     int
+	mu        sync.Mutex
+}
+
+func newIPRateLimiter(limit float64, burst int) *ipRateLimiter {
+	return &ipRateLimiter{
+		clients:   make(map[string]*clientLimiter),
+		lastEvict: time.Now(),
+		limit:     rate.Limit(limit),
+		burst:     burst,
+	}
+}
+
+// reserve takes a token of the client and returns the time to wait before
+// the next request is allowed, which is zero if the request is allowed now.
+func (l *ipRateLimiter) reserve(ip string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The idle limiters are evicted lazily to not grow the map unbounded.
+	if now.Sub(l.lastEvict) >= limiterEvictInterval {
+		for key, client := range l.clients {
+			if now.Sub(client.lastSeen) >= limiterIdleTimeout {
+				delete(l.clients, key)
+			}
+		}
+
+		l.lastEvict = now
+	}
+
+	client, ok := l.clients[ip]
+	if !ok {
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+
+	client.lastSeen = now
+
+	// The reservation is always possible since the burst is at least 1.
+	reservation := client.limiter.ReserveN(now, 1)
+
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		// The rejected request does not consume the token.
+		reservation.CancelAt(now)
+	}
+
+	return delay
+}
+
+// RateLimit is a router middleware that limits the rate of the requests
+// from every client IP address.
+//
+// The client IP address is taken from the connection remote address, or from
+// the "X-Real-IP" header if the request comes from a trusted proxy.
+//
+// The requests exceeding the rate are rejected with a 429 status code and
+// the "Retry-After" header set to the number of seconds to wait. If no rate
+// limit is set, all the requests are allowed.
+func (m *Middlewares) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.rateLimiter == nil {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		ip := m.clientAddr(r)
+
+		if delay := m.rateLimiter.reserve(ip, time.Now()); delay > 0 {
+			m.log.Warn("request rate limit exceeded", zap.String("ip", ip))
+
+			retryAfter := int64(math.Ceil(delay.Seconds()))
+
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+			http.Error(w, errormsg.ErrRateLimitExceeded.Error(), http.StatusTooManyRequests)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
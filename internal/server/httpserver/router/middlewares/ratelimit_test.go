@@ -0,0 +1,86 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	mw := New(WithRateLimit(1, 2))
+
+	handler := mw.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	doRequest := func(ip string) *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+		req.RemoteAddr = ip + ":1234"
+
+		w := httptest.NewRecorder()
+
+		handler.ServeHTTP(w, req)
+
+		return w.Result()
+	}
+
+	for range 2 {
+		resp := doRequest("10.0.0.1")
+		require.NoError(t, resp.Body.Close())
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	resp := doRequest("10.0.0.1")
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	// The "X-Real-IP" header of an untrusted client does not bypass the limit.
+	req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Real-IP", "10.0.0.3")
+
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// Another client has its own limit.
+	resp = doRequest("10.0.0.2")
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	mw := New(WithRateLimit(0, 1))
+
+	assert.Nil(t, mw.rateLimiter)
+}
+
+func TestIPRateLimiterEviction(t *testing.T) {
+	limiter := newIPRateLimiter(1, 1)
+
+	now := time.Now()
+
+	assert.Zero(t, limiter.reserve("10.0.0.1", now))
+	assert.Positive(t, limiter.reserve("10.0.0.1", now))
+
+	now = now.Add(limiterEvictInterval)
+
+	assert.Zero(t, limiter.reserve("10.0.0.2", now))
+	assert.Len(t, limiter.clients, 2)
+
+	now = now.Add(limiterIdleTimeout)
+
+	assert.Zero(t, limiter.reserve("10.0.0.2", now))
+	assert.Len(t, limiter.clients, 1)
+	assert.NotContains(t, limiter.clients, "10.0.0.1")
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// requestIDHeader is the header the request ID is passed in.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the length of the incoming request ID.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID is a router middleware that assigns an ID to the request to
+// correlate the agent and server logs.
+//
+// The ID is taken from the "X-Request-ID" header or generated as a UUID
+// if the header is missing or invalid. It is stored in the request context
+// and echoed back in the response header.
+func (m *Middlewares) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		w.Header().Set(requestIDHeader, requestID)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIDFromContext returns the request ID assigned by the RequestID
+// middleware or an empty string if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+
+	return requestID
+}
+
+// isValidRequestID reports whether the incoming request ID is safe to be
+// logged and echoed back: not empty, limited in length and printable ASCII.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for i := range len(requestID) {
+		if requestID[i] < 0x21 || requestID[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
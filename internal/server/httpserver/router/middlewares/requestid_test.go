@@ -0,0 +1,69 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestID(t *testing.T) {
+	testCases := []struct {
+		name      string
+		requestID string
+		generated bool
+	}{
+		{"Incoming", "agent-42", false},
+		{"Missing", "", true},
+		{"TooLong", strings.Repeat("a", maxRequestIDLength+1), true},
+		{"NotPrintable", "id\nforged log line", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+
+			mw := New(WithLogger(zap.New(core)))
+
+			var handlerID string
+
+			handler := mw.RequestID(mw.Logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerID = RequestIDFromContext(r.Context())
+
+				w.WriteHeader(http.StatusOK)
+			})))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Request-ID", tc.requestID)
+
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			require.NoError(t, resp.Body.Close())
+
+			respID := resp.Header.Get("X-Request-ID")
+
+			if tc.generated {
+				_, err := uuid.Parse(respID)
+				require.NoError(t, err)
+			} else {
+				assert.Equal(t, tc.requestID, respID)
+			}
+
+			assert.Equal(t, respID, handlerID)
+
+			entries := logs.FilterMessage("request").All()
+			require.Len(t, entries, 1)
+			assert.Equal(t, respID, entries[0].ContextMap()["request_id"])
+		})
+	}
+}
@@ -25,7 +25,7 @@ func (m *Middlewares) HashSumValidator(next http.Handler) http.Handler {
 		body, err := io.ReadAll(r.Body)
 		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
 			m.log.Error("read body", zap.Error(err))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), readBodyErrorStatus(err))
 
 			return
 		}
@@ -66,3 +66,42 @@ func (m *Middlewares) HashSumValidator(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Ed25519SignatureValidator is a router middleware that validates the ed25519
+// signature of the request body.
+//
+// The middleware expects the hex-encoded signature to be passed in the
+// "X-Signature-Ed25519" header. The signature is verified with the sign
+// public key, so the agent does not share a secret key with the server.
+//
+// If the signature is invalid or the header is missing, the middleware returns a 400 status code.
+func (m *Middlewares) Ed25519SignatureValidator(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			m.log.Error("read body", zap.Error(err))
+			http.Error(w, err.Error(), readBodyErrorStatus(err))
+
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		sign, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+		if err != nil {
+			m.log.Error("decode signature", zap.Error(err))
+			http.Error(w, errormsg.ErrSignatureInvalid.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		if !signature.VerifyEd25519(m.signPubKey, body, sign) {
+			m.log.Error("signature mismatch", zap.Error(errormsg.ErrSignatureInvalid))
+			http.Error(w, errormsg.ErrSignatureInvalid.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/andymarkow/go-metrics-collector/internal/signature"
+)
+
+func TestEd25519SignatureValidator(t *testing.T) {
+	signPubKey, signPrivKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	_, otherPrivKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	payload := []byte(`[{"id":"PollCount","type":"counter","delta":3}]`)
+
+	mw := New(WithLogger(zap.NewNop()), WithSignPublicKey(signPubKey))
+
+	handler := mw.Ed25519SignatureValidator(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The validated body is passed on to the next handler.
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, payload, body)
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	testCases := []struct {
+		name       string
+		sign       string
+		statusCode int
+	}{
+		{"ValidSignature", hex.EncodeToString(signature.SignEd25519(signPrivKey, payload)), http.StatusOK},
+		{"OtherKeySignature", hex.EncodeToString(signature.SignEd25519(otherPrivKey, payload)), http.StatusBadRequest},
+		{"MissingSignature", "", http.StatusBadRequest},
+		{"MalformedSignature", "not hex", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(payload))
+			req.Header.Set("X-Signature-Ed25519", tc.sign)
+
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.statusCode, w.Code)
+		})
+	}
+}
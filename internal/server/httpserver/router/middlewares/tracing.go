@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the HTTP server tracer.
+const tracerName = "github.com/andymarkow/go-metrics-collector/internal/server/httpserver"
+
+// tracePropagator extracts the remote parent span from the W3C Trace Context
+// and Baggage request headers.
+var tracePropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+// Tracing is a router middleware that starts a server span per request.
+//
+// The span is a child of the remote span passed in the request headers, if
+// any, and is stored in the request context, so the handlers and the storage
+// calls made with it create the child spans. The span is named after the
+// matched route pattern, e.g. "POST /updates".
+func (m *Middlewares) Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := m.tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", m.clientAddr(r)),
+				attribute.String("request_id", RequestIDFromContext(ctx)),
+			),
+		)
+		defer span.End()
+
+		responseData := &responseData{
+			status: http.StatusOK,
+		}
+
+		next.ServeHTTP(&loggerResponseWriter{ResponseWriter: w, responseData: responseData}, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(ctx); rctx != nil {
+			if pattern := rctx.RoutePattern(); pattern != "" {
+				span.SetName(r.Method + " " + pattern)
+				span.SetAttributes(attribute.String("http.route", pattern))
+			}
+		}
+
+		span.SetAttributes(attribute.Int("http.response.status_code", responseData.status))
+
+		if responseData.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(responseData.status))
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+)
+
+// TrustedSubnet is a router middleware that allows only the requests from
+// the trusted subnet.
+//
+// The client IP address is taken from the connection remote address, or from
+// the "X-Real-IP" header if the request comes from a trusted proxy. If the
+// address is not within the trusted subnet, the middleware returns a 403
+// status code. If no trusted subnet is set, all the requests are denied.
+func (m *Middlewares) TrustedSubnet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.trustedSubnet == nil {
+			m.log.Error("request denied: no trusted subnet is set",
+				zap.String("remote_addr", r.RemoteAddr),
+			)
+			http.Error(w, errormsg.ErrUntrustedIPAddress.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		ip := clientIP(r, m.trustedProxies)
+		if ip == nil || !m.trustedSubnet.Contains(ip) {
+			m.log.Error("request from untrusted ip address",
+				zap.String("x_real_ip", r.Header.Get("X-Real-IP")),
+				zap.String("remote_addr", r.RemoteAddr),
+			)
+			http.Error(w, errormsg.ErrUntrustedIPAddress.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
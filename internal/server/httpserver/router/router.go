@@ -2,86 +2,121 @@
 package router
 
 import (
+	"crypto/ed25519"
 	"crypto/rsa"
+	"fmt"
+	"net"
+	"net/http"
 	_ "net/http/pprof" //nolint:gosec // Enable pprof debugger
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
 
+	"github.com/andymarkow/go-metrics-collector/internal/models"
 	"github.com/andymarkow/go-metrics-collector/internal/server/httpserver/router/handlers"
 	"github.com/andymarkow/go-metrics-collector/internal/server/httpserver/router/middlewares"
 	"github.com/andymarkow/go-metrics-collector/internal/storage"
 )
 
 type routerOpts struct {
-	logger        *zap.Logger
-	cryptoPrivKey *rsa.PrivateKey
-	signKey       []byte
+	logger         *zap.Logger
+	cryptoPrivKey  *rsa.PrivateKey
+	trustedSubnet  *net.IPNet
+	trustedProxies []*net.IPNet
+	fieldMap       map[string]string
+	maxBatchLength int
+	maxNameLength  int
+	signKey        []byte
+	signPubKey     ed25519.PublicKey
+	strictCounters bool
+	jsonUpdates    bool
+	preferMinimal  bool
+	initOnRead     bool
+	allowedOrigins []string
+	rateLimit      float64
+	rateBurst      int
+	config         any
+	buildInfo      models.BuildInfo
+	tracerProvider trace.TracerProvider
+	writeTracker   storage.WriteTracker
+
+	maxDecompressedBytes int64
 }
 
 func NewRouter(store storage.Storage, opts ...Option) *chi.Mux {
 	rOpts := routerOpts{
-		logger:  zap.NewNop(),
-		signKey: make([]byte, 0),
+		logger:               zap.NewNop(),
+		signKey:              make([]byte, 0),
+		tracerProvider:       noop.NewTracerProvider(),
+		maxDecompressedBytes: middlewares.DefaultMaxDecompressedBytes,
 	}
 
 	for _, opt := range opts {
 		opt(&rOpts)
 	}
 
-	h := handlers.NewHandlers(store, handlers.WithLogger(rOpts.logger))
+	h := handlers.NewHandlers(store,
+		handlers.WithLogger(rOpts.logger),
+		handlers.WithFieldMapping(rOpts.fieldMap),
+		handlers.WithStrictCounters(rOpts.strictCounters),
+		handlers.WithMaxBatchLength(rOpts.maxBatchLength),
+		handlers.WithMaxNameLength(rOpts.maxNameLength),
+		handlers.WithJSONUpdatesResponse(rOpts.jsonUpdates),
+		handlers.WithPreferMinimal(rOpts.preferMinimal),
+		handlers.WithInitOnRead(rOpts.initOnRead),
+		handlers.WithConfig(rOpts.config),
+		handlers.WithBuildInfo(rOpts.buildInfo),
+		handlers.WithWriteTracker(rOpts.writeTracker),
+	)
 
 	r := chi.NewRouter()
 
 	mw := middlewares.New(
 		middlewares.WithLogger(rOpts.logger),
 		middlewares.WithSignKey(rOpts.signKey),
+		middlewares.WithSignPublicKey(rOpts.signPubKey),
 		middlewares.WithCryptoPrivateKey(rOpts.cryptoPrivKey),
+		middlewares.WithTrustedSubnet(rOpts.trustedSubnet),
+		middlewares.WithTrustedProxies(rOpts.trustedProxies),
+		middlewares.WithAllowedOrigins(rOpts.allowedOrigins),
+		middlewares.WithRateLimit(rOpts.rateLimit, rOpts.rateBurst),
+		middlewares.WithMaxDecompressedBytes(rOpts.maxDecompressedBytes),
+		middlewares.WithMaxNameLength(rOpts.maxNameLength),
+		middlewares.WithTracerProvider(rOpts.tracerProvider),
 	)
 
 	r.Use(
 		middleware.Recoverer,
 		middleware.StripSlashes,
+		mw.RequestID,
+		mw.Tracing,
 		mw.Logger,
+		mw.Cors,
+		mw.RateLimit,
 	)
 
-	var useHashSumValidator bool
+	// The ed25519 signature takes precedence over the HMAC hash sum.
+	var signatureValidator func(http.Handler) http.Handler
 
-	if len(rOpts.signKey) > 0 {
-		useHashSumValidator = true
+	switch {
+	case len(rOpts.signPubKey) > 0:
+		signatureValidator = mw.Ed25519SignatureValidator
+	case len(rOpts.signKey) > 0:
+		signatureValidator = mw.HashSumValidator
 	}
 
-	r.Mount("/debug", middleware.Profiler())
-
-	r.Get("/ping", h.Ping)
-	r.With(mw.Compress).Get("/", h.GetAllMetrics)
-
-	r.Group(func(r chi.Router) {
-		r.Use(mw.Compress)
-		r.Use(mw.MetricValidator)
+	r.NotFound(h.NotFound)
 
-		r.Get("/value/{metricType}/{metricName}", h.GetMetric)
-		r.Post("/update/{metricType}/{metricName}/{metricValue}", h.UpdateMetric)
-	})
-
-	r.Group(func(r chi.Router) {
-		r.Use(mw.Compress)
-
-		r.Post("/value", h.GetMetricJSON)
-		r.Post("/update", h.UpdateMetricJSON)
-	})
-
-	r.Group(func(r chi.Router) {
-		r.Use(mw.Compress)
-		r.Use(mw.Cryptography)
-
-		if useHashSumValidator {
-			r.Use(mw.HashSumValidator)
-		}
+	r.Mount("/debug", middleware.Profiler())
 
-		r.Post("/updates", h.UpdateMetricsJSON)
-	})
+	// The router table is static, so a duplicate route is a programming
+	// error, and the router panics like chi does on the invalid patterns.
+	if err := registerRoutes(r, routeTable(h, mw, signatureValidator)); err != nil {
+		panic(fmt.Sprintf("router.NewRouter: %v", err))
+	}
 
 	return r
 }
@@ -103,9 +138,148 @@ func WithSignKey(signKey []byte) Option {
 	}
 }
 
+// WithSignPublicKey is a router option that sets the ed25519 public key the
+// batch updates signature is verified with instead of the sign key.
+func WithSignPublicKey(key ed25519.PublicKey) Option {
+	return func(o *routerOpts) {
+		o.signPubKey = key
+	}
+}
+
 // WithCryptoPrivateKey is a router option that sets decription RSA private key.
 func WithCryptoPrivateKey(key *rsa.PrivateKey) Option {
 	return func(o *routerOpts) {
 		o.cryptoPrivKey = key
 	}
 }
+
+// WithFieldMapping is a router option that sets alternate JSON field names
+// accepted by the batch updates endpoint.
+func WithFieldMapping(fieldMap map[string]string) Option {
+	return func(o *routerOpts) {
+		o.fieldMap = fieldMap
+	}
+}
+
+// WithStrictCounters is a router option that makes the update endpoint
+// reject non-integer counter values.
+func WithStrictCounters(strict bool) Option {
+	return func(o *routerOpts) {
+		o.strictCounters = strict
+	}
+}
+
+// WithTrustedSubnet is a router option that sets the subnet the admin
+// endpoints are allowed to be requested from.
+func WithTrustedSubnet(subnet *net.IPNet) Option {
+	return func(o *routerOpts) {
+		o.trustedSubnet = subnet
+	}
+}
+
+// WithTrustedProxies is a router option that sets the subnets of the reverse
+// proxies the client IP address is taken from the "X-Real-IP" header of.
+func WithTrustedProxies(proxies []*net.IPNet) Option {
+	return func(o *routerOpts) {
+		o.trustedProxies = proxies
+	}
+}
+
+// WithMaxBatchLength is a router option that limits the number of metrics
+// accepted by the batch updates endpoint. Zero means no limit.
+func WithMaxBatchLength(n int) Option {
+	return func(o *routerOpts) {
+		o.maxBatchLength = n
+	}
+}
+
+// WithMaxNameLength is a router option that limits the length of the metric
+// names in bytes. The default length is used if n is not positive.
+func WithMaxNameLength(n int) Option {
+	return func(o *routerOpts) {
+		o.maxNameLength = n
+	}
+}
+
+// WithJSONUpdatesResponse is a router option that makes the batch updates
+// endpoint respond with the number of stored metrics as JSON.
+func WithJSONUpdatesResponse(enabled bool) Option {
+	return func(o *routerOpts) {
+		o.jsonUpdates = enabled
+	}
+}
+
+// WithPreferMinimal is a router option that makes the update endpoints
+// respond with 204 No Content to the "Prefer: return=minimal" requests.
+func WithPreferMinimal(enabled bool) Option {
+	return func(o *routerOpts) {
+		o.preferMinimal = enabled
+	}
+}
+
+// WithAllowedOrigins is a router option that sets the origins the
+// cross-origin requests are allowed from. CORS is disabled if empty.
+func WithAllowedOrigins(origins []string) Option {
+	return func(o *routerOpts) {
+		o.allowedOrigins = origins
+	}
+}
+
+// WithRateLimit is a router option that limits the rate of the requests
+// per client IP address to limit requests per second with the burst.
+// The rate is not limited if the limit is not positive.
+func WithRateLimit(limit float64, burst int) Option {
+	return func(o *routerOpts) {
+		o.rateLimit = limit
+		o.rateBurst = burst
+	}
+}
+
+// WithMaxDecompressedBytes is a router option that sets the maximum size of
+// the decompressed request body. The size is not limited if n is not positive.
+func WithMaxDecompressedBytes(n int64) Option {
+	return func(o *routerOpts) {
+		o.maxDecompressedBytes = n
+	}
+}
+
+// WithInitOnRead is a router option that makes the get metric endpoints
+// respond with the zero value for the metrics never written instead of 404.
+func WithInitOnRead(enabled bool) Option {
+	return func(o *routerOpts) {
+		o.initOnRead = enabled
+	}
+}
+
+// WithConfig is a router option that sets the effective server config
+// served by the trusted subnet only config endpoint.
+func WithConfig(cfg any) Option {
+	return func(o *routerOpts) {
+		o.config = cfg
+	}
+}
+
+// WithBuildInfo is a router option that sets the server build information
+// served by the version endpoint.
+func WithBuildInfo(info models.BuildInfo) Option {
+	return func(o *routerOpts) {
+		o.buildInfo = info
+	}
+}
+
+// WithWriteTracker is a router option that sets the tracker of the storage
+// writes the last write age self-metric is reported from, e.g. when the
+// router storage wraps the tracking one.
+func WithWriteTracker(tracker storage.WriteTracker) Option {
+	return func(o *routerOpts) {
+		o.writeTracker = tracker
+	}
+}
+
+// WithTracerProvider is a router option that sets the tracer provider the
+// request spans are created with. The requests are not traced by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *routerOpts) {
+		o.tracerProvider = tp
+	}
+}
@@ -1,15 +1,28 @@
 package router
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/andymarkow/go-metrics-collector/internal/cryptutils"
 	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+	"github.com/andymarkow/go-metrics-collector/internal/signature"
 	"github.com/andymarkow/go-metrics-collector/internal/storage"
 )
 
@@ -205,3 +218,451 @@ func TestRouter(t *testing.T) {
 		})
 	}
 }
+
+func TestEmptyMetricName(t *testing.T) {
+	router := NewRouter(storage.NewMemStorage())
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	testCases := []struct {
+		name   string
+		method string
+		url    string
+	}{
+		{"GetWithoutSlash", http.MethodGet, "/value/counter"},
+		{"GetWithSlash", http.MethodGet, "/value/counter/"},
+		{"DeleteWithoutSlash", http.MethodDelete, "/value/gauge"},
+		{"DeleteWithSlash", http.MethodDelete, "/value/gauge/"},
+		{"UpdateWithoutSlash", http.MethodPost, "/update/counter"},
+		{"UpdateWithSlash", http.MethodPost, "/update/counter/"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, ts.URL+tc.url, nil) //nolint:noctx
+			require.NoError(t, err)
+
+			req.Header.Set("Accept-Encoding", "")
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			assert.Equal(t, errormsg.ErrMetricEmptyName.Error()+"\n", string(body))
+		})
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	router := NewRouter(storage.NewMemStorage())
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	testCases := []struct {
+		name     string
+		url      string
+		response string
+	}{
+		{"MetricNotFound", "/value/counter/NonExistingCounter", storage.ErrMetricNotFound.Error() + "\n"},
+		{"RouteNotFound", "/non/existing/route", errormsg.ErrRouteNotFound.Error() + "\n"},
+		{"MetricRouteNotFound", "/value/counter/someCounter/extra", errormsg.ErrRouteNotFound.Error() + "\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+tc.url, nil) //nolint:noctx
+			require.NoError(t, err)
+
+			req.Header.Set("Accept-Encoding", "")
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			assert.Equal(t, tc.response, string(body))
+		})
+	}
+}
+
+func TestResetCountersTrustedSubnet(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+
+	// The test server requests come from the loopback address.
+	_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+
+	proxies := []*net.IPNet{loopback}
+
+	testCases := []struct {
+		name     string
+		subnet   *net.IPNet
+		proxies  []*net.IPNet
+		realIP   string
+		status   int
+		response string
+	}{
+		{"TrustedRemoteAddr", loopback, nil, "", http.StatusOK, `{"reset":1}`},
+		{"TrustedIPFromProxy", subnet, proxies, "192.168.1.10", http.StatusOK, `{"reset":1}`},
+		{"UntrustedIPFromProxy", subnet, proxies, "10.0.0.1", http.StatusForbidden, errormsg.ErrUntrustedIPAddress.Error() + "\n"},
+		{"MissingIPFromProxy", subnet, proxies, "", http.StatusForbidden, errormsg.ErrUntrustedIPAddress.Error() + "\n"},
+		{"SpoofedIP", subnet, nil, "192.168.1.10", http.StatusForbidden, errormsg.ErrUntrustedIPAddress.Error() + "\n"},
+		{"NoTrustedSubnet", nil, nil, "", http.StatusForbidden, errormsg.ErrUntrustedIPAddress.Error() + "\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			strg := storage.NewMemStorage()
+			require.NoError(t, strg.SetCounter(context.Background(), "testCounter", 3))
+
+			ts := httptest.NewServer(NewRouter(strg,
+				WithTrustedSubnet(tc.subnet),
+				WithTrustedProxies(tc.proxies),
+			))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/admin/reset", nil) //nolint:noctx
+			require.NoError(t, err)
+
+			if tc.realIP != "" {
+				req.Header.Set("X-Real-IP", tc.realIP)
+			}
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+
+			defer func() {
+				require.NoError(t, resp.Body.Close())
+			}()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.status, resp.StatusCode)
+			assert.Equal(t, tc.response, string(body))
+		})
+	}
+}
+
+func TestCors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		origins    []string
+		method     string
+		origin     string
+		statusCode int
+		allowed    string
+		methods    string
+	}{
+		{"AllowedOrigin", []string{"https://dash.example.com"}, http.MethodGet, "https://dash.example.com", http.StatusOK, "https://dash.example.com", ""},
+		{"AnyOrigin", []string{"*"}, http.MethodGet, "https://other.example.com", http.StatusOK, "https://other.example.com", ""},
+		{"DisallowedOrigin", []string{"https://dash.example.com"}, http.MethodGet, "https://evil.example.com", http.StatusOK, "", ""},
+		{"Preflight", []string{"https://dash.example.com"}, http.MethodOptions, "https://dash.example.com", http.StatusNoContent, "https://dash.example.com", "GET, POST"},
+		{"PreflightDisallowed", []string{"https://dash.example.com"}, http.MethodOptions, "https://evil.example.com", http.StatusMethodNotAllowed, "", ""},
+		{"Disabled", nil, http.MethodGet, "https://dash.example.com", http.StatusOK, "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(NewRouter(storage.NewMemStorage(), WithAllowedOrigins(tc.origins)))
+			defer ts.Close()
+
+			req, err := http.NewRequest(tc.method, ts.URL+"/ping", nil) //nolint:noctx
+			require.NoError(t, err)
+
+			req.Header.Set("Origin", tc.origin)
+
+			if tc.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+			assert.Equal(t, tc.allowed, resp.Header.Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tc.methods, resp.Header.Get("Access-Control-Allow-Methods"))
+		})
+	}
+}
+
+func TestMetricNameValueLength(t *testing.T) {
+	router := NewRouter(storage.NewMemStorage())
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	atLimit := strings.Repeat("a", models.DefaultMaxNameLength)
+	overLimit := strings.Repeat("a", models.DefaultMaxNameLength+1)
+
+	testCases := []struct {
+		name       string
+		url        string
+		body       string
+		statusCode int
+	}{
+		{"NameAtLimit", "/update/gauge/" + atLimit + "/1", "", http.StatusOK},
+		{"NameOverLimit", "/update/gauge/" + overLimit + "/1", "", http.StatusBadRequest},
+		{"ValueAtLimit", "/update/gauge/test/" + strings.Repeat("1", models.MaxValueLength), "", http.StatusOK},
+		{"ValueOverLimit", "/update/gauge/test/" + strings.Repeat("1", models.MaxValueLength+1), "", http.StatusBadRequest},
+		{"JSONNameAtLimit", "/update", `{"id": "` + atLimit + `", "type": "gauge", "value": 1}`, http.StatusOK},
+		{"JSONNameOverLimit", "/update", `{"id": "` + overLimit + `", "type": "gauge", "value": 1}`, http.StatusBadRequest},
+		{"NameControlCharacter", "/update/gauge/Heap%0AAlloc/1", "", http.StatusBadRequest},
+		{"JSONNameControlCharacter", "/update", `{"id": "Heap\u0007Alloc", "type": "gauge", "value": 1}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+tc.url, strings.NewReader(tc.body)) //nolint:noctx
+			require.NoError(t, err)
+
+			req.Header.Set("Accept-Encoding", "")
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+		})
+	}
+}
+
+func TestMaxNameLength(t *testing.T) {
+	// The limit of one router does not affect the other one.
+	limited := httptest.NewServer(NewRouter(storage.NewMemStorage(), WithMaxNameLength(10)))
+	defer limited.Close()
+
+	standard := httptest.NewServer(NewRouter(storage.NewMemStorage()))
+	defer standard.Close()
+
+	name := strings.Repeat("a", 11)
+
+	testCases := []struct {
+		name       string
+		ts         *httptest.Server
+		url        string
+		body       string
+		statusCode int
+	}{
+		{"LimitedURL", limited, "/update/gauge/" + name + "/1", "", http.StatusBadRequest},
+		{"LimitedJSON", limited, "/update", `{"id": "` + name + `", "type": "gauge", "value": 1}`, http.StatusBadRequest},
+		{"LimitedAtLimit", limited, "/update/gauge/" + name[1:] + "/1", "", http.StatusOK},
+		{"DefaultURL", standard, "/update/gauge/" + name + "/1", "", http.StatusOK},
+		{"DefaultJSON", standard, "/update", `{"id": "` + name + `", "type": "gauge", "value": 1}`, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, tc.ts.URL+tc.url, strings.NewReader(tc.body)) //nolint:noctx
+			require.NoError(t, err)
+
+			req.Header.Set("Accept-Encoding", "")
+
+			resp, err := tc.ts.Client().Do(req)
+			require.NoError(t, err)
+
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+		})
+	}
+}
+
+func TestDecompressedBodyLimit(t *testing.T) {
+	router := NewRouter(storage.NewMemStorage(), WithMaxDecompressedBytes(1024))
+
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	metric := `{"id": "test", "type": "gauge", "value": 1}`
+	padded := `{"id": "test",` + strings.Repeat(" ", 1024) + `"type": "gauge", "value": 1}`
+
+	testCases := []struct {
+		name       string
+		url        string
+		body       string
+		statusCode int
+	}{
+		{"UpdateUnderLimit", "/update", metric, http.StatusOK},
+		{"UpdateOverLimit", "/update", padded, http.StatusRequestEntityTooLarge},
+		{"UpdatesOverLimit", "/updates", "[" + padded + "]", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			zw := gzip.NewWriter(&buf)
+
+			_, err := zw.Write([]byte(tc.body))
+			require.NoError(t, err)
+			require.NoError(t, zw.Close())
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+tc.url, &buf) //nolint:noctx
+			require.NoError(t, err)
+
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", "gzip")
+			req.Header.Set("Accept-Encoding", "")
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+		})
+	}
+}
+
+func TestUpdatesSignedEncryptedCompressed(t *testing.T) {
+	signKey := []byte("secret")
+
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	strg := storage.NewMemStorage()
+
+	ts := httptest.NewServer(NewRouter(strg,
+		WithSignKey(signKey),
+		WithCryptoPrivateKey(privKey),
+	))
+	defer ts.Close()
+
+	delta := int64(3)
+
+	payload, err := json.Marshal([]models.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}})
+	require.NoError(t, err)
+
+	encrypted, err := cryptutils.EncryptOAEP(sha256.New(), rand.Reader, &privKey.PublicKey, payload, nil)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	_, err = gz.Write(encrypted)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	// The agent signs the plain JSON payload before encrypting and compressing it.
+	validSign, err := signature.CalculateHashSum(signKey, payload)
+	require.NoError(t, err)
+
+	encryptedSign, err := signature.CalculateHashSum(signKey, encrypted)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		sign       []byte
+		statusCode int
+	}{
+		{"PayloadSignature", validSign, http.StatusOK},
+		{"CiphertextSignature", encryptedSign, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, ts.URL+"/updates", bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", "gzip")
+			req.Header.Set("HashSHA256", hex.EncodeToString(tc.sign)) //nolint:canonicalheader
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+
+			assert.Equal(t, tc.statusCode, resp.StatusCode)
+		})
+	}
+
+	// The metrics are stored by the valid request only.
+	cnt, err := strg.GetCounter(context.Background(), "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), cnt)
+}
+
+func TestWriteLineProtocolCompressed(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	ts := httptest.NewServer(NewRouter(strg))
+	defer ts.Close()
+
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte("mem,host=a used_percent=42.5\n"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, ts.URL+"/write", &buf)
+	require.NoError(t, err)
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	gauge, err := strg.GetGauge(context.Background(), "mem_used_percent")
+	require.NoError(t, err)
+	assert.InDelta(t, 42.5, gauge, 0)
+}
+
+func TestPrometheusLastWriteAge(t *testing.T) {
+	getMetrics := func(router http.Handler, query string) string {
+		ts := httptest.NewServer(router)
+		defer ts.Close()
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, ts.URL+"/metrics"+query, nil)
+		require.NoError(t, err)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		return string(body)
+	}
+
+	router := NewRouter(storage.NewStorage(storage.NewMemStorage()))
+
+	body := getMetrics(router, "")
+	assert.Contains(t, body, "# TYPE "+storage.LastWriteAgeMetric+" gauge\n")
+	assert.Contains(t, body, storage.LastWriteAgeMetric+" ")
+
+	assert.Contains(t, getMetrics(router, "?match=storage_*"), storage.LastWriteAgeMetric)
+	assert.NotContains(t, getMetrics(router, "?match=Heap*"), storage.LastWriteAgeMetric)
+
+	// The writes of the plain storage are not tracked.
+	assert.NotContains(t, getMetrics(NewRouter(storage.NewMemStorage()), ""), storage.LastWriteAgeMetric)
+
+	// The tracker is set explicitly when the router storage wraps it.
+	router = NewRouter(storage.NewMemStorage(), WithWriteTracker(storage.NewStorage(storage.NewMemStorage())))
+	assert.Contains(t, getMetrics(router, ""), storage.LastWriteAgeMetric)
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/andymarkow/go-metrics-collector/internal/server/httpserver/router/handlers"
+	"github.com/andymarkow/go-metrics-collector/internal/server/httpserver/router/middlewares"
+)
+
+// route is a single entry of the router table.
+type route struct {
+	method      string
+	pattern     string
+	handler     http.HandlerFunc
+	middlewares []func(http.Handler) http.Handler
+}
+
+// routeTable returns the routes served by the router.
+//
+// The signatureValidator validates the signature of the batch updates, unless
+// it is nil.
+func routeTable(h *handlers.Handlers, mw *middlewares.Middlewares, signatureValidator func(http.Handler) http.Handler) []route {
+	compressed := chi.Middlewares{mw.Compress}
+	validated := chi.Middlewares{mw.Compress, mw.MetricValidator}
+	trusted := chi.Middlewares{mw.TrustedSubnet}
+	// The agent signs the JSON payload, encrypts it and compresses the
+	// ciphertext, so the request body is decompressed, decrypted and only
+	// then validated against the signature.
+	encrypted := chi.Middlewares{mw.Compress, mw.Cryptography}
+
+	if signatureValidator != nil {
+		encrypted = append(encrypted, signatureValidator)
+	}
+
+	return []route{
+		{http.MethodGet, "/ping", h.Ping, nil},
+		{http.MethodGet, "/ready", h.Ready, nil},
+		{http.MethodGet, "/version", h.GetVersion, nil},
+		{http.MethodGet, "/", h.GetAllMetrics, compressed},
+		{http.MethodGet, "/metrics", h.GetAllMetricsPrometheus, compressed},
+		{http.MethodGet, "/values/{prefix}", h.GetMetricsByPrefix, compressed},
+		{http.MethodGet, "/stale", h.GetStaleMetrics, compressed},
+
+		{http.MethodPost, "/admin/reset", h.ResetCounters, trusted},
+		{http.MethodGet, "/config", h.GetConfig, trusted},
+
+		{http.MethodGet, "/value/{metricType}/{metricName}", h.GetMetric, validated},
+		{http.MethodDelete, "/value/{metricType}/{metricName}", h.DeleteMetric, validated},
+		{http.MethodPost, "/update/{metricType}/{metricName}/{metricValue}", h.UpdateMetric, validated},
+
+		// The trailing slash is stripped from the routes with an empty metric
+		// name, so they are matched here to be rejected by the validator.
+		{http.MethodGet, "/value/{metricType}", h.GetMetric, validated},
+		{http.MethodDelete, "/value/{metricType}", h.DeleteMetric, validated},
+		{http.MethodPost, "/update/{metricType}", h.UpdateMetric, validated},
+
+		{http.MethodPost, "/value", h.GetMetricJSON, compressed},
+		{http.MethodPost, "/values", h.GetMetricsJSON, compressed},
+		{http.MethodPost, "/update", h.UpdateMetricJSON, compressed},
+
+		// The InfluxDB line protocol clients, e.g. Telegraf, gzip the body.
+		{http.MethodPost, "/write", h.WriteLineProtocol, compressed},
+
+		{http.MethodPost, "/updates", h.UpdateMetricsJSON, encrypted},
+	}
+}
+
+// urlParamPattern matches the URL parameters of a route pattern.
+var urlParamPattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// validateRoutes checks the router table for the duplicate routes.
+//
+// The routes are conflicting if they have the same method and their patterns
+// differ only in the URL parameter names, e.g. "/value/{type}" and
+// "/value/{metricType}".
+func validateRoutes(routes []route) error {
+	seen := make(map[string]string, len(routes))
+
+	for _, rt := range routes {
+		if rt.method == "" || rt.pattern == "" || rt.handler == nil {
+			return fmt.Errorf("incomplete route: %s %s", rt.method, rt.pattern)
+		}
+
+		key := rt.method + " " + urlParamPattern.ReplaceAllString(rt.pattern, "{}")
+
+		if pattern, ok := seen[key]; ok {
+			return fmt.Errorf("duplicate route: %s %s conflicts with %s", rt.method, rt.pattern, pattern)
+		}
+
+		seen[key] = rt.pattern
+	}
+
+	return nil
+}
+
+// registerRoutes validates the router table and registers the routes.
+func registerRoutes(r chi.Router, routes []route) error {
+	if err := validateRoutes(routes); err != nil {
+		return fmt.Errorf("validateRoutes: %w", err)
+	}
+
+	for _, rt := range routes {
+		r.With(rt.middlewares...).Method(rt.method, rt.pattern, rt.handler)
+	}
+
+	return nil
+}
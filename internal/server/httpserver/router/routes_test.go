@@ -0,0 +1,105 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andymarkow/go-metrics-collector/internal/server/httpserver/router/handlers"
+	"github.com/andymarkow/go-metrics-collector/internal/server/httpserver/router/middlewares"
+	"github.com/andymarkow/go-metrics-collector/internal/storage"
+)
+
+func TestRouteTable(t *testing.T) {
+	r := NewRouter(storage.NewMemStorage())
+
+	var got []string
+
+	require.NoError(t, chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, "/debug/") {
+			got = append(got, method+" "+route)
+		}
+
+		return nil
+	}))
+
+	want := []string{
+		"GET /ping",
+		"GET /ready",
+		"GET /version",
+		"GET /",
+		"GET /metrics",
+		"GET /values/{prefix}",
+		"GET /stale",
+		"POST /admin/reset",
+		"GET /config",
+		"GET /value/{metricType}/{metricName}",
+		"DELETE /value/{metricType}/{metricName}",
+		"POST /update/{metricType}/{metricName}/{metricValue}",
+		"GET /value/{metricType}",
+		"DELETE /value/{metricType}",
+		"POST /update/{metricType}",
+		"POST /value",
+		"POST /values",
+		"POST /update",
+		"POST /write",
+		"POST /updates",
+	}
+
+	assert.ElementsMatch(t, want, got)
+}
+
+func TestValidateRoutes(t *testing.T) {
+	h := handlers.NewHandlers(storage.NewMemStorage())
+	mw := middlewares.New()
+
+	require.NoError(t, validateRoutes(routeTable(h, mw, mw.HashSumValidator)))
+
+	tests := []struct {
+		name   string
+		routes []route
+	}{
+		{
+			name: "duplicate",
+			routes: []route{
+				{http.MethodGet, "/ping", h.Ping, nil},
+				{http.MethodGet, "/ping", h.Ping, nil},
+			},
+		},
+		{
+			name: "conflicting parameter names",
+			routes: []route{
+				{http.MethodGet, "/value/{metricType}", h.GetMetric, nil},
+				{http.MethodGet, "/value/{type}", h.GetMetric, nil},
+			},
+		},
+		{
+			name: "missing handler",
+			routes: []route{
+				{http.MethodGet, "/ping", nil, nil},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Error(t, validateRoutes(tc.routes))
+		})
+	}
+
+	// The same pattern with another method is allowed.
+	assert.NoError(t, validateRoutes([]route{
+		{http.MethodGet, "/value/{metricType}", h.GetMetric, nil},
+		{http.MethodDelete, "/value/{metricType}", h.DeleteMetric, nil},
+	}))
+
+	// Nothing is registered if the router table is invalid.
+	r := chi.NewRouter()
+
+	require.Error(t, registerRoutes(r, tests[0].routes))
+	assert.Empty(t, r.Routes())
+}
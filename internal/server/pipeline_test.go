@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/andymarkow/go-metrics-collector/internal/monitor"
+	"github.com/andymarkow/go-metrics-collector/internal/server/httpserver/router"
+	"github.com/andymarkow/go-metrics-collector/internal/storage"
+)
+
+// TestAgentServerPipeline runs the agent monitor against the real router,
+// so the metrics go through collect, batch, sign, encrypt, compress, HTTP,
+// decompress, decrypt, validate and store all together.
+func TestAgentServerPipeline(t *testing.T) {
+	signKey := []byte("secret")
+
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	// The test server requests come from the loopback address, which is
+	// also trusted as the proxy setting the "X-Real-IP" header.
+	_, trustedSubnet, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+
+	for _, compression := range []string{monitor.CompressionGzip, monitor.CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			strg := storage.NewMemStorage()
+
+			ts := httptest.NewServer(router.NewRouter(strg,
+				router.WithSignKey(signKey),
+				router.WithCryptoPrivateKey(privKey),
+				router.WithTrustedSubnet(trustedSubnet),
+				router.WithTrustedProxies([]*net.IPNet{trustedSubnet}),
+			))
+			defer ts.Close()
+
+			mon := monitor.NewMonitor(
+				monitor.WithLogger(zap.NewNop()),
+				monitor.WithServerAddr(ts.URL),
+				monitor.WithSignKey(signKey),
+				monitor.WithCryptoPubKey(&privKey.PublicKey),
+				monitor.WithCompression(compression),
+			)
+
+			mon.RunOnce()
+
+			ctx := context.Background()
+
+			pollCount, err := strg.GetCounter(ctx, "PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), pollCount)
+
+			alloc, err := strg.GetGauge(ctx, "Alloc")
+			require.NoError(t, err)
+			assert.Positive(t, alloc)
+
+			data, err := strg.GetAllMetrics(ctx)
+			require.NoError(t, err)
+
+			for _, name := range []string{"HeapAlloc", "RandomValue", "TotalMemory", "agent_HTTPConnNew"} {
+				assert.Contains(t, data, name)
+			}
+
+			// The admin endpoints are reachable from the trusted subnet only.
+			for ip, want := range map[string]int{"127.0.0.1": http.StatusOK, "10.0.0.1": http.StatusForbidden} {
+				req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL+"/admin/reset", nil)
+				require.NoError(t, err)
+
+				req.Header.Set("X-Real-IP", ip)
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+
+				assert.Equal(t, want, resp.StatusCode, ip)
+			}
+		})
+	}
+}
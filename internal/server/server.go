@@ -3,36 +3,92 @@ package server
 
 import (
 	"context"
+	"crypto/ed25519"
+	"expvar"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 	"go.uber.org/zap"
 
 	"github.com/andymarkow/go-metrics-collector/internal/cryptutils"
 	"github.com/andymarkow/go-metrics-collector/internal/datamanager"
 	"github.com/andymarkow/go-metrics-collector/internal/logger"
+	"github.com/andymarkow/go-metrics-collector/internal/models"
 	"github.com/andymarkow/go-metrics-collector/internal/server/httpserver"
 	"github.com/andymarkow/go-metrics-collector/internal/server/httpserver/router"
+	"github.com/andymarkow/go-metrics-collector/internal/statsd"
 	"github.com/andymarkow/go-metrics-collector/internal/storage"
 )
 
 // Server represents a metrics server.
 type Server struct {
 	log           *zap.Logger
+	cfg           config
 	httpsrv       *httpserver.HTTPServer
 	datamgr       *datamanager.DataManager
+	aggregator    *storage.GaugeAggregator
+	compactor     *storage.Compactor
+	statsd        *statsd.Listener
+	replica       storage.Storage
 	storage       storage.Storage
 	storeFile     string
 	storeInterval time.Duration
 	restoreOnBoot bool
+
+	// shutdownTimeout is the time to wait for the in-flight requests,
+	// e.g. large batch updates, to complete on shutdown.
+	shutdownTimeout time.Duration
+}
+
+// options represents the server options.
+type options struct {
+	buildInfo      models.BuildInfo
+	tracerProvider trace.TracerProvider
+}
+
+// Option is a server option.
+type Option func(o *options)
+
+// WithBuildInfo is a server option that sets the build version, date,
+// and commit hash served by the version endpoint.
+func WithBuildInfo(version, date, commit string) Option {
+	return func(o *options) {
+		o.buildInfo = models.BuildInfo{
+			Version: version,
+			Date:    date,
+			Commit:  commit,
+		}
+	}
+}
+
+// WithTracerProvider is a server option that sets the tracer provider the
+// request and the Postgres storage spans are created with. Tracing is
+// disabled by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
 }
 
 // NewServer creates a new metrics server.
-func NewServer() (*Server, error) {
+func NewServer(opts ...Option) (*Server, error) {
+	o := &options{
+		buildInfo:      models.BuildInfo{Version: "N/A", Date: "N/A", Commit: "N/A"},
+		tracerProvider: noop.NewTracerProvider(),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	cfg, err := newConfig()
 	if err != nil {
 		return nil, fmt.Errorf("newConfig: %w", err)
@@ -43,10 +99,64 @@ func NewServer() (*Server, error) {
 		return nil, fmt.Errorf("logger.NewZapLogger: %w", err)
 	}
 
-	var strg storage.Storage = storage.NewMemStorage()
+	if cfg.configFileMissing {
+		log.Sugar().Infof("Config file %s not found, using flags and environment variables", cfg.ConfigFile)
+	}
+
+	buckets, err := parseHistogramBuckets(cfg.HistogramBuckets)
+	if err != nil {
+		return nil, fmt.Errorf("parseHistogramBuckets: %w", err)
+	}
+
+	// Only the storage backend selected by the config is opened.
+	backend := storageBackend(cfg)
+
+	var strg storage.Storage
+
+	var compactor *storage.Compactor
+
+	// replica is the database read replica the data saver reads from.
+	var replica storage.Storage
+
+	healthCheckTimeout := time.Duration(cfg.HealthCheckTimeout) * time.Second
+
+	switch backend {
+	case "redis":
+		redisStorage, err := storage.NewRedisStorage(cfg.RedisDSN,
+			storage.WithLogger(log),
+			storage.WithHealthCheckTimeout(healthCheckTimeout),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("storage.NewRedisStorage: %w", err)
+		}
+
+		strg = redisStorage
+
+	case "sqlite":
+		sqliteStorage, err := storage.NewSQLiteStorage(cfg.SQLitePath,
+			storage.WithLogger(log),
+			storage.WithHealthCheckTimeout(healthCheckTimeout),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("storage.NewSQLiteStorage: %w", err)
+		}
+
+		if err := sqliteStorage.Bootstrap(context.Background()); err != nil {
+			return nil, fmt.Errorf("sqliteStorage.Bootstrap: %w", err)
+		}
 
-	if cfg.DatabaseDSN != "" {
-		pgStorage, err := storage.NewPostgresStorage(cfg.DatabaseDSN, storage.WithLogger(log))
+		strg = sqliteStorage
+
+	case "postgres":
+		pgStorage, err := storage.NewPostgresStorage(cfg.DatabaseDSN,
+			storage.WithLogger(log),
+			storage.WithMaxOpenConns(cfg.DBMaxOpenConns),
+			storage.WithMaxIdleConns(cfg.DBMaxIdleConns),
+			storage.WithConnMaxIdleTime(time.Duration(cfg.DBConnMaxIdleTime)*time.Second),
+			storage.WithConnMaxLifetime(time.Duration(cfg.DBConnMaxLifetime)*time.Second),
+			storage.WithHealthCheckTimeout(healthCheckTimeout),
+			storage.WithTracerProvider(o.tracerProvider),
+		)
 		if err != nil {
 			return nil, fmt.Errorf("storage.NewPostgresStorage: %w", err)
 		}
@@ -57,45 +167,175 @@ func NewServer() (*Server, error) {
 			return nil, fmt.Errorf("pgStorage.Bootstrap: %w", err)
 		}
 
+		if cfg.Retention > 0 {
+			compactor = storage.NewCompactor(pgStorage, time.Duration(cfg.Retention)*time.Second,
+				storage.WithCompactorLogger(log),
+				storage.WithCompactInterval(time.Duration(cfg.CompactInterval)*time.Second),
+				storage.WithCompactCounters(cfg.CompactCounters),
+			)
+		}
+
+		// The replica is only read by the data saver, which runs with the
+		// database storage if the file store is forced.
+		if cfg.DatabaseReplicaDSN != "" && cfg.FileStoreWithDB {
+			replica, err = storage.NewPostgresStorage(cfg.DatabaseReplicaDSN,
+				storage.WithLogger(log),
+				storage.WithMaxOpenConns(1),
+				storage.WithMaxIdleConns(1),
+				storage.WithTracerProvider(o.tracerProvider),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("storage.NewPostgresStorage: %w", err)
+			}
+		}
+
 		strg = pgStorage
+
+	default:
+		strg = storage.NewMemStorage(storage.WithHistogramBuckets(buckets))
+	}
+
+	storeFile := effectiveStoreFile(log, cfg, backend)
+
+	var aggregator *storage.GaugeAggregator
+
+	if cfg.GaugeAggregate != "" {
+		mode, err := storage.ParseAggregateMode(cfg.GaugeAggregate)
+		if err != nil {
+			return nil, fmt.Errorf("storage.ParseAggregateMode: %w", err)
+		}
+
+		if cfg.GaugeAggregateWindow < 1 {
+			return nil, fmt.Errorf("invalid gauge aggregate window: %d", cfg.GaugeAggregateWindow)
+		}
+
+		aggregator = storage.NewGaugeAggregator(strg,
+			storage.WithAggregatorLogger(log),
+			storage.WithAggregateMode(mode),
+			storage.WithAggregateWindow(time.Duration(cfg.GaugeAggregateWindow)*time.Second),
+		)
+
+		strg = aggregator
 	}
 
 	store := storage.NewStorage(strg)
 
+	publishLastWriteAge(store)
+
 	privateKey, err := cryptutils.LoadRSAPrivateKey(cfg.CryptoKey)
 	if err != nil {
 		return nil, fmt.Errorf("cryptutils.LoadRSAPrivateKey: %w", err)
 	}
 
-	r := router.NewRouter(store,
+	var signPubKey ed25519.PublicKey
+
+	if cfg.SignPublicKey != "" {
+		signPubKey, err = cryptutils.LoadEd25519PublicKey(cfg.SignPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("cryptutils.LoadEd25519PublicKey: %w", err)
+		}
+	}
+
+	fieldMap, err := parseFieldMap(cfg.FieldMap)
+	if err != nil {
+		return nil, fmt.Errorf("parseFieldMap: %w", err)
+	}
+
+	var trustedSubnet *net.IPNet
+
+	if cfg.TrustedSubnet != "" {
+		_, trustedSubnet, err = net.ParseCIDR(cfg.TrustedSubnet)
+		if err != nil {
+			return nil, fmt.Errorf("net.ParseCIDR: %w", err)
+		}
+	}
+
+	trustedProxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("parseTrustedProxies: %w", err)
+	}
+
+	// The data is saved after each batch update if there is no store interval.
+	datamgrOpts := []datamanager.Option{
+		datamanager.WithLogger(log),
+		datamanager.WithStoreInterval(time.Duration(cfg.StoreInterval) * time.Second),
+		datamanager.WithSynchronous(synchronousSave(cfg, storeFile)),
+		datamanager.WithRestoreMaxAge(time.Duration(cfg.RestoreMaxAge) * time.Second),
+	}
+
+	if replica != nil {
+		datamgrOpts = append(datamgrOpts, datamanager.WithSaveSource(replica))
+	}
+
+	datamgr := datamanager.NewDataManager(store, storeFile, datamgrOpts...)
+
+	r := router.NewRouter(datamgr.SynchronousStorage(store),
 		router.WithCryptoPrivateKey(privateKey),
 		router.WithLogger(log),
 		router.WithSignKey([]byte(cfg.SignKey)),
+		router.WithSignPublicKey(signPubKey),
+		router.WithFieldMapping(fieldMap),
+		router.WithStrictCounters(cfg.StrictCounters),
+		router.WithTrustedSubnet(trustedSubnet),
+		router.WithTrustedProxies(trustedProxies),
+		router.WithMaxBatchLength(cfg.MaxBatchLength),
+		router.WithMaxNameLength(cfg.MaxNameLength),
+		router.WithJSONUpdatesResponse(cfg.JSONUpdatesResponse),
+		router.WithPreferMinimal(cfg.PreferMinimal),
+		router.WithInitOnRead(cfg.InitOnRead),
+		router.WithAllowedOrigins(parseAllowedOrigins(cfg.AllowedOrigins)),
+		router.WithRateLimit(cfg.RateLimit, cfg.RateBurst),
+		router.WithMaxDecompressedBytes(cfg.MaxDecompressedBytes),
+		router.WithConfig(cfg.masked()),
+		router.WithBuildInfo(o.buildInfo),
+		router.WithTracerProvider(o.tracerProvider),
+		router.WithWriteTracker(store),
 	)
 
+	var statsdListener *statsd.Listener
+
+	if cfg.StatsdAddr != "" {
+		// The StatsD packets are not batch updates, so they are not saved
+		// synchronously to not rewrite the file on every packet.
+		statsdListener = statsd.NewListener(store, cfg.StatsdAddr,
+			statsd.WithLogger(log),
+			statsd.WithMaxNameLength(cfg.MaxNameLength),
+		)
+	}
+
 	srv := httpserver.NewHTTPServer(r,
 		httpserver.WithLogger(log),
 		httpserver.WithServerAddr(cfg.ServerAddr),
+		httpserver.WithTLS(cfg.TLSCertFile, cfg.TLSKeyFile),
 	)
 
-	datamgr := datamanager.NewDataManager(store, cfg.StoreFile,
-		datamanager.WithLogger(log),
-		datamanager.WithStoreInterval(time.Duration(cfg.StoreInterval)*time.Second),
-	)
+	logStartupSummary(log, cfg, backend, storeFile)
 
 	return &Server{
-		log:           log,
-		httpsrv:       srv,
-		datamgr:       datamgr,
-		restoreOnBoot: cfg.RestoreOnBoot,
-		storage:       store,
-		storeInterval: time.Duration(cfg.StoreInterval) * time.Second,
-		storeFile:     cfg.StoreFile,
+		log:             log,
+		cfg:             cfg,
+		httpsrv:         srv,
+		datamgr:         datamgr,
+		aggregator:      aggregator,
+		compactor:       compactor,
+		statsd:          statsdListener,
+		restoreOnBoot:   cfg.RestoreOnBoot && storeFile != "",
+		replica:         replica,
+		storage:         store,
+		storeInterval:   time.Duration(cfg.StoreInterval) * time.Second,
+		storeFile:       storeFile,
+		shutdownTimeout: time.Duration(cfg.ShutdownTimeout) * time.Second,
 	}, nil
 }
 
 // Close closes the server.
 func (s *Server) Close() error {
+	if s.replica != nil {
+		if err := s.replica.Close(); err != nil {
+			return fmt.Errorf("replica.Close: %w", err)
+		}
+	}
+
 	if err := s.storage.Close(); err != nil {
 		return fmt.Errorf("storage.Close: %w", err)
 	}
@@ -136,6 +376,28 @@ func (s *Server) Start() error {
 		}()
 	}
 
+	if s.aggregator != nil {
+		wg.Add(1)
+
+		go s.aggregator.RunFlusher(ctx, wg)
+	}
+
+	if s.compactor != nil {
+		wg.Add(1)
+
+		go s.compactor.RunCompactor(ctx, wg)
+	}
+
+	if s.statsd != nil {
+		wg.Add(1)
+
+		go func() {
+			if err := s.statsd.Run(ctx, wg); err != nil {
+				errChan <- fmt.Errorf("statsd.Run: %w", err)
+			}
+		}()
+	}
+
 	go func() {
 		if err := s.httpsrv.Start(); err != nil {
 			errChan <- fmt.Errorf("server.Start: %w", err)
@@ -154,7 +416,7 @@ func (s *Server) Start() error {
 		case <-quit:
 			s.log.Info("Gracefully shutting down server...")
 
-			httpSrvStopCtx, httpSrvStopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			httpSrvStopCtx, httpSrvStopCancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
 			defer httpSrvStopCancel()
 
 			if err := s.httpsrv.Shutdown(httpSrvStopCtx); err != nil {
@@ -169,3 +431,104 @@ func (s *Server) Start() error {
 		}
 	}
 }
+
+// lastWriteAgeTracker is the storage write tracker of the last created server
+// the last write age expvar reports. The expvar can be published only once
+// per process.
+var (
+	lastWriteAgeTracker atomic.Pointer[storage.WriteTrackingStorage]
+	lastWriteAgeOnce    sync.Once
+)
+
+// publishLastWriteAge publishes the last write age of the storage via the
+// expvar stats endpoint.
+func publishLastWriteAge(store *storage.WriteTrackingStorage) {
+	lastWriteAgeTracker.Store(store)
+
+	lastWriteAgeOnce.Do(func() {
+		expvar.Publish(storage.LastWriteAgeMetric, expvar.Func(func() any {
+			return lastWriteAgeTracker.Load().LastWriteAge().Seconds()
+		}))
+	})
+}
+
+// storageBackend returns the storage backend selected by the config.
+//
+// The database storage takes precedence over SQLite, which takes precedence
+// over Redis. The in-memory storage is used if none of them is set.
+func storageBackend(cfg config) string {
+	switch {
+	case cfg.DatabaseDSN != "":
+		return "postgres"
+	case cfg.SQLitePath != "":
+		return "sqlite"
+	case cfg.RedisDSN != "":
+		return "redis"
+	default:
+		return "memory"
+	}
+}
+
+// synchronousSave reports whether the metrics data is saved into the store
+// file after each batch update, which is the case with zero store interval.
+func synchronousSave(cfg config, storeFile string) bool {
+	return cfg.StoreInterval == 0 && storeFile != ""
+}
+
+// effectiveStoreFile returns the file the metrics data is stored into and
+// restored from, or an empty string if the file store is disabled.
+//
+// The database storages are durable on their own and do not restore the data
+// from the file, so the file store only duplicates them with the periodic full
+// scans. It is disabled with them unless forced by the config.
+func effectiveStoreFile(log *zap.Logger, cfg config, backend string) string {
+	if backend == "memory" || cfg.StoreFile == "" {
+		return cfg.StoreFile
+	}
+
+	if cfg.FileStoreWithDB {
+		log.Info("Metrics data file store is enabled with the database storage",
+			zap.String("storage", backend),
+			zap.String("store_file", cfg.StoreFile),
+		)
+
+		return cfg.StoreFile
+	}
+
+	log.Info("Metrics data file store is disabled with the database storage",
+		zap.String("storage", backend),
+	)
+
+	return ""
+}
+
+// logStartupSummary logs the effective server configuration in a single line.
+//
+// The storeFile is the effective store file, which is empty if the file store
+// is disabled with the database storage.
+func logStartupSummary(log *zap.Logger, cfg config, backend, storeFile string) {
+	cfg = cfg.redacted()
+
+	log.Info("Server configuration",
+		zap.String("address", cfg.ServerAddr),
+		zap.String("storage", backend),
+		zap.String("database_dsn", cfg.DatabaseDSN),
+		zap.String("database_replica_dsn", cfg.DatabaseReplicaDSN),
+		zap.String("redis_dsn", cfg.RedisDSN),
+		zap.String("sqlite_path", cfg.SQLitePath),
+		zap.String("statsd_addr", cfg.StatsdAddr),
+		zap.String("store_file", storeFile),
+		zap.Duration("store_interval", time.Duration(cfg.StoreInterval)*time.Second),
+		zap.Bool("restore", cfg.RestoreOnBoot && storeFile != ""),
+		zap.Duration("restore_max_age", time.Duration(cfg.RestoreMaxAge)*time.Second),
+		zap.String("gauge_aggregate", cfg.GaugeAggregate),
+		zap.Int("metrics_retention", cfg.Retention),
+		zap.Bool("signing", cfg.SignKey != "" || cfg.SignPublicKey != ""),
+		zap.Bool("ed25519_signing", cfg.SignPublicKey != ""),
+		zap.Bool("encryption", cfg.CryptoKey != ""),
+		zap.Bool("tls", cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""),
+		zap.Bool("strict_counters", cfg.StrictCounters),
+		zap.String("trusted_subnet", cfg.TrustedSubnet),
+		zap.String("trusted_proxies", cfg.TrustedProxies),
+	)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/andymarkow/go-metrics-collector/internal/storage"
+)
+
+func TestStorageBackend(t *testing.T) {
+	testCases := []struct {
+		name string
+		cfg  config
+		want string
+	}{
+		{"Memory", config{}, "memory"},
+		{"Redis", config{RedisDSN: "redis://localhost:6379/0"}, "redis"},
+		{"SQLite", config{SQLitePath: "/tmp/metrics.db"}, "sqlite"},
+		{"Postgres", config{DatabaseDSN: "postgres://localhost:5432/metrics"}, "postgres"},
+		{"SQLiteOverRedis", config{RedisDSN: "redis://localhost:6379/0", SQLitePath: "/tmp/metrics.db"}, "sqlite"},
+		{"PostgresOverAll", config{
+			RedisDSN:    "redis://localhost:6379/0",
+			SQLitePath:  "/tmp/metrics.db",
+			DatabaseDSN: "postgres://localhost:5432/metrics",
+		}, "postgres"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, storageBackend(tc.cfg))
+		})
+	}
+}
+
+func TestEffectiveStoreFile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		backend string
+		cfg     config
+		want    string
+		wantLog string
+	}{
+		{
+			name:    "Memory",
+			backend: "memory",
+			cfg:     config{StoreFile: "/tmp/metrics-db.json"},
+			want:    "/tmp/metrics-db.json",
+		},
+		{
+			name:    "Postgres",
+			backend: "postgres",
+			cfg:     config{StoreFile: "/tmp/metrics-db.json"},
+			want:    "",
+			wantLog: "Metrics data file store is disabled with the database storage",
+		},
+		{
+			name:    "PostgresForced",
+			backend: "postgres",
+			cfg:     config{StoreFile: "/tmp/metrics-db.json", FileStoreWithDB: true},
+			want:    "/tmp/metrics-db.json",
+			wantLog: "Metrics data file store is enabled with the database storage",
+		},
+		{
+			name:    "SQLite",
+			backend: "sqlite",
+			cfg:     config{StoreFile: "/tmp/metrics-db.json"},
+			want:    "",
+			wantLog: "Metrics data file store is disabled with the database storage",
+		},
+		{
+			name:    "NoStoreFile",
+			backend: "postgres",
+			cfg:     config{},
+			want:    "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zap.InfoLevel)
+
+			assert.Equal(t, tc.want, effectiveStoreFile(zap.New(core), tc.cfg, tc.backend))
+
+			if tc.wantLog == "" {
+				assert.Zero(t, logs.Len())
+
+				return
+			}
+
+			assert.Equal(t, 1, logs.FilterMessage(tc.wantLog).Len())
+		})
+	}
+}
+
+func TestLogStartupSummaryStoreFile(t *testing.T) {
+	testCases := []struct {
+		name      string
+		storeFile string
+		restore   bool
+	}{
+		{"FileStore", "/tmp/metrics-db.json", true},
+		{"FileStoreDisabled", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zap.InfoLevel)
+
+			cfg := config{StoreFile: "/tmp/metrics-db.json", RestoreOnBoot: true}
+
+			logStartupSummary(zap.New(core), cfg, "postgres", tc.storeFile)
+
+			require.Equal(t, 1, logs.Len())
+
+			fields := logs.All()[0].ContextMap()
+
+			assert.Equal(t, tc.storeFile, fields["store_file"])
+			assert.Equal(t, tc.restore, fields["restore"])
+		})
+	}
+}
+
+func TestPublishLastWriteAge(t *testing.T) {
+	first := storage.NewStorage(storage.NewMemStorage())
+	second := storage.NewStorage(storage.NewMemStorage())
+
+	// The expvar is published once and reports the storage of the last server.
+	publishLastWriteAge(first)
+	publishLastWriteAge(second)
+
+	age, ok := expvar.Get(storage.LastWriteAgeMetric).(expvar.Func)
+	require.True(t, ok)
+	assert.Less(t, age.Value(), time.Minute.Seconds())
+	assert.Same(t, second, lastWriteAgeTracker.Load())
+}
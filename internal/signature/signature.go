@@ -1,7 +1,9 @@
-// Package signature provides functions to calculate SHA256 hash sum with a key.
+// Package signature provides functions to calculate SHA256 hash sum with a key
+// and to sign payloads with ed25519 keys.
 package signature
 
 import (
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/sha256"
 	"fmt"
@@ -17,3 +19,13 @@ func CalculateHashSum(key, payload []byte) ([]byte, error) {
 
 	return h.Sum(nil), nil
 }
+
+// SignEd25519 signs the payload with the ed25519 private key.
+func SignEd25519(key ed25519.PrivateKey, payload []byte) []byte {
+	return ed25519.Sign(key, payload)
+}
+
+// VerifyEd25519 reports whether sign is a valid ed25519 signature of the payload.
+func VerifyEd25519(key ed25519.PublicKey, payload, sign []byte) bool {
+	return len(key) == ed25519.PublicKeySize && ed25519.Verify(key, payload, sign)
+}
@@ -1,6 +1,7 @@
 package signature
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"math/big"
 	"testing"
@@ -8,6 +9,7 @@ import (
 	"crypto/rand"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func generateRandomBytes(length int) ([]byte, error) {
@@ -63,3 +65,20 @@ func ExampleCalculateHashSum() { //nolint:testableexamples
 		panic(err)
 	}
 }
+
+func TestVerifyEd25519(t *testing.T) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	otherPubKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	payload := []byte("value")
+	sign := SignEd25519(privKey, payload)
+
+	assert.True(t, VerifyEd25519(pubKey, payload, sign))
+	assert.False(t, VerifyEd25519(pubKey, []byte("other value"), sign))
+	assert.False(t, VerifyEd25519(otherPubKey, payload, sign))
+	assert.False(t, VerifyEd25519(pubKey, payload, nil))
+	assert.False(t, VerifyEd25519(nil, payload, sign))
+}
@@ -0,0 +1,228 @@
+// Package statsd provides a StatsD UDP listener storing the received metrics.
+package statsd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+	"github.com/andymarkow/go-metrics-collector/internal/storage"
+)
+
+// maxDatagramSize is the maximum size of the UDP datagram read.
+const maxDatagramSize = 65535
+
+// StatsD listener stats exposed via the expvar stats endpoint.
+var (
+	receivedMetrics    = expvar.NewInt("statsd_received_metrics")
+	malformedDatagrams = expvar.NewInt("statsd_malformed_datagrams")
+)
+
+var (
+	errMalformedMetric = errors.New("malformed metric")
+	errUnsupportedType = errors.New("unsupported metric type")
+	errRelativeGauge   = errors.New("relative gauge updates are not supported")
+)
+
+// Listener receives the StatsD metrics over UDP and writes them to the storage.
+//
+// The gauges ("name:value|g") and the counters ("name:value|c", optionally
+// sampled with "|@rate") are supported. A datagram may hold several metrics
+// separated by newlines. The signed gauge values are relative updates in
+// StatsD, which are not supported, so such gauges are rejected.
+type Listener struct {
+	log     *zap.Logger
+	storage storage.Storage
+	addr    string
+
+	maxNameLength int
+}
+
+// NewListener creates a new Listener instance listening on the UDP address.
+func NewListener(strg storage.Storage, addr string, opts ...Option) *Listener {
+	l := &Listener{
+		log:           zap.NewNop(),
+		storage:       strg,
+		addr:          addr,
+		maxNameLength: models.DefaultMaxNameLength,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Option represents a StatsD listener option.
+type Option func(l *Listener)
+
+// WithLogger sets the logger for the StatsD listener.
+func WithLogger(logger *zap.Logger) Option {
+	return func(l *Listener) {
+		l.log = logger
+	}
+}
+
+// WithMaxNameLength limits the length of the metric names in bytes. The
+// default length is used if n is not positive.
+func WithMaxNameLength(n int) Option {
+	return func(l *Listener) {
+		if n > 0 {
+			l.maxNameLength = n
+		}
+	}
+}
+
+// Run listens for the StatsD datagrams until ctx is done.
+func (l *Listener) Run(ctx context.Context, wg *sync.WaitGroup) error {
+	defer wg.Done()
+
+	conn, err := net.ListenPacket("udp", l.addr)
+	if err != nil {
+		return fmt.Errorf("net.ListenPacket: %w", err)
+	}
+
+	l.log.Sugar().Infof("Listening for StatsD metrics on %s", conn.LocalAddr().String())
+
+	return l.serve(ctx, conn)
+}
+
+// serve reads the datagrams from the connection until ctx is done and closes
+// the connection.
+func (l *Listener) serve(ctx context.Context, conn net.PacketConn) error {
+	// The connection is closed to unblock the pending read on shutdown.
+	stop := context.AfterFunc(ctx, func() {
+		if err := conn.Close(); err != nil {
+			l.log.Error("conn.Close", zap.Error(err))
+		}
+	})
+	defer stop()
+
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				l.log.Info("Stopping StatsD listener")
+
+				return nil
+			}
+
+			return fmt.Errorf("conn.ReadFrom: %w", err)
+		}
+
+		if err := l.handleDatagram(ctx, buf[:n]); err != nil {
+			malformedDatagrams.Add(1)
+
+			l.log.Warn("Malformed StatsD datagram",
+				zap.String("remote_addr", addr.String()),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// handleDatagram parses the datagram and stores its metrics. The datagram is
+// rejected entirely if any of its metrics is malformed.
+func (l *Listener) handleDatagram(ctx context.Context, datagram []byte) error {
+	var metrics []models.Metrics
+
+	for _, line := range bytes.Split(datagram, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		metric, err := parseMetric(string(line), l.maxNameLength)
+		if err != nil {
+			return err
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if err := l.storage.SetMetrics(ctx, metrics); err != nil {
+		// The storage failure is not the datagram's fault, so it is not
+		// reported as malformed.
+		l.log.Error("storage.SetMetrics", zap.Error(err))
+
+		return nil
+	}
+
+	receivedMetrics.Add(int64(len(metrics)))
+
+	return nil
+}
+
+// parseMetric parses a single StatsD metric, e.g. "requests:1|c|@0.1".
+//
+// The sampled counter value is scaled by the sample rate and rounded to an
+// integer, as the counters are integer. The name is limited to maxNameLength
+// bytes.
+func parseMetric(line string, maxNameLength int) (models.Metrics, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return models.Metrics{}, fmt.Errorf("%w: %q", errMalformedMetric, line)
+	}
+
+	if err := models.ValidateName(name, maxNameLength); err != nil {
+		return models.Metrics{}, fmt.Errorf("%w: %q: %w", errMalformedMetric, line, err)
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 || len(parts) > 3 {
+		return models.Metrics{}, fmt.Errorf("%w: %q", errMalformedMetric, line)
+	}
+
+	value, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return models.Metrics{}, fmt.Errorf("%w: %q: invalid value", errMalformedMetric, line)
+	}
+
+	rate := 1.0
+
+	if len(parts) == 3 {
+		s, ok := strings.CutPrefix(parts[2], "@")
+		if !ok {
+			return models.Metrics{}, fmt.Errorf("%w: %q", errMalformedMetric, line)
+		}
+
+		rate, err = strconv.ParseFloat(s, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return models.Metrics{}, fmt.Errorf("%w: %q: invalid sample rate", errMalformedMetric, line)
+		}
+	}
+
+	switch parts[1] {
+	case "g":
+		if strings.HasPrefix(parts[0], "+") || strings.HasPrefix(parts[0], "-") {
+			return models.Metrics{}, fmt.Errorf("%w: %q", errRelativeGauge, line)
+		}
+
+		return models.Metrics{ID: name, MType: "gauge", Value: &value}, nil
+
+	case "c":
+		delta := int64(math.Round(value / rate))
+
+		return models.Metrics{ID: name, MType: "counter", Delta: &delta}, nil
+
+	default:
+		return models.Metrics{}, fmt.Errorf("%w: %q", errUnsupportedType, parts[1])
+	}
+}
@@ -0,0 +1,128 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+	"github.com/andymarkow/go-metrics-collector/internal/storage"
+)
+
+func TestParseMetric(t *testing.T) {
+	gauge := 1.5
+	delta := int64(3)
+	sampled := int64(10)
+
+	tests := []struct {
+		name    string
+		line    string
+		want    models.Metrics
+		wantErr error
+	}{
+		{name: "Gauge", line: "HeapAlloc:1.5|g", want: models.Metrics{ID: "HeapAlloc", MType: "gauge", Value: &gauge}},
+		{name: "Counter", line: "PollCount:3|c", want: models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}},
+		{name: "SampledCounter", line: "PollCount:1|c|@0.1", want: models.Metrics{ID: "PollCount", MType: "counter", Delta: &sampled}},
+		{name: "NoValue", line: "PollCount", wantErr: errMalformedMetric},
+		{name: "NoType", line: "PollCount:1", wantErr: errMalformedMetric},
+		{name: "EmptyName", line: ":1|c", wantErr: errMalformedMetric},
+		{name: "LongName", line: strings.Repeat("a", models.DefaultMaxNameLength+1) + ":1|c", wantErr: errMalformedMetric},
+		{name: "InvalidValue", line: "PollCount:abc|c", wantErr: errMalformedMetric},
+		{name: "InvalidRate", line: "PollCount:1|c|@2", wantErr: errMalformedMetric},
+		{name: "Timer", line: "Latency:320|ms", wantErr: errUnsupportedType},
+		{name: "RelativeGauge", line: "HeapAlloc:-1|g", wantErr: errRelativeGauge},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseMetric(tc.line, models.DefaultMaxNameLength)
+			if tc.wantErr != nil {
+				require.ErrorIs(t, err, tc.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	strg := storage.NewMemStorage()
+
+	core, logs := observer.New(zap.WarnLevel)
+
+	l := NewListener(strg, "127.0.0.1:0", WithLogger(zap.New(core)))
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- l.serve(ctx, conn)
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, client.Close())
+	}()
+
+	malformed := malformedDatagrams.Value()
+
+	for _, datagram := range []string{
+		"PollCount:2|c\nHeapAlloc:1.5|g",
+		"PollCount:oops|c",
+		"PollCount:3|c",
+	} {
+		_, err := client.Write([]byte(datagram))
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		cnt, err := strg.GetCounter(context.Background(), "PollCount")
+
+		return err == nil && cnt == 5
+	}, time.Second, 10*time.Millisecond)
+
+	gauge, err := strg.GetGauge(context.Background(), "HeapAlloc")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, gauge, 0)
+
+	// The malformed datagram is counted and logged, and the listener goes on.
+	assert.Equal(t, malformed+1, malformedDatagrams.Value())
+	assert.Equal(t, 1, logs.FilterMessage("Malformed StatsD datagram").Len())
+
+	cancel()
+
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("listener did not stop")
+	}
+}
+
+func TestListenerRunInvalidAddr(t *testing.T) {
+	l := NewListener(storage.NewMemStorage(), "invalid")
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+
+	require.Error(t, l.Run(context.Background(), wg))
+
+	wg.Wait()
+}
@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+)
+
+// GaugeAggregator implements the Storage interface by wrapping another Storage.
+var _ Storage = (*GaugeAggregator)(nil)
+
+// AggregateMode represents a gauge aggregation mode.
+type AggregateMode string
+
+const (
+	AggregateAvg  AggregateMode = "avg"
+	AggregateMin  AggregateMode = "min"
+	AggregateMax  AggregateMode = "max"
+	AggregateLast AggregateMode = "last"
+)
+
+// ParseAggregateMode parses the gauge aggregation mode from string.
+func ParseAggregateMode(s string) (AggregateMode, error) {
+	switch mode := AggregateMode(s); mode {
+	case AggregateAvg, AggregateMin, AggregateMax, AggregateLast:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("invalid aggregate mode: %q", s)
+}
+
+// gaugeAggregate holds the gauge values written within the current window.
+type gaugeAggregate struct {
+	sum   float64
+	min   float64
+	max   float64
+	last  float64
+	count int
+}
+
+func (a *gaugeAggregate) add(value float64) {
+	a.sum += value
+	a.min = math.Min(a.min, value)
+	a.max = math.Max(a.max, value)
+	a.last = value
+	a.count++
+}
+
+// merge adds the values of the newer aggregate of the same gauge.
+func (a *gaugeAggregate) merge(newer *gaugeAggregate) {
+	a.sum += newer.sum
+	a.min = math.Min(a.min, newer.min)
+	a.max = math.Max(a.max, newer.max)
+	a.last = newer.last
+	a.count += newer.count
+}
+
+func (a *gaugeAggregate) value(mode AggregateMode) float64 {
+	switch mode {
+	case AggregateAvg:
+		return a.sum / float64(a.count)
+	case AggregateMin:
+		return a.min
+	case AggregateMax:
+		return a.max
+	}
+
+	return a.last
+}
+
+// GaugeAggregator is a Storage decorator that aggregates gauge writes within
+// a time window and flushes the aggregated values to the underlying storage.
+type GaugeAggregator struct {
+	Storage
+	log    *zap.Logger
+	gauges map[string]*gaugeAggregate
+	mode   AggregateMode
+	window time.Duration
+	mu     sync.Mutex
+}
+
+// NewGaugeAggregator creates a new GaugeAggregator instance wrapping the given storage.
+func NewGaugeAggregator(strg Storage, opts ...AggregatorOption) *GaugeAggregator {
+	a := &GaugeAggregator{
+		Storage: strg,
+		log:     zap.NewNop(),
+		gauges:  make(map[string]*gaugeAggregate),
+		mode:    AggregateLast,
+		window:  10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// AggregatorOption represents a gauge aggregator option.
+type AggregatorOption func(a *GaugeAggregator)
+
+// WithAggregatorLogger sets the logger for the gauge aggregator.
+func WithAggregatorLogger(logger *zap.Logger) AggregatorOption {
+	return func(a *GaugeAggregator) {
+		a.log = logger
+	}
+}
+
+// WithAggregateMode sets the gauge aggregation mode.
+func WithAggregateMode(mode AggregateMode) AggregatorOption {
+	return func(a *GaugeAggregator) {
+		a.mode = mode
+	}
+}
+
+// WithAggregateWindow sets the window the gauge values are aggregated within.
+func WithAggregateWindow(window time.Duration) AggregatorOption {
+	return func(a *GaugeAggregator) {
+		a.window = window
+	}
+}
+
+// GetGauge returns the aggregated value of the current window if there is one,
+// otherwise the value from the underlying storage.
+func (a *GaugeAggregator) GetGauge(ctx context.Context, name string) (float64, error) {
+	var value float64
+
+	a.mu.Lock()
+	agg, ok := a.gauges[name]
+	if ok {
+		value = agg.value(a.mode)
+	}
+	a.mu.Unlock()
+
+	if ok {
+		return value, nil
+	}
+
+	return a.Storage.GetGauge(ctx, name) //nolint:wrapcheck
+}
+
+// SetGauge adds the gauge value to the current window aggregate.
+func (a *GaugeAggregator) SetGauge(ctx context.Context, name string, value float64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	agg, ok := a.gauges[name]
+	if !ok {
+		// Check the metric type only once per window.
+		if _, err := a.Storage.GetGauge(ctx, name); err != nil && !errors.Is(err, ErrMetricNotFound) {
+			return fmt.Errorf("storage.GetGauge: %w", err)
+		}
+
+		agg = &gaugeAggregate{min: value, max: value}
+		a.gauges[name] = agg
+	}
+
+	agg.add(value)
+
+	return nil
+}
+
+// SetMetrics adds the gauge values to the current window aggregates and
+// passes the rest of the metrics to the underlying storage.
+func (a *GaugeAggregator) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+	if err := validateMetrics(metrics); err != nil {
+		return err
+	}
+
+	rest := make([]models.Metrics, 0, len(metrics))
+
+	for _, metric := range metrics {
+		if metric.MType != "gauge" {
+			rest = append(rest, metric)
+
+			continue
+		}
+
+		if err := a.SetGauge(ctx, metric.ID, *metric.Value); err != nil {
+			return fmt.Errorf("failed to set metric (%s): %w", metric.ID, err)
+		}
+	}
+
+	if len(rest) == 0 {
+		return nil
+	}
+
+	return a.Storage.SetMetrics(ctx, rest) //nolint:wrapcheck
+}
+
+// DeleteMetric drops the current window aggregate of the gauge and removes
+// the metric from the underlying storage.
+func (a *GaugeAggregator) DeleteMetric(ctx context.Context, metricType, name string) error {
+	var pending bool
+
+	if metricType == "gauge" {
+		a.mu.Lock()
+		_, pending = a.gauges[name]
+		delete(a.gauges, name)
+		a.mu.Unlock()
+	}
+
+	err := a.Storage.DeleteMetric(ctx, metricType, name)
+	if pending && errors.Is(err, ErrMetricNotFound) {
+		return nil
+	}
+
+	return err //nolint:wrapcheck
+}
+
+// Flush writes the aggregated gauge values to the underlying storage and
+// starts a new window.
+//
+// The aggregates failed to be written are merged back into the new window,
+// so they are retried with the next flush instead of being lost.
+func (a *GaugeAggregator) Flush(ctx context.Context) error {
+	a.mu.Lock()
+	gauges := a.gauges
+	a.gauges = make(map[string]*gaugeAggregate, len(gauges))
+	a.mu.Unlock()
+
+	failed := make(map[string]*gaugeAggregate)
+
+	var errs []error
+
+	for name, agg := range gauges {
+		if err := a.Storage.SetGauge(ctx, name, agg.value(a.mode)); err != nil {
+			failed[name] = agg
+			errs = append(errs, fmt.Errorf("storage.SetGauge(%s): %w", name, err))
+		}
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for name, agg := range failed {
+		// The gauge may have been written again since the window swap.
+		if newer, ok := a.gauges[name]; ok {
+			agg.merge(newer)
+		}
+
+		a.gauges[name] = agg
+	}
+
+	return errors.Join(errs...)
+}
+
+// RunFlusher periodically flushes the aggregated gauge values until ctx is done.
+func (a *GaugeAggregator) RunFlusher(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	a.log.Sugar().Infof("Aggregating gauges by %s every %s", a.mode, a.window.String())
+
+	ticker := time.NewTicker(a.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			a.log.Info("Flushing aggregated gauges")
+
+			if err := a.Flush(context.Background()); err != nil {
+				a.log.Error("failed to flush aggregated gauges", zap.Error(err))
+			}
+
+			return
+
+		case <-ticker.C:
+			if err := a.Flush(ctx); err != nil {
+				a.log.Error("failed to flush aggregated gauges", zap.Error(err))
+			}
+		}
+	}
+}
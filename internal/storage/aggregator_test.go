@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGaugeAggregator(t *testing.T) {
+	testCases := []struct {
+		name string
+		mode AggregateMode
+		want float64
+	}{
+		{"Avg", AggregateAvg, 2.5},
+		{"Min", AggregateMin, 1},
+		{"Max", AggregateMax, 4},
+		{"Last", AggregateLast, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			strg := NewMemStorage()
+			agg := NewGaugeAggregator(strg, WithAggregateMode(tc.mode))
+
+			for _, v := range []float64{3, 2, 4, 1} {
+				require.NoError(t, agg.SetGauge(ctx, "testGauge", v))
+			}
+
+			// Nothing is written to the underlying storage until flushed.
+			_, err := strg.GetGauge(ctx, "testGauge")
+			require.ErrorIs(t, err, ErrMetricNotFound)
+
+			val, err := agg.GetGauge(ctx, "testGauge")
+			require.NoError(t, err)
+			assert.InDelta(t, tc.want, val, 0)
+
+			require.NoError(t, agg.Flush(ctx))
+
+			val, err = strg.GetGauge(ctx, "testGauge")
+			require.NoError(t, err)
+			assert.InDelta(t, tc.want, val, 0)
+		})
+	}
+}
+
+func TestGaugeAggregatorTypeMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewMemStorage()
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 1))
+
+	agg := NewGaugeAggregator(strg)
+
+	require.ErrorIs(t, agg.SetGauge(ctx, "testCounter", 1), ErrMetricIsNotGauge)
+}
+
+// failingGaugeStorage is a storage failing to set the gauges of the given name.
+type failingGaugeStorage struct {
+	Storage
+	name string
+}
+
+func (s *failingGaugeStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	if name == s.name {
+		return errors.New("storage unavailable")
+	}
+
+	return s.Storage.SetGauge(ctx, name, value) //nolint:wrapcheck
+}
+
+func TestGaugeAggregatorFlushFailure(t *testing.T) {
+	ctx := context.Background()
+
+	strg := &failingGaugeStorage{Storage: NewMemStorage(), name: "failingGauge"}
+	agg := NewGaugeAggregator(strg, WithAggregateMode(AggregateMax))
+
+	require.NoError(t, agg.SetGauge(ctx, "failingGauge", 5))
+	require.NoError(t, agg.SetGauge(ctx, "testGauge", 1))
+
+	require.ErrorContains(t, agg.Flush(ctx), "failingGauge")
+
+	// The other aggregates are still written.
+	val, err := strg.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 1, val, 0)
+
+	// The failed aggregate is kept and merged with the new window values.
+	require.NoError(t, agg.SetGauge(ctx, "failingGauge", 3))
+
+	strg.name = ""
+
+	require.NoError(t, agg.Flush(ctx))
+
+	val, err = strg.GetGauge(ctx, "failingGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 5, val, 0)
+}
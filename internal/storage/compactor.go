@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// compactedMetrics is the number of stale metrics deleted by the compactor
+// exposed via the expvar stats endpoint.
+var compactedMetrics = expvar.NewInt("storage_compacted_metrics")
+
+// Compact deletes the gauges not updated within the retention window.
+// Counters are deleted as well if withCounters is true.
+//
+// It returns the number of deleted metrics.
+func (pg *PostgresStorage) Compact(ctx context.Context, retention time.Duration, withCounters bool) (int64, error) {
+	queries := []string{"DELETE FROM metric_gauges WHERE updated_at < $1;"}
+
+	if withCounters {
+		queries = append(queries, "DELETE FROM metric_counters WHERE updated_at < $1;")
+	}
+
+	deadline := time.Now().Add(-retention)
+
+	var deleted int64
+
+	for _, query := range queries {
+		err := WithRetry(func() error {
+			res, err := pg.db.ExecContext(ctx, query, deadline)
+			if err != nil {
+				return fmt.Errorf("db.ExecContext: %w", err)
+			}
+
+			rows, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("res.RowsAffected: %w", err)
+			}
+
+			deleted += rows
+
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// Compactor periodically deletes the stale metrics from the Postgres storage.
+type Compactor struct {
+	log          *zap.Logger
+	storage      *PostgresStorage
+	interval     time.Duration
+	retention    time.Duration
+	withCounters bool
+}
+
+// NewCompactor creates a new Compactor instance for the given storage.
+func NewCompactor(pg *PostgresStorage, retention time.Duration, opts ...CompactorOption) *Compactor {
+	c := &Compactor{
+		log:       zap.NewNop(),
+		storage:   pg,
+		interval:  time.Hour,
+		retention: retention,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// CompactorOption represents a compactor option.
+type CompactorOption func(c *Compactor)
+
+// WithCompactorLogger sets the logger for the compactor.
+func WithCompactorLogger(logger *zap.Logger) CompactorOption {
+	return func(c *Compactor) {
+		c.log = logger
+	}
+}
+
+// WithCompactInterval sets the interval between the compactions.
+func WithCompactInterval(interval time.Duration) CompactorOption {
+	return func(c *Compactor) {
+		c.interval = interval
+	}
+}
+
+// WithCompactCounters sets whether or not the stale counters are deleted as well.
+// Counters are exempt from the compaction by default.
+func WithCompactCounters(withCounters bool) CompactorOption {
+	return func(c *Compactor) {
+		c.withCounters = withCounters
+	}
+}
+
+// RunCompactor periodically compacts the storage until ctx is done.
+func (c *Compactor) RunCompactor(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	c.log.Sugar().Infof("Compacting metrics not updated within %s every %s", c.retention.String(), c.interval.String())
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.log.Info("Stopping metrics compactor")
+
+			return
+
+		case <-ticker.C:
+			deleted, err := c.storage.Compact(ctx, c.retention, c.withCounters)
+			compactedMetrics.Add(deleted)
+
+			if err != nil {
+				c.log.Error("failed to compact metrics", zap.Error(err))
+
+				continue
+			}
+
+			c.log.Sugar().Infof("Compacted %d stale metrics", deleted)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// DefaultHistogramBuckets are the default histogram buckets upper bounds.
+var DefaultHistogramBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramValue is a histogram of the observed values.
+//
+// Counts holds the number of observations within each of the buckets,
+// i.e. greater than the previous bucket upper bound and less or equal
+// to the bucket upper bound. The observations greater than the last upper
+// bound are accounted in Count only.
+type HistogramValue struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Sum     float64   `json:"sum"`
+	Count   uint64    `json:"count"`
+}
+
+// newHistogramValue creates an empty histogram with the given buckets upper bounds.
+func newHistogramValue(buckets []float64) HistogramValue {
+	return HistogramValue{
+		Buckets: slices.Clone(buckets),
+		Counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// observe returns a copy of the histogram with the value observed.
+//
+// The histogram is copied since the stored metrics are shared with the
+// callers of GetAllMetrics.
+func (v HistogramValue) observe(value float64) HistogramValue {
+	h := HistogramValue{
+		Buckets: v.Buckets,
+		Counts:  slices.Clone(v.Counts),
+		Sum:     v.Sum + value,
+		Count:   v.Count + 1,
+	}
+
+	if i, _ := slices.BinarySearch(h.Buckets, value); i < len(h.Buckets) {
+		h.Counts[i]++
+	}
+
+	return h
+}
+
+// CumulativeCounts returns the number of observations less or equal to
+// each of the buckets upper bounds.
+func (v HistogramValue) CumulativeCounts() []uint64 {
+	counts := make([]uint64, len(v.Counts))
+
+	var total uint64
+
+	for i, c := range v.Counts {
+		total += c
+		counts[i] = total
+	}
+
+	return counts
+}
+
+func (v HistogramValue) String() string {
+	return fmt.Sprintf("count=%d sum=%s", v.Count, strconv.FormatFloat(v.Sum, 'f', -1, 64))
+}
+
+// loadHistogramValue converts a decoded JSON histogram value to HistogramValue.
+func loadHistogramValue(value any) (HistogramValue, error) {
+	var h HistogramValue
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return h, fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &h); err != nil {
+		return h, fmt.Errorf("invalid histogram value: %w", err)
+	}
+
+	if len(h.Counts) != len(h.Buckets) || !slices.IsSorted(h.Buckets) {
+		return h, fmt.Errorf("invalid histogram value: %d counts for %d buckets", len(h.Counts), len(h.Buckets))
+	}
+
+	return h, nil
+}
@@ -2,10 +2,16 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"maps"
+	"math"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
 	"github.com/andymarkow/go-metrics-collector/internal/models"
 	"github.com/andymarkow/go-metrics-collector/internal/monitor"
 )
@@ -13,8 +19,9 @@ import (
 var _ Storage = (*MemStorage)(nil)
 
 type Metric struct {
-	Value any                `json:"value"`
-	Type  monitor.MetricType `json:"type"`
+	Value     any                `json:"value"`
+	Type      monitor.MetricType `json:"type"`
+	UpdatedAt time.Time          `json:"updated_at"`
 }
 
 func (m *Metric) StringValue() string {
@@ -23,6 +30,8 @@ func (m *Metric) StringValue() string {
 		return v.String()
 	case GaugeValue:
 		return v.String()
+	case HistogramValue:
+		return v.String()
 	}
 
 	return fmt.Sprintf("%v", m.Value)
@@ -41,13 +50,17 @@ func (v GaugeValue) String() string {
 }
 
 type MemStorage struct {
-	data map[string]Metric
-	mu   sync.RWMutex
+	data    map[string]Metric
+	buckets []float64
+	mu      sync.RWMutex
 }
 
-func NewMemStorage() *MemStorage {
+func NewMemStorage(opts ...Option) *MemStorage {
+	options := newOptions(opts...)
+
 	return &MemStorage{
-		data: make(map[string]Metric),
+		data:    make(map[string]Metric),
+		buckets: options.buckets,
 	}
 }
 
@@ -59,11 +72,52 @@ func (s *MemStorage) Ping(_ context.Context) error {
 	return nil
 }
 
+// HealthCheck always succeeds, as the memory storage is always available.
+func (s *MemStorage) HealthCheck(_ context.Context) error {
+	return nil
+}
+
+// GetAllMetrics returns a copy of the stored metrics, so callers can range
+// over it without holding the lock.
 func (s *MemStorage) GetAllMetrics(_ context.Context) (map[string]Metric, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return s.data, nil
+	return maps.Clone(s.data), nil
+}
+
+// GetMetricsByPrefix returns the metrics which names start with the prefix.
+func (s *MemStorage) GetMetricsByPrefix(_ context.Context, prefix string) (map[string]Metric, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data := make(map[string]Metric)
+
+	for name, metric := range s.data {
+		if strings.HasPrefix(name, prefix) {
+			data[name] = metric
+		}
+	}
+
+	return data, nil
+}
+
+// GetStaleMetrics returns the metrics not updated within the olderThan window.
+func (s *MemStorage) GetStaleMetrics(_ context.Context, olderThan time.Duration) (map[string]Metric, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deadline := time.Now().Add(-olderThan)
+
+	data := make(map[string]Metric)
+
+	for name, metric := range s.data {
+		if metric.UpdatedAt.Before(deadline) {
+			data[name] = metric
+		}
+	}
+
+	return data, nil
 }
 
 func (s *MemStorage) GetCounter(_ context.Context, name string) (int64, error) {
@@ -85,25 +139,39 @@ func (s *MemStorage) SetCounter(_ context.Context, name string, value int64) err
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if metric, ok := s.data[name]; ok {
-		if v, ok := metric.Value.(CounterValue); ok {
-			s.data[name] = Metric{
-				Type:  monitor.MetricCounter,
-				Value: CounterValue(int64(v) + value),
-			}
+	metric, ok := s.data[name]
 
-			return nil
+	metric, err := addCounter(metric, ok, value)
+	if err != nil {
+		return err
+	}
+
+	s.data[name] = metric
+
+	return nil
+}
+
+// addCounter returns the counter metric with the value added. The addition
+// overflowing int64 is rejected rather than wrapping the counter around.
+func addCounter(metric Metric, exists bool, value int64) (Metric, error) {
+	if exists {
+		v, ok := metric.Value.(CounterValue)
+		if !ok {
+			return Metric{}, ErrMetricIsNotCounter
 		}
 
-		return ErrMetricIsNotCounter
-	}
+		if (value > 0 && int64(v) > math.MaxInt64-value) || (value < 0 && int64(v) < math.MinInt64-value) {
+			return Metric{}, fmt.Errorf("%w: %d + %d", errormsg.ErrMetricCounterOverflow, int64(v), value)
+		}
 
-	s.data[name] = Metric{
-		Type:  monitor.MetricCounter,
-		Value: CounterValue(value),
+		value += int64(v)
 	}
 
-	return nil
+	return Metric{
+		Type:      monitor.MetricCounter,
+		Value:     CounterValue(value),
+		UpdatedAt: time.Now(),
+	}, nil
 }
 
 func (s *MemStorage) GetGauge(_ context.Context, name string) (float64, error) {
@@ -125,38 +193,197 @@ func (s *MemStorage) SetGauge(_ context.Context, name string, value float64) err
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if metric, ok := s.data[name]; ok {
+	metric, ok := s.data[name]
+
+	metric, err := setGauge(metric, ok, value)
+	if err != nil {
+		return err
+	}
+
+	s.data[name] = metric
+
+	return nil
+}
+
+// setGauge returns the gauge metric with the value replaced.
+func setGauge(metric Metric, exists bool, value float64) (Metric, error) {
+	if exists {
 		if _, ok := metric.Value.(GaugeValue); !ok {
+			return Metric{}, ErrMetricIsNotGauge
+		}
+	}
+
+	return Metric{
+		Type:      monitor.MetricGauge,
+		Value:     GaugeValue(value),
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+// SetGaugeMax stores the gauge value only if it is greater than the current one.
+func (s *MemStorage) SetGaugeMax(_ context.Context, name string, value float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if metric, ok := s.data[name]; ok {
+		v, ok := metric.Value.(GaugeValue)
+		if !ok {
 			return ErrMetricIsNotGauge
 		}
+
+		if float64(v) >= value {
+			// The metric is still reported, though its value is kept.
+			metric.UpdatedAt = time.Now()
+			s.data[name] = metric
+
+			return nil
+		}
 	}
 
 	s.data[name] = Metric{
-		Type:  monitor.MetricGauge,
-		Value: GaugeValue(value),
+		Type:      monitor.MetricGauge,
+		Value:     GaugeValue(value),
+		UpdatedAt: time.Now(),
+	}
+
+	return nil
+}
+
+// ObserveHistogram adds the value to the histogram buckets.
+func (s *MemStorage) ObserveHistogram(_ context.Context, name string, value float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metric, ok := s.data[name]
+
+	metric, err := s.observeHistogram(metric, ok, value)
+	if err != nil {
+		return err
 	}
 
+	s.data[name] = metric
+
 	return nil
 }
 
-func (s *MemStorage) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+// observeHistogram returns the histogram metric with the value observed.
+func (s *MemStorage) observeHistogram(metric Metric, exists bool, value float64) (Metric, error) {
+	h := newHistogramValue(s.buckets)
+
+	if exists {
+		v, ok := metric.Value.(HistogramValue)
+		if !ok {
+			return Metric{}, ErrMetricIsNotHistogram
+		}
+
+		h = v
+	}
+
+	return Metric{
+		Type:      monitor.MetricHistogram,
+		Value:     h.observe(value),
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+func (s *MemStorage) GetHistogram(_ context.Context, name string) (HistogramValue, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if metric, ok := s.data[name]; ok {
+		if v, ok := metric.Value.(HistogramValue); ok {
+			return v, nil
+		}
+
+		return HistogramValue{}, ErrMetricIsNotHistogram
+	}
+
+	return HistogramValue{}, ErrMetricNotFound
+}
+
+// SetMetrics stores the metrics as a whole: if any of them fails, none is
+// stored. The changes are staged and applied under a single lock, so the
+// concurrent readers never see the batch applied partially.
+func (s *MemStorage) SetMetrics(_ context.Context, metrics []models.Metrics) error {
+	if err := validateMetrics(metrics); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	staged := make(map[string]Metric, len(metrics))
+
 	for _, metric := range metrics {
+		cur, ok := staged[metric.ID]
+		if !ok {
+			cur, ok = s.data[metric.ID]
+		}
+
+		var next Metric
+		var err error
+
 		switch metric.MType {
 		case "counter":
-			if err := s.SetCounter(ctx, metric.ID, *metric.Delta); err != nil {
-				return fmt.Errorf("failed to set metric (%s): %w", metric.ID, err)
-			}
-
+			next, err = addCounter(cur, ok, *metric.Delta)
 		case "gauge":
-			if err := s.SetGauge(ctx, metric.ID, *metric.Value); err != nil {
-				return fmt.Errorf("failed to set metric (%s): %w", metric.ID, err)
-			}
+			next, err = setGauge(cur, ok, *metric.Value)
+		case "histogram":
+			next, err = s.observeHistogram(cur, ok, *metric.Value)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to set metric (%s): %w", metric.ID, err)
 		}
+
+		staged[metric.ID] = next
+	}
+
+	maps.Copy(s.data, staged)
+
+	return nil
+}
+
+// DeleteMetric removes the metric of the given type from the storage.
+func (s *MemStorage) DeleteMetric(_ context.Context, metricType, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metric, ok := s.data[name]
+	if !ok || string(metric.Type) != metricType {
+		return ErrMetricNotFound
 	}
 
+	delete(s.data, name)
+
 	return nil
 }
 
+// ResetCounters sets all the counters to zero and returns the number of
+// counters reset. The gauges are left untouched.
+func (s *MemStorage) ResetCounters(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reset int64
+
+	for name, metric := range s.data {
+		if metric.Type != monitor.MetricCounter {
+			continue
+		}
+
+		s.data[name] = Metric{
+			Type:      metric.Type,
+			Value:     CounterValue(0),
+			UpdatedAt: metric.UpdatedAt,
+		}
+
+		reset++
+	}
+
+	return reset, nil
+}
+
 func (s *MemStorage) LoadData(_ context.Context, data map[string]Metric) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -164,25 +391,39 @@ func (s *MemStorage) LoadData(_ context.Context, data map[string]Metric) error {
 	for k, metric := range data {
 		switch metric.Type {
 		case monitor.MetricCounter:
-			v, ok := metric.Value.(float64)
-			if !ok {
-				return fmt.Errorf("failed load metric (%s): invalid value type (%T)", k, metric.Value)
+			v, err := loadCounterValue(metric.Value)
+			if err != nil {
+				return fmt.Errorf("failed load metric (%s): %w", k, err)
 			}
 
 			s.data[k] = Metric{
-				Type:  metric.Type,
-				Value: CounterValue(int64(v)),
+				Type:      metric.Type,
+				Value:     CounterValue(v),
+				UpdatedAt: metric.UpdatedAt,
 			}
 
 		case monitor.MetricGauge:
-			v, ok := metric.Value.(float64)
-			if !ok {
-				return fmt.Errorf("failed load metric (%s): invalid value type (%T)", k, metric.Value)
+			v, err := loadGaugeValue(metric.Value)
+			if err != nil {
+				return fmt.Errorf("failed load metric (%s): %w", k, err)
+			}
+
+			s.data[k] = Metric{
+				Type:      metric.Type,
+				Value:     GaugeValue(v),
+				UpdatedAt: metric.UpdatedAt,
+			}
+
+		case monitor.MetricHistogram:
+			v, err := loadHistogramValue(metric.Value)
+			if err != nil {
+				return fmt.Errorf("failed load metric (%s): %w", k, err)
 			}
 
 			s.data[k] = Metric{
-				Type:  metric.Type,
-				Value: GaugeValue(v),
+				Type:      metric.Type,
+				Value:     v,
+				UpdatedAt: metric.UpdatedAt,
 			}
 
 		default:
@@ -192,3 +433,49 @@ func (s *MemStorage) LoadData(_ context.Context, data map[string]Metric) error {
 
 	return nil
 }
+
+// maxSafeInteger is the largest integer a float64 represents exactly (2^53).
+const maxSafeInteger = 1 << 53
+
+// loadCounterValue converts a decoded JSON counter value to int64.
+//
+// Values decoded as json.Number are converted exactly, while float64 values
+// beyond the safe integer range are rejected as they have already lost precision.
+func loadCounterValue(value any) (int64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("invalid counter value (%s): %w", v, err)
+		}
+
+		return n, nil
+
+	case float64:
+		if v > maxSafeInteger || v < -maxSafeInteger {
+			return 0, fmt.Errorf("counter value (%v) exceeds safe integer range", v)
+		}
+
+		return int64(v), nil
+	}
+
+	return 0, fmt.Errorf("invalid value type (%T)", value)
+}
+
+// loadGaugeValue converts a decoded JSON gauge value to float64.
+func loadGaugeValue(value any) (float64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("invalid gauge value (%s): %w", v, err)
+		}
+
+		return f, nil
+
+	case float64:
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("invalid value type (%T)", value)
+}
@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+)
+
+func TestMemStorageSetCounterOverflow(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewMemStorage()
+
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", math.MaxInt64-1))
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 1))
+
+	err := strg.SetCounter(ctx, "testCounter", 1)
+	require.ErrorIs(t, err, errormsg.ErrMetricCounterOverflow)
+
+	// The counter is not wrapped around.
+	cnt, err := strg.GetCounter(ctx, "testCounter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), cnt)
+
+	require.NoError(t, strg.SetCounter(ctx, "testNegativeCounter", math.MinInt64+1))
+	require.ErrorIs(t, strg.SetCounter(ctx, "testNegativeCounter", -2), errormsg.ErrMetricCounterOverflow)
+
+	// The batch overflowing the counter is not stored at all.
+	delta := int64(1)
+	err = strg.SetMetrics(ctx, []models.Metrics{
+		{ID: "testBatchCounter", MType: "counter", Delta: &delta},
+		{ID: "testCounter", MType: "counter", Delta: &delta},
+	})
+	require.ErrorIs(t, err, errormsg.ErrMetricCounterOverflow)
+
+	_, err = strg.GetCounter(ctx, "testBatchCounter")
+	require.ErrorIs(t, err, ErrMetricNotFound)
+}
+
+func TestMemStorageSetGaugeMax(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewMemStorage()
+
+	require.NoError(t, strg.SetGaugeMax(ctx, "testGauge", 2.5))
+
+	val, err := strg.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 2.5, val, 0)
+
+	// Smaller value is ignored.
+	require.NoError(t, strg.SetGaugeMax(ctx, "testGauge", 1.5))
+
+	val, err = strg.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 2.5, val, 0)
+
+	// Greater value is stored.
+	require.NoError(t, strg.SetGaugeMax(ctx, "testGauge", 3.5))
+
+	val, err = strg.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 3.5, val, 0)
+
+	// Counter metric can not be updated as gauge.
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 1))
+	require.ErrorIs(t, strg.SetGaugeMax(ctx, "testCounter", 1), ErrMetricIsNotGauge)
+}
+
+func TestMemStorageLoadDataUnsafeCounter(t *testing.T) {
+	strg := NewMemStorage()
+
+	err := strg.LoadData(context.Background(), map[string]Metric{
+		"testCounter": {Type: "counter", Value: float64(1<<53 + 2)},
+	})
+	require.Error(t, err)
+}
+
+func TestMemStorageDeleteMetric(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewMemStorage()
+	require.NoError(t, strg.SetGauge(ctx, "testGauge", 1.5))
+
+	// Metric of another type is not deleted.
+	require.ErrorIs(t, strg.DeleteMetric(ctx, "counter", "testGauge"), ErrMetricNotFound)
+
+	require.NoError(t, strg.DeleteMetric(ctx, "gauge", "testGauge"))
+
+	_, err := strg.GetGauge(ctx, "testGauge")
+	require.ErrorIs(t, err, ErrMetricNotFound)
+
+	require.ErrorIs(t, strg.DeleteMetric(ctx, "gauge", "testGauge"), ErrMetricNotFound)
+}
+
+func TestMemStorageResetCounters(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewMemStorage()
+	require.NoError(t, strg.SetCounter(ctx, "testCounter1", 3))
+	require.NoError(t, strg.SetCounter(ctx, "testCounter2", 5))
+	require.NoError(t, strg.SetGauge(ctx, "testGauge", 1.5))
+
+	reset, err := strg.ResetCounters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), reset)
+
+	cnt, err := strg.GetCounter(ctx, "testCounter1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), cnt)
+
+	// Gauges are left untouched.
+	gauge, err := strg.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, gauge, 0)
+}
+
+func TestMemStorageGetAllMetricsConcurrent(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewMemStorage()
+
+	wg := &sync.WaitGroup{}
+
+	for i := range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range 100 {
+				name := "testGauge" + strconv.Itoa(i*100+j)
+
+				assert.NoError(t, strg.SetGauge(ctx, name, float64(j)))
+				assert.NoError(t, strg.SetCounter(ctx, "testCounter"+strconv.Itoa(i), 1))
+			}
+		}()
+	}
+
+	for range 100 {
+		data, err := strg.GetAllMetrics(ctx)
+		require.NoError(t, err)
+
+		for k, v := range data {
+			_ = k + v.StringValue()
+		}
+	}
+
+	wg.Wait()
+
+	data, err := strg.GetAllMetrics(ctx)
+	require.NoError(t, err)
+	assert.Len(t, data, 404)
+}
+
+func TestMemStorageObserveHistogram(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewMemStorage(WithHistogramBuckets([]float64{1, 0.1, 0.5}))
+
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 2} {
+		require.NoError(t, strg.ObserveHistogram(ctx, "testHistogram", v))
+	}
+
+	h, err := strg.GetHistogram(ctx, "testHistogram")
+	require.NoError(t, err)
+
+	assert.Equal(t, []float64{0.1, 0.5, 1}, h.Buckets)
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	assert.Equal(t, []uint64{2, 3, 4}, h.CumulativeCounts())
+	assert.Equal(t, uint64(5), h.Count)
+	assert.InDelta(t, 3.15, h.Sum, 1e-9)
+
+	require.NoError(t, strg.SetGauge(ctx, "testGauge", 1))
+	require.ErrorIs(t, strg.ObserveHistogram(ctx, "testGauge", 1), ErrMetricIsNotHistogram)
+	require.ErrorIs(t, strg.SetGauge(ctx, "testHistogram", 1), ErrMetricIsNotGauge)
+
+	_, err = strg.GetHistogram(ctx, "unknown")
+	require.ErrorIs(t, err, ErrMetricNotFound)
+}
+
+func TestMemStorageLoadDataHistogram(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewMemStorage()
+	require.NoError(t, strg.ObserveHistogram(ctx, "testHistogram", 0.2))
+
+	data, err := strg.GetAllMetrics(ctx)
+	require.NoError(t, err)
+
+	// Round trip the data the way the data manager stores it.
+	raw, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	loaded := make(map[string]Metric)
+	require.NoError(t, decoder.Decode(&loaded))
+
+	restored := NewMemStorage()
+	require.NoError(t, restored.LoadData(ctx, loaded))
+
+	h, err := restored.GetHistogram(ctx, "testHistogram")
+	require.NoError(t, err)
+	assert.Equal(t, data["testHistogram"].Value, h)
+}
+
+func TestMemStorageSetMetricsNilValues(t *testing.T) {
+	ctx := context.Background()
+
+	delta := int64(1)
+
+	testCases := []struct {
+		name    string
+		metrics []models.Metrics
+		wantErr error
+	}{
+		{"CounterNilDelta", []models.Metrics{{ID: "testCounter", MType: "counter"}}, errormsg.ErrMetricEmptyDelta},
+		{"GaugeNilValue", []models.Metrics{{ID: "testGauge", MType: "gauge"}}, errormsg.ErrMetricEmptyValue},
+		{"HistogramNilValue", []models.Metrics{{ID: "testHistogram", MType: "histogram"}}, errormsg.ErrMetricEmptyValue},
+		{
+			"BatchIsNotApplied",
+			[]models.Metrics{
+				{ID: "validCounter", MType: "counter", Delta: &delta},
+				{ID: "testCounter", MType: "counter"},
+			},
+			errormsg.ErrMetricEmptyDelta,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			strg := NewMemStorage()
+
+			require.ErrorIs(t, strg.SetMetrics(ctx, tc.metrics), tc.wantErr)
+
+			data, err := strg.GetAllMetrics(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, data)
+		})
+	}
+}
+
+func TestMemStorageGetMetricsByPrefix(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewMemStorage()
+	require.NoError(t, strg.SetGauge(ctx, "HeapAlloc", 1.5))
+	require.NoError(t, strg.SetCounter(ctx, "HeapCount", 2))
+	require.NoError(t, strg.SetGauge(ctx, "StackSys", 3))
+
+	data, err := strg.GetMetricsByPrefix(ctx, "Heap")
+	require.NoError(t, err)
+
+	for name, metric := range data {
+		assert.False(t, metric.UpdatedAt.IsZero())
+
+		metric.UpdatedAt = time.Time{}
+		data[name] = metric
+	}
+
+	assert.Equal(t, map[string]Metric{
+		"HeapAlloc": {Type: "gauge", Value: GaugeValue(1.5)},
+		"HeapCount": {Type: "counter", Value: CounterValue(2)},
+	}, data)
+
+	data, err = strg.GetMetricsByPrefix(ctx, "Unknown")
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestMemStorageGetStaleMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	staleAt := time.Now().Add(-10 * time.Minute)
+
+	strg := NewMemStorage()
+	require.NoError(t, strg.LoadData(ctx, map[string]Metric{
+		"StaleGauge":   {Type: "gauge", Value: 1.5, UpdatedAt: staleAt},
+		"StaleCounter": {Type: "counter", Value: float64(2), UpdatedAt: staleAt},
+		"FreshGauge":   {Type: "gauge", Value: 3.0, UpdatedAt: staleAt},
+	}))
+
+	// The update refreshes the metric timestamp.
+	require.NoError(t, strg.SetGauge(ctx, "FreshGauge", 4))
+
+	data, err := strg.GetStaleMetrics(ctx, 5*time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, data, 2)
+	assert.Contains(t, data, "StaleGauge")
+	assert.Contains(t, data, "StaleCounter")
+	assert.True(t, data["StaleGauge"].UpdatedAt.Equal(staleAt))
+
+	data, err = strg.GetStaleMetrics(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestMemStorageSetMetricsAtomic(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewMemStorage()
+	require.NoError(t, strg.SetGauge(ctx, "testGauge", 1.5))
+
+	delta := int64(2)
+	value := 3.5
+
+	// The last metric conflicts with the stored gauge, so the batch fails.
+	err := strg.SetMetrics(ctx, []models.Metrics{
+		{ID: "testCounter", MType: "counter", Delta: &delta},
+		{ID: "testGauge", MType: "gauge", Value: &value},
+		{ID: "testGauge", MType: "counter", Delta: &delta},
+	})
+	require.ErrorIs(t, err, ErrMetricIsNotCounter)
+
+	_, err = strg.GetCounter(ctx, "testCounter")
+	require.ErrorIs(t, err, ErrMetricNotFound)
+
+	gauge, err := strg.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, gauge, 0)
+
+	// The same counter is summed within the batch.
+	require.NoError(t, strg.SetMetrics(ctx, []models.Metrics{
+		{ID: "testCounter", MType: "counter", Delta: &delta},
+		{ID: "testCounter", MType: "counter", Delta: &delta},
+	}))
+
+	cnt, err := strg.GetCounter(ctx, "testCounter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), cnt)
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+)
+
+// LastWriteAgeMetric is the name of the self-metric of the time in seconds
+// since the last successful storage write.
+const LastWriteAgeMetric = "storage_last_write_age_seconds"
+
+// WriteTracker reports the time since the last successful storage write.
+type WriteTracker interface {
+	LastWriteAge() time.Duration
+}
+
+// WriteTrackingStorage is a Storage decorator that records the time of the
+// successful writes, so that a wedged storage is detected by the growing
+// LastWriteAge.
+type WriteTrackingStorage struct {
+	Storage
+
+	// lastWrite is the Unix time in nanoseconds of the last successful
+	// write, or of the storage creation if there were none.
+	lastWrite atomic.Int64
+}
+
+// LastWriteAge returns the time since the last successful storage write.
+// It grows while the storage is wedged, even if the requests keep coming.
+func (s *WriteTrackingStorage) LastWriteAge() time.Duration {
+	return time.Since(time.Unix(0, s.lastWrite.Load()))
+}
+
+// recordWrite records the successful storage write.
+func (s *WriteTrackingStorage) recordWrite() {
+	s.lastWrite.Store(time.Now().UnixNano())
+}
+
+// SetCounter adds the value to the counter and records the write.
+func (s *WriteTrackingStorage) SetCounter(ctx context.Context, name string, value int64) error {
+	if err := s.Storage.SetCounter(ctx, name, value); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	s.recordWrite()
+
+	return nil
+}
+
+// SetGauge sets the gauge value and records the write.
+func (s *WriteTrackingStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	if err := s.Storage.SetGauge(ctx, name, value); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	s.recordWrite()
+
+	return nil
+}
+
+// SetGaugeMax sets the gauge value if it is greater and records the write.
+func (s *WriteTrackingStorage) SetGaugeMax(ctx context.Context, name string, value float64) error {
+	if err := s.Storage.SetGaugeMax(ctx, name, value); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	s.recordWrite()
+
+	return nil
+}
+
+// ObserveHistogram observes the histogram value and records the write.
+func (s *WriteTrackingStorage) ObserveHistogram(ctx context.Context, name string, value float64) error {
+	if err := s.Storage.ObserveHistogram(ctx, name, value); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	s.recordWrite()
+
+	return nil
+}
+
+// SetMetrics stores the metrics batch and records the write.
+func (s *WriteTrackingStorage) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+	if err := s.Storage.SetMetrics(ctx, metrics); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	s.recordWrite()
+
+	return nil
+}
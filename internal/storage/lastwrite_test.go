@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+)
+
+func TestLastWriteAge(t *testing.T) {
+	ctx := context.Background()
+
+	strg := NewStorage(NewMemStorage())
+
+	assert.Positive(t, strg.LastWriteAge())
+
+	// The write an hour ago is simulated.
+	strg.lastWrite.Store(time.Now().Add(-time.Hour).UnixNano())
+
+	// The failed writes are not recorded.
+	require.Error(t, strg.SetMetrics(ctx, []models.Metrics{{ID: "HeapAlloc", MType: "gauge"}}))
+	assert.GreaterOrEqual(t, strg.LastWriteAge(), time.Hour)
+
+	// The other storage tracks its writes on its own.
+	other := NewStorage(NewMemStorage())
+	require.NoError(t, other.SetCounter(ctx, "PollCount", 1))
+	assert.GreaterOrEqual(t, strg.LastWriteAge(), time.Hour)
+
+	writes := map[string]func() error{
+		"SetCounter":       func() error { return strg.SetCounter(ctx, "PollCount", 1) },
+		"SetGauge":         func() error { return strg.SetGauge(ctx, "HeapAlloc", 1) },
+		"SetGaugeMax":      func() error { return strg.SetGaugeMax(ctx, "HeapAlloc", 2) },
+		"ObserveHistogram": func() error { return strg.ObserveHistogram(ctx, "Latency", 0.1) },
+		"SetMetrics": func() error {
+			value := 1.5
+
+			return strg.SetMetrics(ctx, []models.Metrics{{ID: "HeapAlloc", MType: "gauge", Value: &value}})
+		},
+	}
+
+	for name, write := range writes {
+		t.Run(name, func(t *testing.T) {
+			strg.lastWrite.Store(time.Now().Add(-time.Hour).UnixNano())
+
+			require.NoError(t, write())
+			assert.Less(t, strg.LastWriteAge(), time.Minute)
+		})
+	}
+}
@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE metric_counters ADD COLUMN IF NOT EXISTS "updated_at" TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE metric_gauges ADD COLUMN IF NOT EXISTS "updated_at" TIMESTAMPTZ NOT NULL DEFAULT now();
+
+
+-- +goose Down
+ALTER TABLE metric_counters DROP COLUMN "updated_at";
+ALTER TABLE metric_gauges DROP COLUMN "updated_at";
@@ -0,0 +1,8 @@
+-- +goose Up
+-- The UNIQUE constraints of 001_init create these indexes under the same
+-- names, so this is a no-op unless the tables were created without them.
+CREATE UNIQUE INDEX IF NOT EXISTS metric_counters_name_key ON metric_counters ("name");
+CREATE UNIQUE INDEX IF NOT EXISTS metric_gauges_name_key ON metric_gauges ("name");
+
+
+-- +goose Down
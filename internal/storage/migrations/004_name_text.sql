@@ -0,0 +1,10 @@
+-- +goose Up
+-- The metric name length is limited by the server validation, which may be
+-- configured above the 50 characters of 001_init, so it is not limited here.
+ALTER TABLE metric_counters ALTER COLUMN "name" TYPE TEXT;
+ALTER TABLE metric_gauges ALTER COLUMN "name" TYPE TEXT;
+
+
+-- +goose Down
+ALTER TABLE metric_counters ALTER COLUMN "name" TYPE VARCHAR (50);
+ALTER TABLE metric_gauges ALTER COLUMN "name" TYPE VARCHAR (50);
@@ -0,0 +1,19 @@
+-- +goose Up
+-- The schema mirrors the Postgres one. The updated_at columns hold the Unix
+-- time in nanoseconds, since SQLite has no timestamp type.
+CREATE TABLE IF NOT EXISTS metric_counters (
+    "name" TEXT UNIQUE NOT NULL,
+    "value" INTEGER NOT NULL DEFAULT 0,
+    "updated_at" INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS metric_gauges (
+    "name" TEXT UNIQUE NOT NULL,
+    "value" REAL NOT NULL DEFAULT 0,
+    "updated_at" INTEGER NOT NULL DEFAULT 0
+);
+
+
+-- +goose Down
+DROP TABLE metric_counters;
+DROP TABLE metric_gauges;
@@ -3,9 +3,11 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"embed"
 	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,18 +15,29 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib" // Postgresql driver.
 	"github.com/pressly/goose/v3"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 
+	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
 	"github.com/andymarkow/go-metrics-collector/internal/models"
 )
 
+// embeddedMigrations holds the database schema migrations embedded into the
+// binary, so that Bootstrap does not depend on the working directory.
+//
+//go:embed migrations/*.sql
+var embeddedMigrations embed.FS
+
 // PostgresStorage implements the Storage interface using Postgres.
 var _ Storage = (*PostgresStorage)(nil)
 
 // PostgresStorage is a Storage implementation using Postgres.
 type PostgresStorage struct {
-	log *zap.Logger
-	db  *sql.DB
+	log    *zap.Logger
+	db     *sql.DB
+	tracer trace.Tracer
+
+	healthCheckTimeout time.Duration
 }
 
 // NewPostgresStorage creates a new PostgresStorage instance with the given connection string.
@@ -37,40 +50,37 @@ func NewPostgresStorage(connStr string, opts ...Option) (*PostgresStorage, error
 		return nil, fmt.Errorf("sql.Open: %w", err)
 	}
 
-	db.SetMaxOpenConns(10)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxIdleTime(180 * time.Second)
-	db.SetConnMaxLifetime(3600 * time.Second)
+	options := newOptions(opts...)
 
-	pgstorage := &PostgresStorage{
-		log: zap.NewNop(),
-		db:  db,
-	}
+	db.SetMaxOpenConns(options.maxOpenConns)
+	db.SetMaxIdleConns(options.maxIdleConns)
+	db.SetConnMaxIdleTime(options.connMaxIdleTime)
+	db.SetConnMaxLifetime(options.connMaxLifetime)
 
-	for _, opt := range opts {
-		opt(pgstorage)
+	pgstorage := &PostgresStorage{
+		log:                options.log,
+		db:                 db,
+		tracer:             options.tracerProvider.Tracer(tracerName),
+		healthCheckTimeout: options.healthCheckTimeout,
 	}
 
 	return pgstorage, nil
 }
 
-type Option func(*PostgresStorage)
-
-func WithLogger(logger *zap.Logger) Option {
-	return func(pg *PostgresStorage) {
-		pg.log = logger
-	}
-}
-
 // Bootstrap migrates the database schema to the latest version.
 //
 // It is safe to call multiple times, as goose will only apply the
 // migrations that have not yet been applied.
 func (pg *PostgresStorage) Bootstrap(ctx context.Context) error {
+	migrationsFS, err := fs.Sub(embeddedMigrations, "migrations")
+	if err != nil {
+		return fmt.Errorf("fs.Sub: %w", err)
+	}
+
 	provider, err := goose.NewProvider(
 		goose.DialectPostgres,
 		pg.db,
-		os.DirFS("migrations"),
+		migrationsFS,
 	)
 	if err != nil {
 		return fmt.Errorf("goose.NewProvider: %w", err)
@@ -93,8 +103,16 @@ func (pg *PostgresStorage) Close() error {
 	return nil
 }
 
+// startSpan starts the child span of the storage operation.
+func (pg *PostgresStorage) startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return startSpan(ctx, pg.tracer, "postgresql", operation)
+}
+
 // Ping pings the underlying database connection.
 func (pg *PostgresStorage) Ping(ctx context.Context) error {
+	ctx, span := pg.startSpan(ctx, "Ping")
+	defer span.End()
+
 	err := WithRetry(func() error {
 		if err := pg.db.PingContext(ctx); err != nil {
 			return fmt.Errorf("db.PingContext: %w", err)
@@ -103,17 +121,91 @@ func (pg *PostgresStorage) Ping(ctx context.Context) error {
 		return nil
 	})
 	if err != nil {
-		return err
+		return spanError(span, err)
+	}
+
+	return nil
+}
+
+// HealthCheck pings the underlying database connection once without retries,
+// so it returns within the health check timeout when the database is down.
+func (pg *PostgresStorage) HealthCheck(ctx context.Context) error {
+	ctx, span := pg.startSpan(ctx, "HealthCheck")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, pg.healthCheckTimeout)
+	defer cancel()
+
+	if err := pg.db.PingContext(ctx); err != nil {
+		return spanError(span, fmt.Errorf("db.PingContext: %w", err))
 	}
 
 	return nil
 }
 
 func (pg *PostgresStorage) GetAllMetrics(ctx context.Context) (map[string]Metric, error) {
+	ctx, span := pg.startSpan(ctx, "GetAllMetrics")
+	defer span.End()
+
+	data, err := pg.queryMetrics(ctx,
+		"SELECT name, value, updated_at FROM metric_counters;",
+		"SELECT name, value, updated_at FROM metric_gauges;",
+	)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return data, nil
+}
+
+// GetMetricsByPrefix returns the metrics which names start with the prefix.
+func (pg *PostgresStorage) GetMetricsByPrefix(ctx context.Context, prefix string) (map[string]Metric, error) {
+	ctx, span := pg.startSpan(ctx, "GetMetricsByPrefix")
+	defer span.End()
+
+	data, err := pg.queryMetrics(ctx,
+		"SELECT name, value, updated_at FROM metric_counters WHERE name LIKE $1 || '%';",
+		"SELECT name, value, updated_at FROM metric_gauges WHERE name LIKE $1 || '%';",
+		escapeLikePattern(prefix),
+	)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return data, nil
+}
+
+// GetStaleMetrics returns the metrics not updated within the olderThan window.
+func (pg *PostgresStorage) GetStaleMetrics(ctx context.Context, olderThan time.Duration) (map[string]Metric, error) {
+	ctx, span := pg.startSpan(ctx, "GetStaleMetrics")
+	defer span.End()
+
+	data, err := pg.queryMetrics(ctx,
+		"SELECT name, value, updated_at FROM metric_counters WHERE updated_at < $1;",
+		"SELECT name, value, updated_at FROM metric_gauges WHERE updated_at < $1;",
+		time.Now().Add(-olderThan),
+	)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	return data, nil
+}
+
+// escapeLikePattern escapes the LIKE pattern special characters, so that
+// the string is matched literally.
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// queryMetrics returns the metrics selected by the counters and gauges queries
+// with the given arguments. The queries select the name, value and updated_at
+// columns.
+func (pg *PostgresStorage) queryMetrics(ctx context.Context, countersQuery, gaugesQuery string, args ...any) (map[string]Metric, error) {
 	data := make(map[string]Metric)
 
 	err := WithRetry(func() error {
-		countersStmt, err := pg.db.PrepareContext(ctx, "SELECT name, value FROM metric_counters;")
+		countersStmt, err := pg.db.PrepareContext(ctx, countersQuery)
 		if err != nil {
 			return fmt.Errorf("db.PrepareContext: %w", err)
 		}
@@ -123,7 +215,7 @@ func (pg *PostgresStorage) GetAllMetrics(ctx context.Context) (map[string]Metric
 			}
 		}()
 
-		counters, err := countersStmt.QueryContext(ctx)
+		counters, err := countersStmt.QueryContext(ctx, args...)
 		if err != nil {
 			return fmt.Errorf("countersStmt.QueryContext: %w", err)
 		}
@@ -136,14 +228,16 @@ func (pg *PostgresStorage) GetAllMetrics(ctx context.Context) (map[string]Metric
 		for counters.Next() {
 			var name string
 			var value int64
+			var updatedAt time.Time
 
-			if err := counters.Scan(&name, &value); err != nil {
+			if err := counters.Scan(&name, &value, &updatedAt); err != nil {
 				return fmt.Errorf("counters.Scan: %w", err)
 			}
 
 			data[name] = Metric{
-				Type:  "counter",
-				Value: value,
+				Type:      "counter",
+				Value:     value,
+				UpdatedAt: updatedAt,
 			}
 		}
 
@@ -151,7 +245,7 @@ func (pg *PostgresStorage) GetAllMetrics(ctx context.Context) (map[string]Metric
 			return fmt.Errorf("counters.Err: %w", err)
 		}
 
-		gaugesStmt, err := pg.db.PrepareContext(ctx, "SELECT name, value FROM metric_gauges;")
+		gaugesStmt, err := pg.db.PrepareContext(ctx, gaugesQuery)
 		if err != nil {
 			return fmt.Errorf("db.PrepareContext: %w", err)
 		}
@@ -161,7 +255,7 @@ func (pg *PostgresStorage) GetAllMetrics(ctx context.Context) (map[string]Metric
 			}
 		}()
 
-		gauges, err := gaugesStmt.QueryContext(ctx)
+		gauges, err := gaugesStmt.QueryContext(ctx, args...)
 		if err != nil {
 			return fmt.Errorf("gaugesStmt.QueryContext: %w", err)
 		}
@@ -174,14 +268,16 @@ func (pg *PostgresStorage) GetAllMetrics(ctx context.Context) (map[string]Metric
 		for gauges.Next() {
 			var name string
 			var value float64
+			var updatedAt time.Time
 
-			if err := gauges.Scan(&name, &value); err != nil {
+			if err := gauges.Scan(&name, &value, &updatedAt); err != nil {
 				return fmt.Errorf("gauges.Scan: %w", err)
 			}
 
 			data[name] = Metric{
-				Type:  "gauge",
-				Value: value,
+				Type:      "gauge",
+				Value:     value,
+				UpdatedAt: updatedAt,
 			}
 		}
 
@@ -199,6 +295,9 @@ func (pg *PostgresStorage) GetAllMetrics(ctx context.Context) (map[string]Metric
 }
 
 func (pg *PostgresStorage) GetCounter(ctx context.Context, name string) (int64, error) {
+	ctx, span := pg.startSpan(ctx, "GetCounter")
+	defer span.End()
+
 	var value int64
 
 	err := WithRetry(func() error {
@@ -224,18 +323,21 @@ func (pg *PostgresStorage) GetCounter(ctx context.Context, name string) (int64,
 		return nil
 	})
 	if err != nil {
-		return 0, err
+		return 0, spanError(span, err)
 	}
 
 	return value, nil
 }
 
 func (pg *PostgresStorage) SetCounter(ctx context.Context, name string, value int64) error {
+	ctx, span := pg.startSpan(ctx, "SetCounter")
+	defer span.End()
+
 	query := `
 		INSERT INTO metric_counters (name, value)
 		VALUES ($1, $2)
 		ON CONFLICT (name)
-		DO UPDATE SET value = metric_counters.value + $2;`
+		DO UPDATE SET value = metric_counters.value + $2, updated_at = now();`
 
 	err := WithRetry(func() error {
 		stmt, err := pg.db.PrepareContext(ctx, query)
@@ -250,19 +352,22 @@ func (pg *PostgresStorage) SetCounter(ctx context.Context, name string, value in
 
 		_, err = stmt.ExecContext(ctx, name, value)
 		if err != nil {
-			return fmt.Errorf("stmt.ExecContext: %w", err)
+			return fmt.Errorf("stmt.ExecContext: %w", counterOverflowError(err))
 		}
 
 		return nil
 	})
 	if err != nil {
-		return err
+		return spanError(span, err)
 	}
 
 	return nil
 }
 
 func (pg *PostgresStorage) GetGauge(ctx context.Context, name string) (float64, error) {
+	ctx, span := pg.startSpan(ctx, "GetGauge")
+	defer span.End()
+
 	var value float64
 
 	err := WithRetry(func() error {
@@ -287,18 +392,57 @@ func (pg *PostgresStorage) GetGauge(ctx context.Context, name string) (float64,
 		return nil
 	})
 	if err != nil {
-		return 0, err
+		return 0, spanError(span, err)
 	}
 
 	return value, nil
 }
 
 func (pg *PostgresStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	ctx, span := pg.startSpan(ctx, "SetGauge")
+	defer span.End()
+
+	query := `
+		INSERT INTO metric_gauges (name, value)
+		VALUES ($1, $2)
+		ON CONFLICT (name)
+		DO UPDATE SET value = $2, updated_at = now();`
+
+	err := WithRetry(func() error {
+		stmt, err := pg.db.PrepareContext(ctx, query)
+		if err != nil {
+			return fmt.Errorf("db.PrepareContext: %w", err)
+		}
+		defer func() {
+			if err := stmt.Close(); err != nil {
+				pg.log.Error("stmt.Close: " + err.Error())
+			}
+		}()
+
+		_, err = stmt.ExecContext(ctx, name, value)
+		if err != nil {
+			return fmt.Errorf("stmt.ExecContext: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return spanError(span, err)
+	}
+
+	return nil
+}
+
+// SetGaugeMax stores the gauge value only if it is greater than the current one.
+func (pg *PostgresStorage) SetGaugeMax(ctx context.Context, name string, value float64) error {
+	ctx, span := pg.startSpan(ctx, "SetGaugeMax")
+	defer span.End()
+
 	query := `
 		INSERT INTO metric_gauges (name, value)
 		VALUES ($1, $2)
 		ON CONFLICT (name)
-		DO UPDATE SET value = $2;`
+		DO UPDATE SET value = GREATEST(metric_gauges.value, $2), updated_at = now();`
 
 	err := WithRetry(func() error {
 		stmt, err := pg.db.PrepareContext(ctx, query)
@@ -319,17 +463,26 @@ func (pg *PostgresStorage) SetGauge(ctx context.Context, name string, value floa
 		return nil
 	})
 	if err != nil {
-		return err
+		return spanError(span, err)
 	}
 
 	return nil
 }
 
 func (pg *PostgresStorage) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+	ctx, span := pg.startSpan(ctx, "SetMetrics")
+	defer span.End()
+
+	if err := validateMetrics(metrics); err != nil {
+		return spanError(span, err)
+	}
+
 	err := WithRetry(func() error {
-		tx, err := pg.db.Begin()
+		// The transaction is rolled back if ctx is done before the commit,
+		// e.g. the client has gone away on the server shutdown.
+		tx, err := pg.db.BeginTx(ctx, nil)
 		if err != nil {
-			return fmt.Errorf("db.Begin: %w", err)
+			return fmt.Errorf("db.BeginTx: %w", err)
 		}
 		defer func() {
 			if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
@@ -339,7 +492,7 @@ func (pg *PostgresStorage) SetMetrics(ctx context.Context, metrics []models.Metr
 
 		counterStmt, err := tx.PrepareContext(ctx,
 			"INSERT INTO metric_counters (name, value) VALUES ($1, $2)"+
-				"ON CONFLICT (name) DO UPDATE SET value = metric_counters.value + $2;")
+				"ON CONFLICT (name) DO UPDATE SET value = metric_counters.value + $2, updated_at = now();")
 		if err != nil {
 			return fmt.Errorf("tx.PrepareContext: %w", err)
 		}
@@ -351,7 +504,7 @@ func (pg *PostgresStorage) SetMetrics(ctx context.Context, metrics []models.Metr
 
 		gaugeStmt, err := tx.PrepareContext(ctx,
 			"INSERT INTO metric_gauges (name, value) VALUES ($1, $2)"+
-				"ON CONFLICT (name) DO UPDATE SET value = $2;")
+				"ON CONFLICT (name) DO UPDATE SET value = $2, updated_at = now();")
 		if err != nil {
 			return fmt.Errorf("tx.PrepareContext: %w", err)
 		}
@@ -366,7 +519,7 @@ func (pg *PostgresStorage) SetMetrics(ctx context.Context, metrics []models.Metr
 			case "counter":
 				_, err := counterStmt.ExecContext(ctx, metric.ID, *metric.Delta)
 				if err != nil {
-					return fmt.Errorf("counterStmt.ExecContext: %w", err)
+					return fmt.Errorf("counterStmt.ExecContext: %w", counterOverflowError(err))
 				}
 
 			case "gauge":
@@ -375,6 +528,9 @@ func (pg *PostgresStorage) SetMetrics(ctx context.Context, metrics []models.Metr
 					return fmt.Errorf("gaugeStmt.ExecContext: %w", err)
 				}
 
+			case "histogram":
+				return ErrHistogramsNotSupported
+
 			default:
 				return fmt.Errorf("unknown metric type: %s", metric.MType)
 			}
@@ -387,12 +543,90 @@ func (pg *PostgresStorage) SetMetrics(ctx context.Context, metrics []models.Metr
 		return nil
 	})
 	if err != nil {
-		return err
+		return spanError(span, err)
+	}
+
+	return nil
+}
+
+// DeleteMetric removes the metric of the given type from the database.
+func (pg *PostgresStorage) DeleteMetric(ctx context.Context, metricType, name string) error {
+	ctx, span := pg.startSpan(ctx, "DeleteMetric")
+	defer span.End()
+
+	var query string
+
+	switch metricType {
+	case "counter":
+		query = "DELETE FROM metric_counters WHERE name = $1;"
+	case "gauge":
+		query = "DELETE FROM metric_gauges WHERE name = $1;"
+	default:
+		return spanError(span, ErrMetricNotFound)
+	}
+
+	err := WithRetry(func() error {
+		res, err := pg.db.ExecContext(ctx, query, name)
+		if err != nil {
+			return fmt.Errorf("db.ExecContext: %w", err)
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("res.RowsAffected: %w", err)
+		}
+
+		if rows == 0 {
+			return ErrMetricNotFound
+		}
+
+		return nil
+	})
+	if err != nil {
+		return spanError(span, err)
 	}
 
 	return nil
 }
 
+// ObserveHistogram is not supported by the Postgres storage.
+func (pg *PostgresStorage) ObserveHistogram(_ context.Context, _ string, _ float64) error {
+	return ErrHistogramsNotSupported
+}
+
+// GetHistogram is not supported by the Postgres storage.
+func (pg *PostgresStorage) GetHistogram(_ context.Context, _ string) (HistogramValue, error) {
+	return HistogramValue{}, ErrHistogramsNotSupported
+}
+
+// ResetCounters sets all the counters to zero and returns the number of
+// counters reset. The gauges are left untouched.
+func (pg *PostgresStorage) ResetCounters(ctx context.Context) (int64, error) {
+	ctx, span := pg.startSpan(ctx, "ResetCounters")
+	defer span.End()
+
+	var reset int64
+
+	err := WithRetry(func() error {
+		res, err := pg.db.ExecContext(ctx, "UPDATE metric_counters SET value = 0, updated_at = now();")
+		if err != nil {
+			return fmt.Errorf("db.ExecContext: %w", err)
+		}
+
+		reset, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("res.RowsAffected: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, spanError(span, err)
+	}
+
+	return reset, nil
+}
+
 // LoadData is a stub to keep compatibility with Storage interface.
 func (pg *PostgresStorage) LoadData(_ context.Context, _ map[string]Metric) error {
 	return nil
@@ -400,6 +634,12 @@ func (pg *PostgresStorage) LoadData(_ context.Context, _ map[string]Metric) erro
 
 // WithRetry retries operations in case of retryable errors.
 func WithRetry(operation func() error) error {
+	return retry(isRetryableError, operation)
+}
+
+// retry retries the operation in case of the errors reported retryable by
+// the isRetryable classifier of the storage.
+func retry(isRetryable func(error) bool, operation func() error) error {
 	// Retry count
 	retryCount := 3
 
@@ -417,7 +657,7 @@ func WithRetry(operation func() error) error {
 			return nil
 		}
 
-		if isRetryableError(err) {
+		if isRetryable(err) {
 			retryWaitTime = time.Duration((i*retryWaitInterval + 1)) * time.Second // 1s, 3s, 5s, etc.
 
 			// TBD: time.After or time.Ticker.
@@ -430,6 +670,17 @@ func WithRetry(operation func() error) error {
 	return fmt.Errorf("retry attempts exceeded: %w", err)
 }
 
+// counterOverflowError reports the counter addition out of the bigint range
+// as the counter overflow, so the counter is never wrapped around.
+func counterOverflowError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.NumericValueOutOfRange {
+		return fmt.Errorf("%w: %w", errormsg.ErrMetricCounterOverflow, err)
+	}
+
+	return err
+}
+
 // isRetryableError checks if error is retryable.
 func isRetryableError(err error) bool {
 	// Connection refused error
@@ -443,5 +694,11 @@ func isRetryableError(err error) bool {
 		return true
 	}
 
+	// Undefined table error is transient while another instance bootstraps
+	// the database schema, so reads are retried until migrations complete.
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UndefinedTable {
+		return true
+	}
+
 	return false
 }
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+)
+
+func newTestRedisStorage(t *testing.T) *RedisStorage {
+	t.Helper()
+
+	srv := miniredis.RunT(t)
+
+	strg, err := NewRedisStorage("redis://" + srv.Addr())
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, strg.Close())
+	})
+
+	return strg
+}
+
+func TestRedisStorage(t *testing.T) {
+	ctx := context.Background()
+
+	strg := newTestRedisStorage(t)
+
+	require.NoError(t, strg.Ping(ctx))
+
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 2))
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 3))
+	require.NoError(t, strg.SetGauge(ctx, "testGauge", 1.5))
+
+	cnt, err := strg.GetCounter(ctx, "testCounter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), cnt)
+
+	gauge, err := strg.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, gauge, 0)
+
+	// Smaller value is ignored.
+	require.NoError(t, strg.SetGaugeMax(ctx, "testGauge", 1))
+
+	gauge, err = strg.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, gauge, 0)
+
+	_, err = strg.GetGauge(ctx, "unknown")
+	require.ErrorIs(t, err, ErrMetricNotFound)
+
+	_, err = strg.GetGauge(ctx, "testCounter")
+	require.ErrorIs(t, err, ErrMetricIsNotGauge)
+
+	require.ErrorIs(t, strg.SetCounter(ctx, "testGauge", 1), ErrMetricIsNotCounter)
+
+	delta := int64(1)
+	value := 2.5
+
+	require.NoError(t, strg.SetMetrics(ctx, []models.Metrics{
+		{ID: "testCounter", MType: "counter", Delta: &delta},
+		{ID: "otherGauge", MType: "gauge", Value: &value},
+	}))
+
+	data, err := strg.GetAllMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Metric{
+		"testCounter": {Type: "counter", Value: CounterValue(6)},
+		"testGauge":   {Type: "gauge", Value: GaugeValue(1.5)},
+		"otherGauge":  {Type: "gauge", Value: GaugeValue(2.5)},
+	}, data)
+
+	require.NoError(t, strg.DeleteMetric(ctx, "gauge", "otherGauge"))
+	require.ErrorIs(t, strg.DeleteMetric(ctx, "gauge", "otherGauge"), ErrMetricNotFound)
+}
+
+func TestRedisStorageSetMetricsTypeMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	strg := newTestRedisStorage(t)
+
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 1))
+
+	delta := int64(1)
+	value := 2.5
+
+	testCases := []struct {
+		name    string
+		metrics []models.Metrics
+		wantErr error
+	}{
+		{"StoredCounter", []models.Metrics{
+			{ID: "otherGauge", MType: "gauge", Value: &value},
+			{ID: "testCounter", MType: "gauge", Value: &value},
+		}, ErrMetricIsNotGauge},
+		{"BatchGauge", []models.Metrics{
+			{ID: "newMetric", MType: "gauge", Value: &value},
+			{ID: "newMetric", MType: "counter", Delta: &delta},
+		}, ErrMetricIsNotCounter},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.ErrorIs(t, strg.SetMetrics(ctx, tc.metrics), tc.wantErr)
+		})
+	}
+
+	// Nothing of the rejected batches is stored.
+	data, err := strg.GetAllMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Metric{
+		"testCounter": {Type: "counter", Value: CounterValue(1)},
+	}, data)
+}
+
+func TestRedisStorageResetCounters(t *testing.T) {
+	ctx := context.Background()
+
+	strg := newTestRedisStorage(t)
+
+	reset, err := strg.ResetCounters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), reset)
+
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 3))
+	require.NoError(t, strg.SetGauge(ctx, "testGauge", 1.5))
+
+	reset, err = strg.ResetCounters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reset)
+
+	cnt, err := strg.GetCounter(ctx, "testCounter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), cnt)
+
+	gauge, err := strg.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, gauge, 0)
+}
+
+func TestRedisStorageGetMetricsByPrefix(t *testing.T) {
+	ctx := context.Background()
+
+	strg := newTestRedisStorage(t)
+
+	require.NoError(t, strg.SetGauge(ctx, "HeapAlloc", 1.5))
+	require.NoError(t, strg.SetCounter(ctx, "HeapCount", 2))
+	require.NoError(t, strg.SetGauge(ctx, "Heap*", 3))
+	require.NoError(t, strg.SetGauge(ctx, "StackSys", 4))
+
+	data, err := strg.GetMetricsByPrefix(ctx, "Heap")
+	require.NoError(t, err)
+	assert.Len(t, data, 3)
+	assert.Equal(t, CounterValue(2), data["HeapCount"].Value)
+
+	// The glob pattern characters are matched literally.
+	data, err = strg.GetMetricsByPrefix(ctx, "Heap*")
+	require.NoError(t, err)
+	assert.Len(t, data, 1)
+	assert.Contains(t, data, "Heap*")
+}
@@ -0,0 +1,497 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/pressly/goose/v3"
+	"go.uber.org/zap"
+	"modernc.org/sqlite" // SQLite driver.
+	sqlite3 "modernc.org/sqlite/lib"
+
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+)
+
+// embeddedSQLiteMigrations holds the SQLite database schema migrations.
+//
+//go:embed migrations/sqlite/*.sql
+var embeddedSQLiteMigrations embed.FS
+
+// sqliteBusyTimeout is the time a connection waits for the database lock
+// held by another connection before failing with the SQLITE_BUSY error.
+const sqliteBusyTimeout = 5 * time.Second
+
+// SQLiteStorage implements the Storage interface using SQLite.
+var _ Storage = (*SQLiteStorage)(nil)
+
+// SQLiteStorage is a Storage implementation using SQLite.
+//
+// The schema mirrors the Postgres one with the updated_at columns holding
+// the Unix time in nanoseconds.
+type SQLiteStorage struct {
+	log *zap.Logger
+	db  *sql.DB
+
+	healthCheckTimeout time.Duration
+}
+
+// NewSQLiteStorage creates a new SQLiteStorage instance with the database
+// file at the given path. The file is created if it does not exist.
+//
+// The database is opened in the WAL mode, so the reads are not blocked
+// by the writes.
+func NewSQLiteStorage(path string, opts ...Option) (*SQLiteStorage, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_txlock=immediate",
+		path, sqliteBusyTimeout.Milliseconds())
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sql.Open: %w", err)
+	}
+
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(5)
+	db.SetConnMaxIdleTime(180 * time.Second)
+
+	options := newOptions(opts...)
+
+	return &SQLiteStorage{
+		log:                options.log,
+		db:                 db,
+		healthCheckTimeout: options.healthCheckTimeout,
+	}, nil
+}
+
+// Bootstrap migrates the database schema to the latest version.
+func (s *SQLiteStorage) Bootstrap(ctx context.Context) error {
+	migrationsFS, err := fs.Sub(embeddedSQLiteMigrations, "migrations/sqlite")
+	if err != nil {
+		return fmt.Errorf("fs.Sub: %w", err)
+	}
+
+	provider, err := goose.NewProvider(
+		goose.DialectSQLite3,
+		s.db,
+		migrationsFS,
+	)
+	if err != nil {
+		return fmt.Errorf("goose.NewProvider: %w", err)
+	}
+
+	_, err = provider.Up(ctx)
+	if err != nil {
+		return fmt.Errorf("provider.Up: %w", err)
+	}
+
+	return nil
+}
+
+// Close closes the underlying database connection.
+func (s *SQLiteStorage) Close() error {
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("db.Close: %w", err)
+	}
+
+	return nil
+}
+
+// Ping pings the underlying database connection.
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	err := withSQLiteRetry(func() error {
+		if err := s.db.PingContext(ctx); err != nil {
+			return fmt.Errorf("db.PingContext: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// HealthCheck pings the underlying database connection once without retries.
+func (s *SQLiteStorage) HealthCheck(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.healthCheckTimeout)
+	defer cancel()
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("db.PingContext: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetAllMetrics(ctx context.Context) (map[string]Metric, error) {
+	return s.queryMetrics(ctx,
+		"SELECT name, value, updated_at FROM metric_counters;",
+		"SELECT name, value, updated_at FROM metric_gauges;",
+	)
+}
+
+// GetMetricsByPrefix returns the metrics which names start with the prefix.
+//
+// The names are compared with substr rather than LIKE, which is case
+// insensitive in SQLite.
+func (s *SQLiteStorage) GetMetricsByPrefix(ctx context.Context, prefix string) (map[string]Metric, error) {
+	return s.queryMetrics(ctx,
+		"SELECT name, value, updated_at FROM metric_counters WHERE substr(name, 1, length($1)) = $1;",
+		"SELECT name, value, updated_at FROM metric_gauges WHERE substr(name, 1, length($1)) = $1;",
+		prefix,
+	)
+}
+
+// GetStaleMetrics returns the metrics not updated within the olderThan window.
+func (s *SQLiteStorage) GetStaleMetrics(ctx context.Context, olderThan time.Duration) (map[string]Metric, error) {
+	return s.queryMetrics(ctx,
+		"SELECT name, value, updated_at FROM metric_counters WHERE updated_at < $1;",
+		"SELECT name, value, updated_at FROM metric_gauges WHERE updated_at < $1;",
+		time.Now().Add(-olderThan).UnixNano(),
+	)
+}
+
+// queryMetrics returns the metrics selected by the counters and gauges queries
+// with the given arguments. The queries select the name, value and updated_at
+// columns.
+func (s *SQLiteStorage) queryMetrics(ctx context.Context, countersQuery, gaugesQuery string, args ...any) (map[string]Metric, error) {
+	data := make(map[string]Metric)
+
+	err := withSQLiteRetry(func() error {
+		counters, err := s.db.QueryContext(ctx, countersQuery, args...)
+		if err != nil {
+			return fmt.Errorf("db.QueryContext: %w", err)
+		}
+		defer func() {
+			if err := counters.Close(); err != nil {
+				s.log.Error("counters.Close: " + err.Error())
+			}
+		}()
+
+		for counters.Next() {
+			var name string
+			var value int64
+			var updatedAt int64
+
+			if err := counters.Scan(&name, &value, &updatedAt); err != nil {
+				return fmt.Errorf("counters.Scan: %w", err)
+			}
+
+			data[name] = Metric{
+				Type:      "counter",
+				Value:     value,
+				UpdatedAt: time.Unix(0, updatedAt),
+			}
+		}
+
+		if err := counters.Err(); err != nil {
+			return fmt.Errorf("counters.Err: %w", err)
+		}
+
+		gauges, err := s.db.QueryContext(ctx, gaugesQuery, args...)
+		if err != nil {
+			return fmt.Errorf("db.QueryContext: %w", err)
+		}
+		defer func() {
+			if err := gauges.Close(); err != nil {
+				s.log.Error("gauges.Close: " + err.Error())
+			}
+		}()
+
+		for gauges.Next() {
+			var name string
+			var value float64
+			var updatedAt int64
+
+			if err := gauges.Scan(&name, &value, &updatedAt); err != nil {
+				return fmt.Errorf("gauges.Scan: %w", err)
+			}
+
+			data[name] = Metric{
+				Type:      "gauge",
+				Value:     value,
+				UpdatedAt: time.Unix(0, updatedAt),
+			}
+		}
+
+		if err := gauges.Err(); err != nil {
+			return fmt.Errorf("gauges.Err: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *SQLiteStorage) GetCounter(ctx context.Context, name string) (int64, error) {
+	var value int64
+
+	err := withSQLiteRetry(func() error {
+		row := s.db.QueryRowContext(ctx, "SELECT value FROM metric_counters WHERE name = $1;", name)
+
+		if err := row.Scan(&value); errors.Is(err, sql.ErrNoRows) {
+			return ErrMetricNotFound
+		} else if err != nil {
+			return fmt.Errorf("row.Scan: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+func (s *SQLiteStorage) SetCounter(ctx context.Context, name string, value int64) error {
+	return s.exec(ctx, `
+		INSERT INTO metric_counters (name, value, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name)
+		DO UPDATE SET value = metric_counters.value + excluded.value, updated_at = excluded.updated_at;`,
+		name, value, time.Now().UnixNano(),
+	)
+}
+
+func (s *SQLiteStorage) GetGauge(ctx context.Context, name string) (float64, error) {
+	var value float64
+
+	err := withSQLiteRetry(func() error {
+		row := s.db.QueryRowContext(ctx, "SELECT value FROM metric_gauges WHERE name = $1;", name)
+
+		if err := row.Scan(&value); errors.Is(err, sql.ErrNoRows) {
+			return ErrMetricNotFound
+		} else if err != nil {
+			return fmt.Errorf("row.Scan: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+func (s *SQLiteStorage) SetGauge(ctx context.Context, name string, value float64) error {
+	return s.exec(ctx, `
+		INSERT INTO metric_gauges (name, value, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name)
+		DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at;`,
+		name, value, time.Now().UnixNano(),
+	)
+}
+
+// SetGaugeMax stores the gauge value only if it is greater than the current one.
+func (s *SQLiteStorage) SetGaugeMax(ctx context.Context, name string, value float64) error {
+	return s.exec(ctx, `
+		INSERT INTO metric_gauges (name, value, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name)
+		DO UPDATE SET value = max(metric_gauges.value, excluded.value), updated_at = excluded.updated_at;`,
+		name, value, time.Now().UnixNano(),
+	)
+}
+
+// exec executes the query with the given arguments.
+func (s *SQLiteStorage) exec(ctx context.Context, query string, args ...any) error {
+	err := withSQLiteRetry(func() error {
+		if _, err := s.db.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("db.ExecContext: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+	if err := validateMetrics(metrics); err != nil {
+		return err
+	}
+
+	err := withSQLiteRetry(func() error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("db.BeginTx: %w", err)
+		}
+		defer func() {
+			if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+				s.log.Error("tx.Rollback: " + err.Error())
+			}
+		}()
+
+		counterStmt, err := tx.PrepareContext(ctx,
+			"INSERT INTO metric_counters (name, value, updated_at) VALUES ($1, $2, $3) "+
+				"ON CONFLICT (name) DO UPDATE SET value = metric_counters.value + excluded.value, "+
+				"updated_at = excluded.updated_at;")
+		if err != nil {
+			return fmt.Errorf("tx.PrepareContext: %w", err)
+		}
+		defer func() {
+			if err := counterStmt.Close(); err != nil {
+				s.log.Error("counterStmt.Close: " + err.Error())
+			}
+		}()
+
+		gaugeStmt, err := tx.PrepareContext(ctx,
+			"INSERT INTO metric_gauges (name, value, updated_at) VALUES ($1, $2, $3) "+
+				"ON CONFLICT (name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at;")
+		if err != nil {
+			return fmt.Errorf("tx.PrepareContext: %w", err)
+		}
+		defer func() {
+			if err := gaugeStmt.Close(); err != nil {
+				s.log.Error("gaugeStmt.Close: " + err.Error())
+			}
+		}()
+
+		now := time.Now().UnixNano()
+
+		for _, metric := range metrics {
+			switch metric.MType {
+			case "counter":
+				_, err := counterStmt.ExecContext(ctx, metric.ID, *metric.Delta, now)
+				if err != nil {
+					return fmt.Errorf("counterStmt.ExecContext: %w", err)
+				}
+
+			case "gauge":
+				_, err := gaugeStmt.ExecContext(ctx, metric.ID, *metric.Value, now)
+				if err != nil {
+					return fmt.Errorf("gaugeStmt.ExecContext: %w", err)
+				}
+
+			case "histogram":
+				return ErrHistogramsNotSupported
+
+			default:
+				return fmt.Errorf("unknown metric type: %s", metric.MType)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("tx.Commit: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteMetric removes the metric of the given type from the database.
+func (s *SQLiteStorage) DeleteMetric(ctx context.Context, metricType, name string) error {
+	var query string
+
+	switch metricType {
+	case "counter":
+		query = "DELETE FROM metric_counters WHERE name = $1;"
+	case "gauge":
+		query = "DELETE FROM metric_gauges WHERE name = $1;"
+	default:
+		return ErrMetricNotFound
+	}
+
+	err := withSQLiteRetry(func() error {
+		res, err := s.db.ExecContext(ctx, query, name)
+		if err != nil {
+			return fmt.Errorf("db.ExecContext: %w", err)
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("res.RowsAffected: %w", err)
+		}
+
+		if rows == 0 {
+			return ErrMetricNotFound
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ObserveHistogram is not supported by the SQLite storage.
+func (s *SQLiteStorage) ObserveHistogram(_ context.Context, _ string, _ float64) error {
+	return ErrHistogramsNotSupported
+}
+
+// GetHistogram is not supported by the SQLite storage.
+func (s *SQLiteStorage) GetHistogram(_ context.Context, _ string) (HistogramValue, error) {
+	return HistogramValue{}, ErrHistogramsNotSupported
+}
+
+// ResetCounters sets all the counters to zero and returns the number of
+// counters reset. The gauges are left untouched.
+func (s *SQLiteStorage) ResetCounters(ctx context.Context) (int64, error) {
+	var reset int64
+
+	err := withSQLiteRetry(func() error {
+		res, err := s.db.ExecContext(ctx, "UPDATE metric_counters SET value = 0, updated_at = $1;", time.Now().UnixNano())
+		if err != nil {
+			return fmt.Errorf("db.ExecContext: %w", err)
+		}
+
+		reset, err = res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("res.RowsAffected: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return reset, nil
+}
+
+// LoadData is a stub to keep compatibility with Storage interface.
+func (s *SQLiteStorage) LoadData(_ context.Context, _ map[string]Metric) error {
+	return nil
+}
+
+// withSQLiteRetry retries the SQLite operations in case of retryable errors.
+func withSQLiteRetry(operation func() error) error {
+	return retry(isSQLiteRetryableError, operation)
+}
+
+// isSQLiteRetryableError checks if the SQLite error is retryable, which is
+// the case when the database is locked by another connection for longer
+// than the busy timeout.
+func isSQLiteRetryableError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+			return true
+		}
+	}
+
+	return false
+}
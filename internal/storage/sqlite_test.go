@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andymarkow/go-metrics-collector/internal/errormsg"
+	"github.com/andymarkow/go-metrics-collector/internal/models"
+)
+
+// newTestSQLiteStorage returns a bootstrapped SQLiteStorage with the database
+// file in a temporary directory.
+func newTestSQLiteStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+
+	strg, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, strg.Close())
+	})
+
+	require.NoError(t, strg.Bootstrap(context.Background()))
+
+	return strg
+}
+
+func TestSQLiteStorageUpsert(t *testing.T) {
+	ctx := context.Background()
+
+	strg := newTestSQLiteStorage(t)
+
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 1))
+	require.NoError(t, strg.SetCounter(ctx, "testCounter", 2))
+	require.NoError(t, strg.SetGauge(ctx, "testGauge", 1.5))
+	require.NoError(t, strg.SetGauge(ctx, "testGauge", 2.5))
+	require.NoError(t, strg.SetGaugeMax(ctx, "testGauge", 0.5))
+
+	cnt, err := strg.GetCounter(ctx, "testCounter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), cnt)
+
+	gauge, err := strg.GetGauge(ctx, "testGauge")
+	require.NoError(t, err)
+	assert.InDelta(t, 2.5, gauge, 0)
+
+	_, err = strg.GetCounter(ctx, "nonexisting")
+	require.ErrorIs(t, err, ErrMetricNotFound)
+
+	_, err = strg.GetGauge(ctx, "nonexisting")
+	require.ErrorIs(t, err, ErrMetricNotFound)
+}
+
+func TestSQLiteStorageSetMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	strg := newTestSQLiteStorage(t)
+
+	delta := int64(5)
+	value := 1.25
+
+	require.NoError(t, strg.SetMetrics(ctx, []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "HeapAlloc", MType: "gauge", Value: &value},
+	}))
+
+	// The batch with an unsupported metric is not applied partially.
+	err := strg.SetMetrics(ctx, []models.Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Latency", MType: "histogram", Value: &value},
+	})
+	require.ErrorIs(t, err, ErrHistogramsNotSupported)
+
+	err = strg.SetMetrics(ctx, []models.Metrics{{ID: "HeapAlloc", MType: "gauge"}})
+	require.ErrorIs(t, err, errormsg.ErrMetricEmptyValue)
+
+	data, err := strg.GetAllMetrics(ctx)
+	require.NoError(t, err)
+
+	require.Len(t, data, 2)
+	assert.Equal(t, int64(10), data["PollCount"].Value)
+	assert.EqualValues(t, "counter", data["PollCount"].Type)
+	assert.InDelta(t, 1.25, data["HeapAlloc"].Value, 0)
+	assert.WithinDuration(t, time.Now(), data["HeapAlloc"].UpdatedAt, time.Minute)
+}
+
+func TestSQLiteStorageGetMetricsByPrefix(t *testing.T) {
+	ctx := context.Background()
+
+	strg := newTestSQLiteStorage(t)
+
+	require.NoError(t, strg.SetGauge(ctx, "HeapAlloc", 1))
+	require.NoError(t, strg.SetGauge(ctx, "heapObjects", 2))
+	require.NoError(t, strg.SetGauge(ctx, "Heap%Idle", 3))
+	require.NoError(t, strg.SetCounter(ctx, "HeapCount", 4))
+
+	data, err := strg.GetMetricsByPrefix(ctx, "Heap")
+	require.NoError(t, err)
+
+	assert.Len(t, data, 3)
+	assert.NotContains(t, data, "heapObjects")
+
+	data, err = strg.GetMetricsByPrefix(ctx, "Heap%")
+	require.NoError(t, err)
+
+	assert.Len(t, data, 1)
+	assert.Contains(t, data, "Heap%Idle")
+}
+
+func TestSQLiteStorageDeleteAndReset(t *testing.T) {
+	ctx := context.Background()
+
+	strg := newTestSQLiteStorage(t)
+
+	require.NoError(t, strg.SetCounter(ctx, "PollCount", 3))
+	require.NoError(t, strg.SetCounter(ctx, "Requests", 7))
+	require.NoError(t, strg.SetGauge(ctx, "HeapAlloc", 1.5))
+
+	reset, err := strg.ResetCounters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), reset)
+
+	cnt, err := strg.GetCounter(ctx, "Requests")
+	require.NoError(t, err)
+	assert.Zero(t, cnt)
+
+	require.NoError(t, strg.DeleteMetric(ctx, "gauge", "HeapAlloc"))
+	require.ErrorIs(t, strg.DeleteMetric(ctx, "gauge", "HeapAlloc"), ErrMetricNotFound)
+
+	stale, err := strg.GetStaleMetrics(ctx, -time.Minute)
+	require.NoError(t, err)
+	assert.Len(t, stale, 2)
+
+	stale, err = strg.GetStaleMetrics(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, stale)
+}
+
+func TestSQLiteStoragePingAndHealthCheck(t *testing.T) {
+	ctx := context.Background()
+
+	strg, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+
+	require.NoError(t, strg.Ping(ctx))
+	require.NoError(t, strg.HealthCheck(ctx))
+
+	require.NoError(t, strg.Close())
+
+	// The closed database is reported unhealthy without retries.
+	require.Error(t, strg.HealthCheck(ctx))
+}
+
+func TestIsSQLiteRetryableError(t *testing.T) {
+	ctx := context.Background()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "metrics.db")
+
+	// The connection holds the write lock of the database.
+	locker, err := sql.Open("sqlite", dsn)
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, locker.Close())
+	}()
+
+	conn, err := locker.Conn(ctx)
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, conn.Close())
+	}()
+
+	_, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE")
+	require.NoError(t, err)
+
+	// Another connection without the busy timeout fails to take the lock.
+	db, err := sql.Open("sqlite", dsn)
+	require.NoError(t, err)
+
+	defer func() {
+		require.NoError(t, db.Close())
+	}()
+
+	_, err = db.ExecContext(ctx, "BEGIN IMMEDIATE")
+	require.Error(t, err)
+
+	assert.True(t, isSQLiteRetryableError(fmt.Errorf("db.ExecContext: %w", err)))
+
+	// The PostgreSQL retryable errors are not retried with SQLite.
+	assert.False(t, isSQLiteRetryableError(fmt.Errorf("dial: %w", syscall.ECONNREFUSED)))
+	assert.False(t, isSQLiteRetryableError(sql.ErrNoRows))
+}
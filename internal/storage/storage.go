@@ -2,8 +2,16 @@
 package storage
 
 import (
+	"cmp"
 	"context"
 	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
 
 	"github.com/andymarkow/go-metrics-collector/internal/models"
 )
@@ -12,20 +20,184 @@ var (
 	ErrMetricNotFound     = errors.New("metric not found")
 	ErrMetricIsNotCounter = errors.New("metric is not counter")
 	ErrMetricIsNotGauge   = errors.New("metric is not gauge")
+
+	ErrMetricIsNotHistogram   = errors.New("metric is not histogram")
+	ErrHistogramsNotSupported = errors.New("histograms are not supported by the storage")
+
+	ErrStaleMetricsNotSupported = errors.New("stale metrics are not supported by the storage")
 )
 
 type Storage interface {
 	GetAllMetrics(ctx context.Context) (map[string]Metric, error)
+	GetMetricsByPrefix(ctx context.Context, prefix string) (map[string]Metric, error)
+	GetStaleMetrics(ctx context.Context, olderThan time.Duration) (map[string]Metric, error)
 	GetCounter(ctx context.Context, name string) (int64, error)
 	SetCounter(ctx context.Context, name string, value int64) error
 	GetGauge(ctx context.Context, name string) (float64, error)
 	SetGauge(ctx context.Context, name string, value float64) error
+	SetGaugeMax(ctx context.Context, name string, value float64) error
+	ObserveHistogram(ctx context.Context, name string, value float64) error
+	GetHistogram(ctx context.Context, name string) (HistogramValue, error)
 	SetMetrics(ctx context.Context, metrics []models.Metrics) error
+	DeleteMetric(ctx context.Context, metricType, name string) error
+	ResetCounters(ctx context.Context) (int64, error)
 	LoadData(ctx context.Context, data map[string]Metric) error
 	Ping(ctx context.Context) error
+	HealthCheck(ctx context.Context) error
 	Close() error
 }
 
-func NewStorage(strg Storage) Storage {
-	return strg
+// NewStorage returns the storage recording the time of its successful writes.
+func NewStorage(strg Storage) *WriteTrackingStorage {
+	s := &WriteTrackingStorage{Storage: strg}
+	s.recordWrite()
+
+	return s
+}
+
+// NamedMetric is a metric with its name.
+type NamedMetric struct {
+	Name string
+	Metric
+}
+
+// SortedMetrics returns the metrics sorted by name and type, so that the read
+// endpoints render them in the same order across calls.
+func SortedMetrics(data map[string]Metric) []NamedMetric {
+	metrics := make([]NamedMetric, 0, len(data))
+
+	for name, metric := range data {
+		metrics = append(metrics, NamedMetric{Name: name, Metric: metric})
+	}
+
+	slices.SortFunc(metrics, func(a, b NamedMetric) int {
+		return cmp.Or(
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.Type, b.Type),
+		)
+	})
+
+	return metrics
+}
+
+// validateMetrics checks that the metrics have the values of their types
+// set, so that the storages do not dereference nil pointers. The name length
+// is limited by the handlers, so it is not limited here.
+func validateMetrics(metrics []models.Metrics) error {
+	for _, metric := range metrics {
+		if err := metric.ValidateUpdate(0); err != nil {
+			return fmt.Errorf("invalid metric (%s): %w", metric.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// DefaultHealthCheckTimeout is the default time limit of the storage
+// health check.
+const DefaultHealthCheckTimeout = 1 * time.Second
+
+// options represents the storage backends options.
+type options struct {
+	log     *zap.Logger
+	buckets []float64
+
+	// The database connection pool settings.
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxIdleTime time.Duration
+	connMaxLifetime time.Duration
+
+	healthCheckTimeout time.Duration
+
+	tracerProvider trace.TracerProvider
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		log:             zap.NewNop(),
+		buckets:         DefaultHistogramBuckets,
+		maxOpenConns:    10,
+		maxIdleConns:    5,
+		connMaxIdleTime: 180 * time.Second,
+		connMaxLifetime: 3600 * time.Second,
+
+		healthCheckTimeout: DefaultHealthCheckTimeout,
+
+		tracerProvider: noop.NewTracerProvider(),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// Option is a storage backend option.
+type Option func(o *options)
+
+// WithLogger is a storage backend option that sets logger.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *options) {
+		o.log = logger
+	}
+}
+
+// WithHistogramBuckets is a storage backend option that sets the histograms
+// buckets upper bounds.
+func WithHistogramBuckets(buckets []float64) Option {
+	return func(o *options) {
+		o.buckets = slices.Clone(buckets)
+		slices.Sort(o.buckets)
+	}
+}
+
+// WithMaxOpenConns is a storage backend option that sets the maximum number
+// of open connections to the database.
+func WithMaxOpenConns(n int) Option {
+	return func(o *options) {
+		o.maxOpenConns = n
+	}
+}
+
+// WithMaxIdleConns is a storage backend option that sets the maximum number
+// of idle connections kept in the database connection pool.
+func WithMaxIdleConns(n int) Option {
+	return func(o *options) {
+		o.maxIdleConns = n
+	}
+}
+
+// WithConnMaxIdleTime is a storage backend option that sets the maximum time
+// a database connection may be idle before it is closed.
+func WithConnMaxIdleTime(d time.Duration) Option {
+	return func(o *options) {
+		o.connMaxIdleTime = d
+	}
+}
+
+// WithConnMaxLifetime is a storage backend option that sets the maximum time
+// a database connection may be reused.
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(o *options) {
+		o.connMaxLifetime = d
+	}
+}
+
+// WithHealthCheckTimeout is a storage backend option that sets the time limit
+// of the health check, so the readiness probes fail fast during an outage.
+func WithHealthCheckTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.healthCheckTimeout = d
+	}
+}
+
+// WithTracerProvider is a storage backend option that sets the tracer provider
+// the storage operations spans are created with. The operations are not traced
+// by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
 }
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortedMetrics(t *testing.T) {
+	data := map[string]Metric{
+		"PollCount":  {Type: "counter", Value: CounterValue(1)},
+		"Alloc":      {Type: "gauge", Value: GaugeValue(2)},
+		"HeapAlloc":  {Type: "gauge", Value: GaugeValue(3)},
+		"RandomSeed": {Type: "gauge", Value: GaugeValue(4)},
+	}
+
+	want := []NamedMetric{
+		{Name: "Alloc", Metric: data["Alloc"]},
+		{Name: "HeapAlloc", Metric: data["HeapAlloc"]},
+		{Name: "PollCount", Metric: data["PollCount"]},
+		{Name: "RandomSeed", Metric: data["RandomSeed"]},
+	}
+
+	for range 10 {
+		assert.Equal(t, want, SortedMetrics(data))
+	}
+
+	assert.Empty(t, SortedMetrics(nil))
+}
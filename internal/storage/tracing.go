@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the storage backends tracer.
+const tracerName = "github.com/andymarkow/go-metrics-collector/internal/storage"
+
+// startSpan starts the child span of the span in ctx named after the storage
+// operation, e.g. "storage.SetMetrics". The returned context carries the span,
+// so the database calls made with it are traced as a part of the operation.
+func startSpan(ctx context.Context, tracer trace.Tracer, dbSystem, operation string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "storage."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", dbSystem),
+			attribute.String("db.operation.name", operation),
+		),
+	)
+}
+
+// spanError records the error on the span and returns the error as is.
+func spanError(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+
+	return err
+}